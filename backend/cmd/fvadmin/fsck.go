@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/db"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+)
+
+// runFsck exits 0 when the store is consistent or was repaired, 1 when
+// problems were found and left in place (including missing and corrupt blobs
+// repaired without -drop-missing), and 2 on errors.
+func runFsck(args []string) int {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "fix what is found instead of only reporting it")
+	dropMissing := fs.Bool("drop-missing", false, "with -repair, also delete every user file whose content is missing or corrupt")
+	verify := fs.Bool("verify", true, "re-hash every blob (reads all content)")
+	minAge := fs.Duration("min-age", time.Hour, "ignore blobs and tmp files modified more recently than this")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+
+	blobs := setup()
+	report, err := storage.Fsck(context.Background(), db.DB, blobs, storage.FsckOptions{
+		Repair:      *repair,
+		DropMissing: *dropMissing,
+		Verify:      *verify,
+		MinAge:      *minAge,
+	})
+	if report != nil {
+		if *asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(report)
+		} else {
+			printReport(report)
+		}
+	}
+	if err != nil {
+		log.Printf("fsck: %v", err)
+		return 2
+	}
+	if !report.Resolved() {
+		return 1
+	}
+	return 0
+}
+
+func printReport(r *storage.FsckReport) {
+	verb := "found"
+	if r.Repaired {
+		verb = "repaired"
+	}
+	for _, key := range r.OrphanBlobs {
+		fmt.Printf("orphan blob: %s\n", key)
+	}
+	for _, o := range r.MissingBlobs {
+		fmt.Printf("missing blob: %s (file_object %s)\n", o.StoragePath, o.FileObjectID)
+	}
+	for _, o := range r.CorruptBlobs {
+		fmt.Printf("corrupt blob: %s (file_object %s): %s\n", o.StoragePath, o.FileObjectID, o.Detail)
+	}
+	if r.Repaired && !r.LostDropped && len(r.MissingBlobs)+len(r.CorruptBlobs) > 0 {
+		fmt.Println("missing and corrupt blobs were left in place; -drop-missing deletes the files that use them")
+	}
+	for _, m := range r.RefCountMismatches {
+		fmt.Printf("ref_count mismatch: file_object %s has %d, actual %d\n", m.FileObjectID, m.RefCount, m.Actual)
+	}
+	for _, p := range r.StaleTmpFiles {
+		fmt.Printf("stale tmp file: %s\n", p)
+	}
+	if r.Clean() {
+		fmt.Println("no problems found")
+		return
+	}
+	fmt.Printf("%s: %d orphan blobs, %d missing blobs, %d corrupt blobs, %d ref_count mismatches, %d stale tmp files\n",
+		verb, len(r.OrphanBlobs), len(r.MissingBlobs), len(r.CorruptBlobs), len(r.RefCountMismatches), len(r.StaleTmpFiles))
+}
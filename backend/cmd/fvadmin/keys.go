@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+)
+
+// runGenSigningKey writes a new token signing key to <dir>/<kid>.pem and
+// prints its kid. The server picks it up on its next start; it only signs
+// once JWT_SIGNING_KID names it.
+func runGenSigningKey(args []string) int {
+	loadEnv()
+	fs := flag.NewFlagSet("gen-signing-key", flag.ExitOnError)
+	dir := fs.String("dir", os.Getenv("JWT_KEYS_DIR"), "key directory (default $JWT_KEYS_DIR)")
+	alg := fs.String("alg", auth.AlgEdDSA, "EdDSA or RS256")
+	kid := fs.String("kid", time.Now().UTC().Format("20060102-150405"), "key id, also the file name")
+	fs.Parse(args)
+
+	if *dir == "" {
+		log.Print("gen-signing-key: -dir or JWT_KEYS_DIR is required")
+		return 2
+	}
+	key, err := auth.GenerateSigningKey(*alg)
+	if err != nil {
+		log.Printf("gen-signing-key: %v", err)
+		return 2
+	}
+	if err := os.MkdirAll(*dir, 0o700); err != nil {
+		log.Printf("gen-signing-key: %v", err)
+		return 2
+	}
+	// O_EXCL: never overwrite a key that may still be verifying tokens
+	f, err := os.OpenFile(filepath.Join(*dir, *kid+".pem"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		log.Printf("gen-signing-key: %v", err)
+		return 2
+	}
+	defer f.Close()
+	if _, err := f.Write(key); err != nil {
+		log.Printf("gen-signing-key: %v", err)
+		return 2
+	}
+	fmt.Println(*kid)
+	return 0
+}
@@ -0,0 +1,74 @@
+// Command fvadmin runs maintenance tasks against a FileVault deployment. It
+// reads the same environment (DATABASE_URL, STORAGE_BACKEND, JWT_KEYS_DIR,
+// ...) as the server.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+	"github.com/rishit911/file_vault_proj-backend/internal/db"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+var commands = []command{
+	{"fsck", "check that the database and blob store agree, optionally repairing", runFsck},
+	{"gen-signing-key", "create a token signing key for JWT_KEYS_DIR", runGenSigningKey},
+	{"create-admin", "create the first admin account, or promote an existing one", runCreateAdmin},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: fvadmin <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", c.name, c.usage)
+	}
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			os.Exit(c.run(os.Args[2:]))
+		}
+	}
+	usage()
+	os.Exit(2)
+}
+
+// loadEnv loads .env the way the server does.
+func loadEnv() {
+	if err := godotenv.Load(".env"); err != nil {
+		_ = godotenv.Load("backend/.env")
+	}
+}
+
+// connectDB loads .env and opens the database.
+func connectDB() {
+	loadEnv()
+	if err := db.ConnectFromEnv(); err != nil {
+		log.Fatalf("db connect failed: %v", err)
+	}
+}
+
+// setup loads .env and opens the database and blob store.
+func setup() storage.BlobStore {
+	connectDB()
+	blobs, err := storage.NewBlobStoreFromEnv()
+	if err != nil {
+		log.Fatalf("blob store init failed: %v", err)
+	}
+	return blobs
+}
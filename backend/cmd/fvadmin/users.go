@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/db"
+	"github.com/rishit911/file_vault_proj-backend/internal/users"
+)
+
+// runCreateAdmin creates an admin account with a verified email address and
+// prints its id. The password is read from the first line of stdin, so it
+// stays out of the shell history and the process list. With -promote the
+// existing account with the email becomes an admin instead.
+func runCreateAdmin(args []string) int {
+	fs := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	email := fs.String("email", "", "email address of the admin")
+	promote := fs.Bool("promote", false, "make the existing account with -email an admin")
+	fs.Parse(args)
+
+	if *email == "" {
+		log.Print("create-admin: -email is required")
+		return 2
+	}
+	connectDB()
+	ctx := context.Background()
+
+	if *promote {
+		id, err := users.Promote(ctx, db.DB, *email)
+		if err != nil {
+			log.Printf("create-admin: %v", err)
+			return 2
+		}
+		fmt.Println(id)
+		return 0
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		log.Printf("create-admin: read password: %v", err)
+		return 2
+	}
+	id, err := users.CreateAdmin(ctx, db.DB, *email, strings.TrimRight(line, "\r\n"))
+	if err != nil {
+		log.Printf("create-admin: %v", err)
+		return 2
+	}
+	fmt.Println(id)
+	return 0
+}
@@ -5,17 +5,24 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
 	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/joho/godotenv"
 	"github.com/rishit911/file_vault_proj-backend/graph"
 	"github.com/rishit911/file_vault_proj-backend/graph/generated"
 	"github.com/rishit911/file_vault_proj-backend/internal/auth"
 	"github.com/rishit911/file_vault_proj-backend/internal/db"
+	"github.com/rishit911/file_vault_proj-backend/internal/mail"
+	"github.com/rishit911/file_vault_proj-backend/internal/oidc"
 	"github.com/rishit911/file_vault_proj-backend/internal/server"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+	"github.com/vektah/gqlparser/v2/ast"
 	"golang.org/x/time/rate"
 )
 
@@ -28,6 +35,12 @@ func main() {
 		}
 	}
 
+	keys, err := auth.LoadKeyringFromEnv()
+	if err != nil {
+		log.Fatalf("jwt keys: %v", err)
+	}
+	auth.SetKeyring(keys)
+
 	// Connect to DB
 	log.Println("Connecting to DB...")
 	if err := db.ConnectFromEnv(); err != nil {
@@ -35,6 +48,11 @@ func main() {
 	}
 	log.Println("DB connected")
 
+	blobs, err := storage.NewBlobStoreFromEnv()
+	if err != nil {
+		log.Fatalf("blob store init failed: %v", err)
+	}
+
 	// Initialize rate limiter store (2 requests per second, burst of 5)
 	rateLimiter := server.NewRateLimiterStore(rate.Limit(2), 5)
 
@@ -45,16 +63,108 @@ func main() {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("OK"))
 	})
-	mux.HandleFunc("/api/v1/auth/register", server.RegisterHandler(db.DB))
-	mux.HandleFunc("/api/v1/auth/login", server.LoginHandler(db.DB))
+	mailSender, err := mail.NewSenderFromEnv()
+	if err != nil {
+		log.Fatalf("mailer init failed: %v", err)
+	}
+
+	// public keys for services that verify vault tokens
+	mux.HandleFunc("GET /.well-known/jwks.json", server.JWKSHandler())
+	mux.HandleFunc("/api/v1/auth/register", server.RegisterHandler(db.DB, mailSender))
+	mux.Handle("/api/v1/auth/login", server.RateLimitMiddleware(rateLimiter, server.LoginHandler(db.DB)))
+	mux.HandleFunc("POST /api/v1/auth/refresh", server.RefreshHandler(db.DB))
+	mux.HandleFunc("POST /api/v1/auth/logout", server.LogoutHandler(db.DB))
+	mux.Handle("POST /api/v1/auth/mfa/verify", server.RateLimitMiddleware(rateLimiter, server.MFAVerifyHandler(db.DB)))
+	mux.HandleFunc("POST /api/v1/auth/mfa/enroll", server.MFAEnrollHandler(db.DB))
+	mux.HandleFunc("GET /api/v1/auth/verify-email", server.VerifyEmailHandler(db.DB))
+	mux.HandleFunc("POST /api/v1/auth/verify-email", server.VerifyEmailHandler(db.DB))
+	// rate limited: each request sends an email
+	mux.Handle("POST /api/v1/auth/forgot-password", server.RateLimitMiddleware(rateLimiter, server.ForgotPasswordHandler(db.DB, mailSender)))
+	mux.HandleFunc("POST /api/v1/auth/reset-password", server.ResetPasswordHandler(db.DB))
+
+	// OpenID Connect single sign-on, when OIDC_ISSUER is set
+	sso, err := oidc.NewProviderFromEnv()
+	if err != nil {
+		log.Fatalf("oidc init failed: %v", err)
+	}
+	if sso != nil {
+		mux.HandleFunc("GET /api/v1/auth/oidc/login", server.OIDCLoginHandler(db.DB, sso))
+		mux.HandleFunc("GET /api/v1/auth/oidc/callback", server.OIDCCallbackHandler(db.DB, sso))
+		log.Printf("single sign-on enabled with %s", sso.Issuer())
+	}
 
 	// protected routes with AuthMiddleware
-	mux.Handle("/api/v1/files/upload", server.AuthMiddleware(server.UploadHandler(db.DB)))
-	mux.Handle("/api/v1/files/register", server.AuthMiddleware(server.RegisterFileHandler(db.DB)))
-	mux.Handle("/api/v1/files", server.AuthMiddleware(server.ListFilesHandler(db.DB))) // GET lists user files
+	mux.Handle("/api/v1/files/upload", server.AuthMiddleware(db.DB, auth.ScopeFilesWrite, server.UploadHandler(db.DB, blobs)))
+	mux.Handle("/api/v1/files/register", server.AuthMiddleware(db.DB, auth.ScopeFilesWrite, server.RegisterFileHandler(db.DB)))
+	mux.Handle("/api/v1/files", server.AuthMiddleware(db.DB, auth.ScopeFilesRead, server.ListFilesHandler(db.DB))) // GET lists user files
 
 	// delete - pattern: /api/v1/files/{id}
-	mux.Handle("/api/v1/files/", server.AuthMiddleware(server.DeleteFileHandler(db.DB)))
+	mux.Handle("/api/v1/files/", server.AuthMiddleware(db.DB, auth.ScopeFilesWrite, server.DeleteFileHandler(db.DB)))
+
+	// download - GET/HEAD /api/v1/files/{id}/content (Range + conditional requests)
+	mux.Handle("GET /api/v1/files/{id}/content", server.AuthMiddleware(db.DB, auth.ScopeFilesRead, server.DownloadHandler(db.DB, blobs)))
+
+	// trash - DELETE /api/v1/files/{id} moves files here
+	mux.Handle("GET /api/v1/trash", server.AuthMiddleware(db.DB, auth.ScopeFilesRead, server.ListTrashHandler(db.DB)))
+	mux.Handle("DELETE /api/v1/trash", server.AuthMiddleware(db.DB, auth.ScopeFilesWrite, server.EmptyTrashHandler(db.DB)))
+	mux.Handle("POST /api/v1/trash/{id}/restore", server.AuthMiddleware(db.DB, auth.ScopeFilesWrite, server.RestoreFileHandler(db.DB)))
+	mux.Handle("DELETE /api/v1/trash/{id}", server.AuthMiddleware(db.DB, auth.ScopeFilesWrite, server.PurgeFileHandler(db.DB)))
+
+	// public share links; unauthenticated, so rate limited per client
+	shareLimiter := server.NewRateLimiterStore(rate.Limit(2), 10)
+	mux.Handle("GET /s/{token}", server.RateLimitMiddleware(shareLimiter, server.ShareDownloadHandler(db.DB, blobs)))
+
+	// tus resumable uploads; OPTIONS is the unauthenticated discovery request
+	mux.HandleFunc("OPTIONS /api/v1/uploads/", server.TusOptionsHandler)
+	mux.Handle("/api/v1/uploads/", server.AuthMiddleware(db.DB, auth.ScopeFilesWrite, server.TusHandler(db.DB, blobs)))
+
+	// drop abandoned tus uploads and their partial data
+	go func() {
+		for range time.Tick(time.Hour) {
+			if n, err := server.PurgeExpiredUploads(context.Background(), db.DB, blobs); err != nil {
+				log.Printf("purge expired uploads: %v", err)
+			} else if n > 0 {
+				log.Printf("purged %d expired uploads", n)
+			}
+		}
+	}()
+
+	// delete blobs whose last reference was dropped more than GC_GRACE_PERIOD ago
+	gcGrace := getEnvDuration("GC_GRACE_PERIOD", 24*time.Hour)
+	go func() {
+		for range time.Tick(getEnvDuration("GC_SWEEP_INTERVAL", time.Hour)) {
+			if n, err := storage.SweepGarbage(context.Background(), db.DB, blobs, gcGrace); err != nil {
+				log.Printf("gc sweep: %v", err)
+			} else if n > 0 {
+				log.Printf("gc swept %d unreferenced objects", n)
+			}
+		}
+	}()
+
+	// purge files that have been in the trash for longer than TRASH_RETENTION_DAYS
+	trashRetention := time.Duration(getEnvInt("TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour
+	go func() {
+		for range time.Tick(time.Hour) {
+			if n, err := storage.PurgeExpiredTrash(context.Background(), db.DB, trashRetention); err != nil {
+				log.Printf("purge trash: %v", err)
+			} else if n > 0 {
+				log.Printf("purged %d files from trash", n)
+			}
+		}
+	}()
+
+	// drop sessions that expired or were revoked a while ago, and forgotten
+	// login failure counts
+	go func() {
+		for range time.Tick(time.Hour) {
+			if _, err := auth.PurgeExpiredSessions(context.Background(), db.DB); err != nil {
+				log.Printf("purge sessions: %v", err)
+			}
+			if _, err := auth.PurgeLoginThrottles(context.Background(), db.DB); err != nil {
+				log.Printf("purge login throttles: %v", err)
+			}
+		}
+	}()
 
 	// GraphQL playground & endpoint
 	playgroundHandler := playground.Handler("GraphQL", "/graphql")
@@ -62,34 +172,46 @@ func main() {
 		playgroundHandler.ServeHTTP(w, r)
 	})
 
-	gqlSrv := handler.NewDefaultServer(generated.NewExecutableSchema(generated.Config{
-		Resolvers: &graph.Resolver{DB: db.DB},
+	gqlSrv := handler.New(generated.NewExecutableSchema(generated.Config{
+		Resolvers:  &graph.Resolver{DB: db.DB, Blobs: blobs, Mail: mailSender},
+		Directives: graph.Directives(),
 	}))
-	// GraphQL handler with rate limiting
+	gqlSrv.AddTransport(transport.Options{})
+	gqlSrv.AddTransport(transport.GET{})
+	gqlSrv.AddTransport(transport.POST{})
+	// multipart request spec for the uploadFile/uploadFiles mutations
+	gqlSrv.AddTransport(transport.MultipartForm{
+		MaxUploadSize: 1 << 30,  // 1GB per request
+		MaxMemory:     32 << 20, // larger parts spill to temp files
+	})
+	gqlSrv.SetQueryCache(lru.New[*ast.QueryDocument](1000))
+	gqlSrv.Use(extension.Introspection{})
+	gqlSrv.Use(extension.AutomaticPersistedQuery{Cache: lru.New[string](100)})
+	// personal access tokens only reach the root fields their scopes allow
+	gqlSrv.AroundRootFields(graph.RequireScopes)
+	// GraphQL handler with rate limiting; the directives check each field
+	// against the caller Authenticate attaches, so rate limits count per user
 	graphqlHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Extract JWT token and set user context for GraphQL
-		ctx := r.Context()
-		authHeader := r.Header.Get("Authorization")
-		if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
-			token := strings.TrimPrefix(authHeader, "Bearer ")
-			if userID, err := auth.ParseAndValidateJWT(token); err == nil {
-				ctx = context.WithValue(ctx, "userID", userID)
-			}
-		}
+		ctx := auth.WithDevice(r.Context(), auth.DeviceFromRequest(r))
 		gqlSrv.ServeHTTP(w, r.WithContext(ctx))
 	})
 
-	// Apply rate limiting to GraphQL endpoint
-	mux.Handle("/graphql", server.RateLimitMiddleware(rateLimiter, graphqlHandler))
+	// multipart uploads may take longer than the server ReadTimeout
+	mux.Handle("/graphql", server.MultipartDeadlines(server.Authenticate(db.DB, server.RateLimitMiddleware(rateLimiter, graphqlHandler))))
 
 	// CORS middleware wrapper
 	corsHandler := func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Range, If-None-Match, If-Range, "+
+				"Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata, Upload-Checksum, Upload-Defer-Length, X-Share-Password")
+			w.Header().Set("Access-Control-Expose-Headers", "Content-Disposition, Content-Range, Accept-Ranges, ETag, "+
+				"Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Tus-Checksum-Algorithm, "+
+				"Upload-Offset, Upload-Length, Upload-Metadata, Upload-Expires, X-User-File-Id")
 
-			if r.Method == "OPTIONS" {
+			// answer CORS preflights here; other OPTIONS requests (tus discovery) reach the mux
+			if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
 				w.WriteHeader(http.StatusOK)
 				return
 			}
@@ -118,3 +240,27 @@ func getEnv(k, def string) string {
 	}
 	return def
 }
+
+func getEnvDuration(k string, def time.Duration) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("invalid %s %q: want a positive duration such as 24h", k, v)
+	}
+	return d
+}
+
+func getEnvInt(k string, def int) int {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Fatalf("invalid %s %q: want a positive integer", k, v)
+	}
+	return n
+}
@@ -0,0 +1,57 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rishit911/file_vault_proj-backend/graph/model"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+)
+
+func (r *userResolver) EmailVerified(ctx context.Context, obj *model.User) (*bool, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, nil
+	}
+	if obj.ID != userID && !auth.IdentityFromContext(ctx).IsAdmin() {
+		return nil, nil
+	}
+
+	var verified bool
+	if err := r.DB.GetContext(ctx, &verified, "SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1", obj.ID); err != nil {
+		return nil, err
+	}
+	return &verified, nil
+}
+
+func (r *mutationResolver) VerifyEmail(ctx context.Context, token string) (bool, error) {
+	if err := auth.VerifyEmail(ctx, r.DB, token); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *mutationResolver) ResendVerificationEmail(ctx context.Context) (bool, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return false, fmt.Errorf("unauthenticated")
+	}
+
+	if err := auth.SendVerificationEmail(ctx, r.DB, r.Mail, userID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *mutationResolver) RequestPasswordReset(ctx context.Context, email string) (bool, error) {
+	// the answer must not depend on whether the account exists
+	auth.RequestPasswordReset(ctx, r.DB, r.Mail, email)
+	return true, nil
+}
+
+func (r *mutationResolver) ResetPassword(ctx context.Context, token string, password string) (bool, error) {
+	if err := auth.ResetPassword(ctx, r.DB, token, password); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,135 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rishit911/file_vault_proj-backend/graph/model"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/authz"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+)
+
+func (r *queryResolver) SharedWithMe(ctx context.Context) ([]*model.AccessGrant, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	grants, err := authz.SharedWith(ctx, r.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	return r.grantModels(ctx, grants)
+}
+
+func (r *queryResolver) AccessGrants(ctx context.Context, userFileID *string, folderID *string) ([]*model.AccessGrant, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	grants, err := authz.ListGrants(ctx, r.DB, userID, userFileID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	return r.grantModels(ctx, grants)
+}
+
+func (r *mutationResolver) ShareWithUser(ctx context.Context, email string, role string, userFileID *string, folderID *string) (*model.AccessGrant, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	g, err := authz.ShareWithUser(ctx, r.DB, userID, userFileID, folderID, email, authz.Role(role))
+	if err != nil {
+		return nil, err
+	}
+	return r.grantModel(ctx, g)
+}
+
+func (r *mutationResolver) UpdateShareRole(ctx context.Context, grantID string, role string) (*model.AccessGrant, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	g, err := authz.UpdateGrantRole(ctx, r.DB, userID, grantID, authz.Role(role))
+	if err != nil {
+		return nil, err
+	}
+	return r.grantModel(ctx, g)
+}
+
+func (r *mutationResolver) Unshare(ctx context.Context, grantID string) (*model.DeletePayload, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	if err := authz.Unshare(ctx, r.DB, userID, grantID); err != nil {
+		return nil, err
+	}
+	return &model.DeletePayload{Success: true}, nil
+}
+
+func (r *Resolver) grantModel(ctx context.Context, g *authz.Grant) (*model.AccessGrant, error) {
+	out, err := r.grantModels(ctx, []authz.Grant{*g})
+	if err != nil {
+		return nil, err
+	}
+	return out[0], nil
+}
+
+func (r *Resolver) grantModels(ctx context.Context, grants []authz.Grant) ([]*model.AccessGrant, error) {
+	var ids []string
+	for _, g := range grants {
+		ids = append(ids, g.GranteeID, g.OwnerID)
+	}
+	users, err := r.loadUsers(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*model.AccessGrant, 0, len(grants))
+	for _, g := range grants {
+		m := &model.AccessGrant{
+			ID:        g.ID,
+			Role:      string(g.Role),
+			Grantee:   users[g.GranteeID],
+			Owner:     users[g.OwnerID],
+			GrantedBy: g.GrantedBy,
+			CreatedAt: g.CreatedAt,
+		}
+		switch {
+		case g.UserFileID != nil:
+			if m.File, err = r.loadUserFile(ctx, g.OwnerID, *g.UserFileID); err != nil {
+				return nil, err
+			}
+		case g.FolderID != nil:
+			f, err := storage.GetFolder(ctx, r.DB, g.OwnerID, *g.FolderID)
+			if err != nil {
+				return nil, err
+			}
+			if m.Folder, err = r.folderModel(ctx, f); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// loadUserFile loads a user file of ownerID as returned by the mutations.
+func (r *Resolver) loadUserFile(ctx context.Context, ownerID, userFileID string) (*model.UserFile, error) {
+	var foID string
+	if err := r.DB.GetContext(ctx, &foID, "SELECT file_object_id FROM user_files WHERE id=$1", userFileID); err != nil {
+		return nil, fmt.Errorf("failed to fetch user file: %v", err)
+	}
+	payload, err := r.registerFilePayload(ownerID, foID, userFileID)
+	if err != nil {
+		return nil, err
+	}
+	return payload.UserFile, nil
+}
@@ -2,25 +2,13 @@ package graph
 
 import (
 	"context"
-	"fmt"
 	"time"
 
 	"github.com/rishit911/file_vault_proj-backend/graph/model"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
 )
 
 func (r *queryResolver) AdminFiles(ctx context.Context, pagination *model.PaginationInput) (*model.FilePage, error) {
-	// Ensure user is admin
-	userID, _ := ctx.Value("userID").(string)
-	if userID == "" {
-		return nil, fmt.Errorf("unauthenticated")
-	}
-
-	var role string
-	err := r.DB.Get(&role, "SELECT role FROM users WHERE id=$1", userID)
-	if err != nil || role != "admin" {
-		return nil, fmt.Errorf("forbidden")
-	}
-
 	// fetch all user_files with pagination (limit/offset)
 	limit := 50
 	offset := 0
@@ -39,6 +27,7 @@ func (r *queryResolver) AdminFiles(ctx context.Context, pagination *model.Pagina
 			uf.filename, 
 			uf.uploaded_at, 
 			uf.visibility,
+			uf.version,
 			fo.id as file_object_id, 
 			fo.hash, 
 			fo.size_bytes, 
@@ -52,6 +41,7 @@ func (r *queryResolver) AdminFiles(ctx context.Context, pagination *model.Pagina
 		FROM user_files uf
 		JOIN file_objects fo ON uf.file_object_id = fo.id
 		JOIN users u ON uf.user_id = u.id
+		WHERE uf.deleted_at IS NULL
 		ORDER BY uf.uploaded_at DESC 
 		LIMIT $1 OFFSET $2`, limit, offset)
 	if err != nil {
@@ -62,6 +52,7 @@ func (r *queryResolver) AdminFiles(ctx context.Context, pagination *model.Pagina
 	var items []*model.UserFile
 	for rows.Next() {
 		var userFileID, filename, visibility string
+		var version int
 		var uploadedAt time.Time
 		var foID, foHash string
 		var sizeBytes int64
@@ -71,7 +62,7 @@ func (r *queryResolver) AdminFiles(ctx context.Context, pagination *model.Pagina
 		var uid, email, userRole string
 		var userCreatedAt time.Time
 
-		err := rows.Scan(&userFileID, &filename, &uploadedAt, &visibility, &foID, &foHash, &sizeBytes, &mimeType, &refCount, &foCreatedAt, &uid, &email, &userRole, &userCreatedAt)
+		err := rows.Scan(&userFileID, &filename, &uploadedAt, &visibility, &version, &foID, &foHash, &sizeBytes, &mimeType, &refCount, &foCreatedAt, &uid, &email, &userRole, &userCreatedAt)
 		if err != nil {
 			continue
 		}
@@ -99,13 +90,66 @@ func (r *queryResolver) AdminFiles(ctx context.Context, pagination *model.Pagina
 			FileObject: fo,
 			Visibility: visibility,
 			UploadedAt: uploadedAt,
+			Version:    version,
 		}
 
 		items = append(items, uf)
 	}
 
 	var total int
-	_ = r.DB.Get(&total, "SELECT COUNT(1) FROM user_files")
+	_ = r.DB.Get(&total, "SELECT COUNT(1) FROM user_files WHERE deleted_at IS NULL")
 
 	return &model.FilePage{Items: items, TotalCount: total}, nil
 }
+
+func (r *queryResolver) AdminFsck(ctx context.Context, verify *bool) (*model.FsckReport, error) {
+	return r.runFsck(ctx, storage.FsckOptions{Verify: verify != nil && *verify})
+}
+
+func (r *mutationResolver) AdminRepairStorage(ctx context.Context, verify *bool, dropMissing *bool) (*model.FsckReport, error) {
+	return r.runFsck(ctx, storage.FsckOptions{
+		Repair:      true,
+		DropMissing: dropMissing != nil && *dropMissing,
+		Verify:      verify != nil && *verify,
+	})
+}
+
+func (r *Resolver) runFsck(ctx context.Context, opts storage.FsckOptions) (*model.FsckReport, error) {
+	report, err := storage.Fsck(ctx, r.DB, r.Blobs, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &model.FsckReport{
+		OrphanBlobs:        append([]string{}, report.OrphanBlobs...),
+		MissingBlobs:       fsckObjects(report.MissingBlobs),
+		CorruptBlobs:       fsckObjects(report.CorruptBlobs),
+		RefCountMismatches: []*model.FsckRefCount{},
+		StaleTmpFiles:      append([]string{}, report.StaleTmpFiles...),
+		Repaired:           report.Repaired,
+		LostDropped:        report.LostDropped,
+	}
+	for _, m := range report.RefCountMismatches {
+		out.RefCountMismatches = append(out.RefCountMismatches, &model.FsckRefCount{
+			FileObjectID: m.FileObjectID,
+			Hash:         m.Hash,
+			RefCount:     m.RefCount,
+			Actual:       m.Actual,
+			PendingGc:    m.PendingGC,
+		})
+	}
+	return out, nil
+}
+
+func fsckObjects(objs []storage.FsckObject) []*model.FsckObject {
+	out := []*model.FsckObject{}
+	for _, o := range objs {
+		fo := &model.FsckObject{FileObjectID: o.FileObjectID, Hash: o.Hash, StoragePath: o.StoragePath}
+		if o.Detail != "" {
+			detail := o.Detail
+			fo.Detail = &detail
+		}
+		out = append(out, fo)
+	}
+	return out
+}
@@ -0,0 +1,85 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/rishit911/file_vault_proj-backend/graph/generated"
+	"github.com/rishit911/file_vault_proj-backend/graph/model"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+)
+
+// Directives implements the authorization directives of the schema against
+// the auth.Identity of the request.
+func Directives() generated.DirectiveRoot {
+	return generated.DirectiveRoot{
+		Auth:          authDirective,
+		HasRole:       hasRoleDirective,
+		HasPermission: hasPermissionDirective,
+		Session:       sessionDirective,
+		Public:        publicDirective,
+	}
+}
+
+// permissionScopes maps Permission to the token scope granting it.
+var permissionScopes = map[model.Permission]string{
+	model.PermissionFilesRead:    auth.ScopeFilesRead,
+	model.PermissionFilesWrite:   auth.ScopeFilesWrite,
+	model.PermissionSharesManage: auth.ScopeSharesManage,
+	model.PermissionAdmin:        auth.ScopeAdmin,
+}
+
+func authDirective(ctx context.Context, obj any, next graphql.Resolver) (any, error) {
+	if auth.IdentityFromContext(ctx) == nil {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+	return next(ctx)
+}
+
+func hasRoleDirective(ctx context.Context, obj any, next graphql.Resolver, role model.Role) (any, error) {
+	id := auth.IdentityFromContext(ctx)
+	if id == nil {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+	if !id.HasRole(strings.ToLower(string(role))) {
+		return nil, fmt.Errorf("forbidden")
+	}
+	return next(ctx)
+}
+
+func hasPermissionDirective(ctx context.Context, obj any, next graphql.Resolver, permission model.Permission) (any, error) {
+	id := auth.IdentityFromContext(ctx)
+	if id == nil {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+	if err := scopeError(id, permissionScopes[permission]); err != nil {
+		return nil, err
+	}
+	return next(ctx)
+}
+
+func sessionDirective(ctx context.Context, obj any, next graphql.Resolver) (any, error) {
+	if id := auth.IdentityFromContext(ctx); id != nil && id.SessionID == "" {
+		return nil, fmt.Errorf("forbidden: not available to access tokens")
+	}
+	return next(ctx)
+}
+
+func publicDirective(ctx context.Context, obj any, next graphql.Resolver) (any, error) {
+	return next(ctx)
+}
+
+// scopeError explains why id cannot use a field needing scope: the scopes
+// of its personal access token, or the read-only access of a user who has
+// not verified their email address.
+func scopeError(id *auth.Identity, scope string) error {
+	switch {
+	case id.Can(scope):
+		return nil
+	case id.SessionID != "":
+		return fmt.Errorf("forbidden: verify your email address first")
+	}
+	return fmt.Errorf("forbidden: token lacks the %s scope", scope)
+}
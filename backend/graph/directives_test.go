@@ -0,0 +1,66 @@
+package graph
+
+import (
+	"context"
+	"testing"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/rishit911/file_vault_proj-backend/graph/model"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+)
+
+func TestDirectives(t *testing.T) {
+	next := func(ctx context.Context) (any, error) { return "ok", nil }
+	user := &auth.Identity{UserID: "u1", Role: auth.RoleUser, SessionID: "s1"}
+	admin := &auth.Identity{UserID: "u2", Role: auth.RoleAdmin, SessionID: "s2"}
+	adminToken := &auth.Identity{UserID: "u2", Role: auth.RoleAdmin, TokenID: "t1", Scopes: auth.Scopes{auth.ScopeFilesRead}}
+	unverified := &auth.Identity{UserID: "u3", Role: auth.RoleUser, SessionID: "s3", Scopes: auth.Scopes{auth.ScopeFilesRead}}
+
+	hasRole := func(ctx context.Context, obj any, next graphql.Resolver) (any, error) {
+		return hasRoleDirective(ctx, obj, next, model.RoleAdmin)
+	}
+	hasPermission := func(ctx context.Context, obj any, next graphql.Resolver) (any, error) {
+		return hasPermissionDirective(ctx, obj, next, model.PermissionAdmin)
+	}
+	cases := []struct {
+		name      string
+		directive func(context.Context, any, graphql.Resolver) (any, error)
+		id        *auth.Identity
+		want      string
+	}{
+		{"auth anonymous", authDirective, nil, "unauthenticated"},
+		{"auth user", authDirective, user, ""},
+		{"hasRole anonymous", hasRole, nil, "unauthenticated"},
+		{"hasRole user", hasRole, user, "forbidden"},
+		{"hasRole admin", hasRole, admin, ""},
+		{"hasPermission anonymous", hasPermission, nil, "unauthenticated"},
+		{"hasPermission session", hasPermission, user, ""},
+		{"hasPermission token", hasPermission, adminToken, "forbidden: token lacks the admin scope"},
+		{"hasPermission unverified", hasPermission, unverified, "forbidden: verify your email address first"},
+		{"session anonymous", sessionDirective, nil, ""},
+		{"session session", sessionDirective, user, ""},
+		{"session token", sessionDirective, adminToken, "forbidden: not available to access tokens"},
+		{"public anonymous", publicDirective, nil, ""},
+	}
+	for _, c := range cases {
+		ctx := context.Background()
+		if c.id != nil {
+			ctx = auth.WithIdentity(ctx, c.id)
+		}
+		res, err := c.directive(ctx, nil, next)
+		switch {
+		case c.want == "" && (err != nil || res != "ok"):
+			t.Errorf("%s: got %v, %v; want it resolved", c.name, res, err)
+		case c.want != "" && (err == nil || err.Error() != c.want):
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.want)
+		}
+	}
+}
+
+func TestPermissionScopes(t *testing.T) {
+	for _, p := range model.AllPermission {
+		if permissionScopes[p] == "" {
+			t.Errorf("permission %s has no scope", p)
+		}
+	}
+}
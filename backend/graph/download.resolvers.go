@@ -0,0 +1,127 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rishit911/file_vault_proj-backend/graph/model"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/authz"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+)
+
+// Downloads is the resolver for the downloads field.
+func (r *userFileResolver) Downloads(ctx context.Context, obj *model.UserFile, pagination *model.PaginationInput) (*model.DownloadEventPage, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+	// owners, co-owners and admins
+	if _, err := authz.RequireFile(ctx, r.DB, userID, obj.ID, authz.Manage); err != nil && !auth.IdentityFromContext(ctx).IsAdmin() {
+		return nil, fmt.Errorf("forbidden")
+	}
+
+	limit, offset := pageBounds(pagination)
+	events, total, err := storage.ListDownloads(ctx, r.DB, storage.DownloadFilter{UserFileID: &obj.ID}, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return r.downloadEventPage(ctx, events, total)
+}
+
+func (r *queryResolver) DownloadEvents(ctx context.Context, filter *model.DownloadEventFilter, pagination *model.PaginationInput) (*model.DownloadEventPage, error) {
+	var f storage.DownloadFilter
+	if filter != nil {
+		f = storage.DownloadFilter{
+			UserFileID: filter.UserFileID,
+			UserID:     filter.DownloaderID,
+			OwnerID:    filter.OwnerID,
+			Source:     filter.Source,
+			From:       filter.DateFrom,
+			To:         filter.DateTo,
+			Completed:  filter.Completed,
+		}
+	}
+	limit, offset := pageBounds(pagination)
+	events, total, err := storage.ListDownloads(ctx, r.DB, f, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return r.downloadEventPage(ctx, events, total)
+}
+
+func (r *Resolver) downloadEventPage(ctx context.Context, events []storage.DownloadEvent, total int) (*model.DownloadEventPage, error) {
+	var ids []string
+	for _, ev := range events {
+		if ev.UserID != nil {
+			ids = append(ids, *ev.UserID)
+		}
+	}
+	users, err := r.loadUsers(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*model.DownloadEvent, len(events))
+	for i, ev := range events {
+		item := &model.DownloadEvent{
+			ID:           ev.ID,
+			UserFileID:   ev.UserFileID,
+			Filename:     ev.Filename,
+			ShareID:      ev.ShareID,
+			Source:       ev.Source,
+			IP:           ev.IP,
+			UserAgent:    ev.UserAgent,
+			BytesSent:    int(ev.BytesSent),
+			Completed:    ev.Completed,
+			DownloadedAt: ev.DownloadedAt,
+		}
+		if ev.UserID != nil {
+			item.Downloader = users[*ev.UserID]
+		}
+		if ev.RangeStart != nil && ev.RangeEnd != nil {
+			start, end := int(*ev.RangeStart), int(*ev.RangeEnd)
+			item.RangeStart, item.RangeEnd = &start, &end
+		}
+		items[i] = item
+	}
+	return &model.DownloadEventPage{Items: items, TotalCount: total}, nil
+}
+
+// loadUsers fetches users by id in one query.
+func (r *Resolver) loadUsers(ctx context.Context, ids []string) (map[string]*model.User, error) {
+	users := map[string]*model.User{}
+	if len(ids) == 0 {
+		return users, nil
+	}
+	var rows []struct {
+		ID        string    `db:"id"`
+		Email     string    `db:"email"`
+		Role      string    `db:"role"`
+		CreatedAt time.Time `db:"created_at"`
+	}
+	err := r.DB.SelectContext(ctx, &rows, "SELECT id, email, role, created_at FROM users WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("load users: %v", err)
+	}
+	for _, u := range rows {
+		users[u.ID] = &model.User{ID: u.ID, Email: u.Email, Role: u.Role, CreatedAt: u.CreatedAt}
+	}
+	return users, nil
+}
+
+// pageBounds applies the PaginationInput defaults (20, 0).
+func pageBounds(p *model.PaginationInput) (int, int) {
+	limit, offset := 20, 0
+	if p != nil {
+		if p.Limit != nil {
+			limit = *p.Limit
+		}
+		if p.Offset != nil {
+			offset = *p.Offset
+		}
+	}
+	return limit, offset
+}
@@ -6,9 +6,14 @@ import (
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/99designs/gqlgen/graphql"
 	"github.com/lib/pq"
 	"github.com/rishit911/file_vault_proj-backend/graph/model"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/authz"
+	"github.com/rishit911/file_vault_proj-backend/internal/orgs"
+	"github.com/rishit911/file_vault_proj-backend/internal/server"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
 )
 
 // helper to build filter SQL clauses
@@ -61,12 +66,44 @@ func buildFilterSQL(f *model.FileFilter, args *[]interface{}) string {
 	return " AND " + strings.Join(parts, " AND ")
 }
 
-func (r *queryResolver) Files(ctx context.Context, filter *model.FileFilter, pagination *model.PaginationInput) (*model.FilePage, error) {
-	userID, _ := ctx.Value("userID").(string)
+func (r *queryResolver) Files(ctx context.Context, filter *model.FileFilter, pagination *model.PaginationInput, folderID *string, path *string, orgID *string) (*model.FilePage, error) {
+	userID := auth.UserIDFromContext(ctx)
 	if userID == "" {
 		return &model.FilePage{Items: []*model.UserFile{}, TotalCount: 0}, nil
 	}
 
+	// restrict to one folder if asked to; nil folder means the root. A
+	// folder shared with the caller lists its owner's files.
+	ownerID := userID
+	inFolder := folderID != nil || path != nil
+	if folderID != nil {
+		acc, err := authz.RequireFolder(ctx, r.DB, userID, *folderID, authz.Read)
+		if err != nil {
+			return nil, err
+		}
+		ownerID = acc.OwnerID
+	} else if path != nil {
+		id, err := storage.ResolveFolderPath(ctx, r.DB, userID, *path)
+		if err != nil {
+			return nil, err
+		}
+		folderID = id
+	}
+	if orgID != nil {
+		if _, err := orgs.MemberRole(ctx, r.DB, userID, *orgID); err != nil {
+			return nil, err
+		}
+	}
+	folderSQL := func(args *[]interface{}) string {
+		if !inFolder {
+			return ""
+		}
+		*args = append(*args, folderID)
+		return fmt.Sprintf(" AND uf.folder_id IS NOT DISTINCT FROM $%d", len(*args))
+	}
+	// the org's files, or else the owner's own (not their org files)
+	ownerSQL := `CASE WHEN $2::uuid IS NULL THEN uf.user_id=$1 AND uf.org_id IS NULL ELSE uf.org_id=$2 END`
+
 	limit := 20
 	offset := 0
 	if pagination != nil {
@@ -79,8 +116,9 @@ func (r *queryResolver) Files(ctx context.Context, filter *model.FileFilter, pag
 	}
 
 	// First get total count (simpler query)
-	countArgs := []interface{}{userID}
-	countSql := `SELECT COUNT(1) FROM user_files uf JOIN file_objects fo ON uf.file_object_id=fo.id WHERE uf.user_id=$1` + buildFilterSQL(filter, &countArgs)
+	countArgs := []interface{}{ownerID, orgID}
+	countSql := `SELECT COUNT(1) FROM user_files uf JOIN file_objects fo ON uf.file_object_id=fo.id WHERE ` + ownerSQL + ` AND uf.deleted_at IS NULL` + buildFilterSQL(filter, &countArgs)
+	countSql += folderSQL(&countArgs)
 	var total int
 	err := r.DB.Get(&total, countSql, countArgs...)
 	if err != nil {
@@ -93,12 +131,16 @@ func (r *queryResolver) Files(ctx context.Context, filter *model.FileFilter, pag
 	}
 
 	// Build main query with proper parameter indexing
-	args := []interface{}{userID}
+	args := []interface{}{ownerID, orgID}
 	sql := `SELECT
 		uf.id,
+		uf.user_id,
 		uf.filename,
 		uf.uploaded_at,
 		uf.visibility,
+		uf.folder_id,
+		uf.org_id,
+		uf.version,
 		fo.id,
 		fo.hash,
 		fo.storage_path,
@@ -108,10 +150,11 @@ func (r *queryResolver) Files(ctx context.Context, filter *model.FileFilter, pag
 		fo.created_at
 	FROM user_files uf
 	JOIN file_objects fo ON uf.file_object_id = fo.id
-	WHERE uf.user_id = $1`
+	WHERE ` + ownerSQL + ` AND uf.deleted_at IS NULL`
 
 	// Apply filters
 	sql += buildFilterSQL(filter, &args)
+	sql += folderSQL(&args)
 
 	// Add pagination
 	sql += fmt.Sprintf(" ORDER BY uf.uploaded_at DESC LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
@@ -127,9 +170,13 @@ func (r *queryResolver) Files(ctx context.Context, filter *model.FileFilter, pag
 	for rows.Next() {
 		var uf struct {
 			ID         string    `db:"id"`
+			UserID     string    `db:"user_id"`
 			Filename   string    `db:"filename"`
 			UploadedAt time.Time `db:"uploaded_at"`
 			Visibility string    `db:"visibility"`
+			FolderID   *string   `db:"folder_id"`
+			OrgID      *string   `db:"org_id"`
+			Version    int       `db:"version"`
 		}
 		var fo struct {
 			ID          string    `db:"id"`
@@ -142,7 +189,7 @@ func (r *queryResolver) Files(ctx context.Context, filter *model.FileFilter, pag
 		}
 
 		err := rows.Scan(
-			&uf.ID, &uf.Filename, &uf.UploadedAt, &uf.Visibility,
+			&uf.ID, &uf.UserID, &uf.Filename, &uf.UploadedAt, &uf.Visibility, &uf.FolderID, &uf.OrgID, &uf.Version,
 			&fo.ID, &fo.Hash, &fo.StoragePath, &fo.SizeBytes, &fo.MimeType, &fo.RefCount, &fo.CreatedAt,
 		)
 		if err != nil {
@@ -151,7 +198,7 @@ func (r *queryResolver) Files(ctx context.Context, filter *model.FileFilter, pag
 
 		userFile := &model.UserFile{
 			ID:   uf.ID,
-			User: &model.User{ID: userID},
+			User: &model.User{ID: uf.UserID},
 			FileObject: &model.FileObject{
 				ID:          fo.ID,
 				Hash:        fo.Hash,
@@ -164,6 +211,9 @@ func (r *queryResolver) Files(ctx context.Context, filter *model.FileFilter, pag
 			Filename:   uf.Filename,
 			Visibility: uf.Visibility,
 			UploadedAt: uf.UploadedAt,
+			FolderID:   uf.FolderID,
+			OrgID:      uf.OrgID,
+			Version:    uf.Version,
 		}
 
 		items = append(items, userFile)
@@ -200,44 +250,50 @@ func (r *queryResolver) SearchFiles(ctx context.Context, q string, filter *model
 		}
 	}
 
-	return r.Files(ctx, searchFilter, pagination)
+	return r.Files(ctx, searchFilter, pagination, nil, nil, nil)
 }
 
 func (r *mutationResolver) RegisterFile(ctx context.Context, input model.RegisterFileInput) (*model.RegisterFilePayload, error) {
-	userID, _ := ctx.Value("userID").(string)
+	userID := auth.UserIDFromContext(ctx)
 	if userID == "" {
 		return nil, fmt.Errorf("unauthenticated")
 	}
 
-	// dedup check
-	var foID string
-	err := r.DB.Get(&foID, "SELECT id FROM file_objects WHERE hash=$1", input.Hash)
-	if err != nil || foID == "" {
-		// create file_object (we'll store storage_path placeholder)
-		id := uuid.New().String()
-		storagePath := "/data/files/" + input.Hash
-		_, err := r.DB.Exec("INSERT INTO file_objects (id, hash, storage_path, size_bytes, mime_type, ref_count) VALUES ($1,$2,$3,$4,$5,1)",
-			id, input.Hash, storagePath, input.SizeBytes, input.MimeType)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create file object: %v", err)
-		}
-		foID = id
-	} else {
-		// increment ref
-		_, err = r.DB.Exec("UPDATE file_objects SET ref_count = ref_count + 1 WHERE id=$1", foID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to increment ref count: %v", err)
-		}
+	if !storage.ValidHash(input.Hash) {
+		return nil, fmt.Errorf("hash must be a hex-encoded SHA-256")
+	}
+	if input.SizeBytes < 0 {
+		return nil, fmt.Errorf("sizeBytes must not be negative")
 	}
 
-	// create user_file
-	userFileID := uuid.New().String()
-	_, err = r.DB.Exec("INSERT INTO user_files (id, user_id, file_object_id, filename) VALUES ($1,$2,$3,$4)",
-		userFileID, userID, foID, input.Filename)
+	// a new version of a file shared with the caller goes to the owner's vault
+	target, err := authz.UploadTarget(ctx, r.DB, userID, input.OrgID, input.ReplaceUserFileID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create user file: %v", err)
+		return nil, err
+	}
+
+	if err := server.CheckRegistrationQuota(r.DB, target, input.Hash, int64(input.SizeBytes)); err != nil {
+		return nil, err
 	}
 
+	// dedup + ref count + user_files insert in one transaction; content is uploaded separately
+	res, err := storage.AttachContent(ctx, r.DB, storage.AttachInput{
+		UserID:            target.OwnerID,
+		Filename:          input.Filename,
+		Hash:              input.Hash,
+		FolderID:          input.FolderID,
+		ReplaceUserFileID: input.ReplaceUserFileID,
+		OrgID:             target.OrgID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to register file: %v", err)
+	}
+
+	return r.registerFilePayload(target.OwnerID, res.FileObject.ID, res.UserFileID)
+}
+
+// registerFilePayload loads the rows behind a newly created user file.
+func (r *Resolver) registerFilePayload(userID, foID, userFileID string) (*model.RegisterFilePayload, error) {
 	// fetch created rows to return
 	var fo struct {
 		ID          string    `db:"id"`
@@ -248,18 +304,22 @@ func (r *mutationResolver) RegisterFile(ctx context.Context, input model.Registe
 		RefCount    int       `db:"ref_count"`
 		CreatedAt   time.Time `db:"created_at"`
 	}
-	err = r.DB.Get(&fo, "SELECT id, hash, storage_path, size_bytes, mime_type, ref_count, created_at FROM file_objects WHERE id=$1", foID)
+	err := r.DB.Get(&fo, "SELECT id, hash, storage_path, size_bytes, mime_type, ref_count, created_at FROM file_objects WHERE id=$1", foID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch file object: %v", err)
 	}
 
 	var uf struct {
-		ID         string    `db:"id"`
-		Filename   string    `db:"filename"`
-		UploadedAt time.Time `db:"uploaded_at"`
-		Visibility string    `db:"visibility"`
+		ID         string     `db:"id"`
+		Filename   string     `db:"filename"`
+		UploadedAt time.Time  `db:"uploaded_at"`
+		Visibility string     `db:"visibility"`
+		FolderID   *string    `db:"folder_id"`
+		OrgID      *string    `db:"org_id"`
+		DeletedAt  *time.Time `db:"deleted_at"`
+		Version    int        `db:"version"`
 	}
-	err = r.DB.Get(&uf, "SELECT id, filename, uploaded_at, visibility FROM user_files WHERE id=$1", userFileID)
+	err = r.DB.Get(&uf, "SELECT id, filename, uploaded_at, visibility, folder_id, org_id, deleted_at, version FROM user_files WHERE id=$1", userFileID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch user file: %v", err)
 	}
@@ -289,6 +349,50 @@ func (r *mutationResolver) RegisterFile(ctx context.Context, input model.Registe
 			Filename:   uf.Filename,
 			Visibility: uf.Visibility,
 			UploadedAt: uf.UploadedAt,
+			FolderID:   uf.FolderID,
+			OrgID:      uf.OrgID,
+			DeletedAt:  uf.DeletedAt,
+			Version:    uf.Version,
 		},
 	}, nil
 }
+
+func (r *mutationResolver) UploadFile(ctx context.Context, file graphql.Upload, folderID *string, replaceUserFileID *string, orgID *string) (*model.RegisterFilePayload, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	res, err := server.IngestFile(ctx, r.DB, r.Blobs, server.IngestInput{
+		UserID:            userID,
+		Filename:          file.Filename,
+		DeclaredMime:      file.ContentType,
+		Content:           file.File,
+		FolderID:          folderID,
+		ReplaceUserFileID: replaceUserFileID,
+		OrgID:             orgID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.registerFilePayload(res.OwnerID, res.FileObjectID, res.UserFileID)
+}
+
+func (r *mutationResolver) UploadFiles(ctx context.Context, files []*graphql.Upload, folderID *string, orgID *string) ([]*model.UploadFileResult, error) {
+	// files are stored one by one, so report each outcome rather than
+	// failing the mutation after some of them are in
+	results := make([]*model.UploadFileResult, 0, len(files))
+	for _, f := range files {
+		res := &model.UploadFileResult{Filename: f.Filename}
+		p, err := r.UploadFile(ctx, *f, folderID, nil, orgID)
+		if err != nil {
+			msg := err.Error()
+			res.Error = &msg
+		} else {
+			res.Payload = p
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
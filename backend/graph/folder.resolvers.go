@@ -0,0 +1,154 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rishit911/file_vault_proj-backend/graph/model"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/authz"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+)
+
+func (r *queryResolver) Folders(ctx context.Context, parentID *string, path *string) ([]*model.Folder, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	// subfolders of a folder shared with the caller belong to its owner
+	ownerID := userID
+	if parentID != nil {
+		acc, err := authz.RequireFolder(ctx, r.DB, userID, *parentID, authz.Read)
+		if err != nil {
+			return nil, err
+		}
+		ownerID = acc.OwnerID
+	} else if path != nil {
+		id, err := storage.ResolveFolderPath(ctx, r.DB, userID, *path)
+		if err != nil {
+			return nil, err
+		}
+		parentID = id
+	}
+
+	folders, err := storage.ListFolders(ctx, r.DB, ownerID, parentID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*model.Folder, 0, len(folders))
+	for i := range folders {
+		f, err := r.folderModel(ctx, &folders[i])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+func (r *mutationResolver) CreateFolder(ctx context.Context, name string, parentID *string) (*model.Folder, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	f, err := storage.CreateFolder(ctx, r.DB, userID, parentID, name)
+	if err != nil {
+		return nil, err
+	}
+	return r.folderModel(ctx, f)
+}
+
+func (r *mutationResolver) RenameFolder(ctx context.Context, folderID string, name string) (*model.Folder, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	if err := storage.RenameFolder(ctx, r.DB, userID, folderID, name); err != nil {
+		return nil, err
+	}
+	return r.loadFolder(ctx, userID, folderID)
+}
+
+func (r *mutationResolver) MoveFolder(ctx context.Context, folderID string, parentID *string) (*model.Folder, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	if err := storage.MoveFolder(ctx, r.DB, userID, folderID, parentID); err != nil {
+		return nil, err
+	}
+	return r.loadFolder(ctx, userID, folderID)
+}
+
+func (r *mutationResolver) DeleteFolder(ctx context.Context, folderID string, purge *bool) (*model.DeleteFolderPayload, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	n, err := storage.DeleteFolder(ctx, r.DB, userID, folderID, purge != nil && *purge)
+	if err != nil {
+		return nil, err
+	}
+	return &model.DeleteFolderPayload{Success: true, Files: n}, nil
+}
+
+func (r *mutationResolver) MoveFile(ctx context.Context, userFileID string, folderID *string) (*model.UserFile, error) {
+	userID, foID, err := r.ownedUserFile(ctx, userFileID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := storage.MoveUserFile(ctx, r.DB, userID, userFileID, folderID); err != nil {
+		return nil, err
+	}
+
+	payload, err := r.registerFilePayload(userID, foID, userFileID)
+	if err != nil {
+		return nil, err
+	}
+	return payload.UserFile, nil
+}
+
+func (r *mutationResolver) RenameFile(ctx context.Context, userFileID string, filename string) (*model.UserFile, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+	// editors may rename
+	acc, err := authz.RequireFile(ctx, r.DB, userID, userFileID, authz.Write)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := storage.RenameUserFile(ctx, r.DB, userFileID, filename); err != nil {
+		return nil, err
+	}
+	return r.loadUserFile(ctx, acc.OwnerID, userFileID)
+}
+
+func (r *Resolver) loadFolder(ctx context.Context, userID, folderID string) (*model.Folder, error) {
+	f, err := storage.GetFolder(ctx, r.DB, userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	return r.folderModel(ctx, f)
+}
+
+func (r *Resolver) folderModel(ctx context.Context, f *storage.Folder) (*model.Folder, error) {
+	path, err := storage.FolderPath(ctx, r.DB, f.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.Folder{
+		ID:        f.ID,
+		Name:      f.Name,
+		ParentID:  f.ParentID,
+		Path:      path,
+		CreatedAt: f.CreatedAt,
+	}, nil
+}
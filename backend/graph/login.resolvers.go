@@ -0,0 +1,78 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rishit911/file_vault_proj-backend/graph/model"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+)
+
+// LoginEvents is the resolver for the loginEvents field.
+func (r *queryResolver) LoginEvents(ctx context.Context, filter *model.LoginEventFilter, pagination *model.PaginationInput) (*model.LoginEventPage, error) {
+	var f auth.LoginEventFilter
+	if filter != nil {
+		f = auth.LoginEventFilter{
+			UserID: filter.UserID,
+			Email:  filter.Email,
+			Event:  filter.Event,
+			IP:     filter.IP,
+			From:   filter.DateFrom,
+			To:     filter.DateTo,
+		}
+	}
+	limit, offset := pageBounds(pagination)
+	events, total, err := auth.ListLoginEvents(ctx, r.DB, f, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, ev := range events {
+		if ev.UserID != nil {
+			ids = append(ids, *ev.UserID)
+		}
+		if ev.ActorID != nil {
+			ids = append(ids, *ev.ActorID)
+		}
+	}
+	users, err := r.loadUsers(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]*model.LoginEvent, len(events))
+	for i, ev := range events {
+		item := &model.LoginEvent{
+			ID:        ev.ID,
+			Event:     ev.Event,
+			Email:     ev.Email,
+			IP:        ev.IP,
+			UserAgent: ev.UserAgent,
+			CreatedAt: ev.CreatedAt,
+		}
+		if ev.UserID != nil {
+			item.User = users[*ev.UserID]
+		}
+		if ev.ActorID != nil {
+			item.Actor = users[*ev.ActorID]
+		}
+		items[i] = item
+	}
+	return &model.LoginEventPage{Items: items, TotalCount: total}, nil
+}
+
+// UnlockAccount is the resolver for the unlockAccount field.
+func (r *mutationResolver) UnlockAccount(ctx context.Context, userID string) (bool, error) {
+	adminID := auth.UserIDFromContext(ctx)
+
+	err := auth.UnlockAccount(ctx, r.DB, userID, adminID)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		return false, fmt.Errorf("not found")
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,108 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rishit911/file_vault_proj-backend/graph/generated"
+	"github.com/rishit911/file_vault_proj-backend/graph/model"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+)
+
+func (r *Resolver) User() generated.UserResolver { return &userResolver{r} }
+
+type userResolver struct{ *Resolver }
+
+func (r *userResolver) MfaEnabled(ctx context.Context, obj *model.User) (*bool, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, nil
+	}
+	if obj.ID != userID && !auth.IdentityFromContext(ctx).IsAdmin() {
+		return nil, nil
+	}
+
+	state, err := auth.GetMFAState(ctx, r.DB, obj.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &state.Enabled, nil
+}
+
+func (r *mutationResolver) VerifyMfa(ctx context.Context, mfaToken string, code string) (*model.AuthPayload, error) {
+	userID, recoveryCodes, err := auth.VerifyMFA(ctx, r.DB, mfaToken, code, auth.DeviceFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := auth.StartSession(ctx, r.DB, userID, auth.DeviceFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	users, err := r.loadUsers(ctx, []string{userID})
+	if err != nil {
+		return nil, err
+	}
+
+	out := sessionPayload(tokens, users[userID])
+	out.RecoveryCodes = recoveryCodes
+	return out, nil
+}
+
+func (r *mutationResolver) EnrollTotp(ctx context.Context, mfaToken *string) (*model.TOTPEnrollment, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if mfaToken != nil {
+		// a user whose role requires two-factor authentication, mid-login
+		id, err := auth.ParseMFAToken(*mfaToken)
+		if err != nil {
+			return nil, err
+		}
+		userID = id
+	}
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	enrollment, err := auth.BeginTOTPEnrollment(ctx, r.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.TOTPEnrollment{Secret: enrollment.Secret, URI: enrollment.URI}, nil
+}
+
+func (r *mutationResolver) ConfirmTotp(ctx context.Context, code string) ([]string, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	return auth.ConfirmTOTPEnrollment(ctx, r.DB, userID, code)
+}
+
+func (r *mutationResolver) DisableTotp(ctx context.Context, code string) (*model.DeletePayload, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	if err := auth.DisableTOTP(ctx, r.DB, userID, code); err != nil {
+		return nil, err
+	}
+	return &model.DeletePayload{Success: true}, nil
+}
+
+func (r *mutationResolver) SetMFARequired(ctx context.Context, role string, required bool) (bool, error) {
+	if err := auth.SetMFARequired(ctx, r.DB, role, required); err != nil {
+		return false, err
+	}
+	return required, nil
+}
+
+// sessionPayload is the AuthPayload of a completed login.
+func sessionPayload(tokens *auth.TokenPair, user *model.User) *model.AuthPayload {
+	return &model.AuthPayload{
+		Token:        &tokens.AccessToken,
+		RefreshToken: &tokens.RefreshToken,
+		ExpiresAt:    &tokens.ExpiresAt,
+		User:         user,
+	}
+}
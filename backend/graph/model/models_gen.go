@@ -3,18 +3,96 @@
 package model
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
 	"time"
 )
 
+type AccessGrant struct {
+	ID        string    `json:"id"`
+	Role      string    `json:"role"`
+	Grantee   *User     `json:"grantee"`
+	Owner     *User     `json:"owner"`
+	GrantedBy *string   `json:"grantedBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	File      *UserFile `json:"file,omitempty"`
+	Folder    *Folder   `json:"folder,omitempty"`
+}
+
+type AccessToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	AllowedIPs []string   `json:"allowedIPs"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	LastUsedIP *string    `json:"lastUsedIP,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
 type AuthPayload struct {
-	Token string `json:"token"`
-	User  *User  `json:"user"`
+	Token                 *string    `json:"token,omitempty"`
+	RefreshToken          *string    `json:"refreshToken,omitempty"`
+	ExpiresAt             *time.Time `json:"expiresAt,omitempty"`
+	User                  *User      `json:"user"`
+	MfaRequired           bool       `json:"mfaRequired"`
+	MfaEnrollmentRequired bool       `json:"mfaEnrollmentRequired"`
+	MfaToken              *string    `json:"mfaToken,omitempty"`
+	RecoveryCodes         []string   `json:"recoveryCodes,omitempty"`
+}
+
+type CreatedAccessToken struct {
+	Token       string       `json:"token"`
+	AccessToken *AccessToken `json:"accessToken"`
+}
+
+type DeleteFolderPayload struct {
+	Success bool `json:"success"`
+	Files   int  `json:"files"`
 }
 
 type DeletePayload struct {
 	Success bool `json:"success"`
 }
 
+type DownloadEvent struct {
+	ID           string    `json:"id"`
+	UserFileID   string    `json:"userFileID"`
+	Filename     string    `json:"filename"`
+	Downloader   *User     `json:"downloader,omitempty"`
+	ShareID      *string   `json:"shareID,omitempty"`
+	Source       string    `json:"source"`
+	IP           *string   `json:"ip,omitempty"`
+	UserAgent    *string   `json:"userAgent,omitempty"`
+	RangeStart   *int      `json:"rangeStart,omitempty"`
+	RangeEnd     *int      `json:"rangeEnd,omitempty"`
+	BytesSent    int       `json:"bytesSent"`
+	Completed    bool      `json:"completed"`
+	DownloadedAt time.Time `json:"downloadedAt"`
+}
+
+type DownloadEventFilter struct {
+	UserFileID   *string    `json:"userFileID,omitempty"`
+	DownloaderID *string    `json:"downloaderID,omitempty"`
+	OwnerID      *string    `json:"ownerID,omitempty"`
+	Source       *string    `json:"source,omitempty"`
+	DateFrom     *time.Time `json:"dateFrom,omitempty"`
+	DateTo       *time.Time `json:"dateTo,omitempty"`
+	Completed    *bool      `json:"completed,omitempty"`
+}
+
+type DownloadEventPage struct {
+	Items      []*DownloadEvent `json:"items"`
+	TotalCount int              `json:"totalCount"`
+}
+
+type EmptyTrashPayload struct {
+	Purged int `json:"purged"`
+}
+
 type FileFilter struct {
 	MimeTypes        []string   `json:"mimeTypes,omitempty"`
 	MinSize          *int       `json:"minSize,omitempty"`
@@ -40,9 +118,101 @@ type FilePage struct {
 	TotalCount int         `json:"totalCount"`
 }
 
+type FileVersion struct {
+	Version         int         `json:"version"`
+	Current         bool        `json:"current"`
+	Filename        string      `json:"filename"`
+	FileObject      *FileObject `json:"fileObject"`
+	CreatedAt       time.Time   `json:"createdAt"`
+	SizeDelta       int         `json:"sizeDelta"`
+	MimeTypeChanged bool        `json:"mimeTypeChanged"`
+}
+
+type Folder struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	ParentID  *string   `json:"parentID,omitempty"`
+	Path      string    `json:"path"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type FsckObject struct {
+	FileObjectID string  `json:"fileObjectID"`
+	Hash         string  `json:"hash"`
+	StoragePath  string  `json:"storagePath"`
+	Detail       *string `json:"detail,omitempty"`
+}
+
+type FsckRefCount struct {
+	FileObjectID string `json:"fileObjectID"`
+	Hash         string `json:"hash"`
+	RefCount     int    `json:"refCount"`
+	Actual       int    `json:"actual"`
+	PendingGc    bool   `json:"pendingGC"`
+}
+
+type FsckReport struct {
+	OrphanBlobs        []string        `json:"orphanBlobs"`
+	MissingBlobs       []*FsckObject   `json:"missingBlobs"`
+	CorruptBlobs       []*FsckObject   `json:"corruptBlobs"`
+	RefCountMismatches []*FsckRefCount `json:"refCountMismatches"`
+	StaleTmpFiles      []string        `json:"staleTmpFiles"`
+	Repaired           bool            `json:"repaired"`
+	LostDropped        bool            `json:"lostDropped"`
+}
+
+type LoginEvent struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	Email     string    `json:"email"`
+	User      *User     `json:"user,omitempty"`
+	Actor     *User     `json:"actor,omitempty"`
+	IP        *string   `json:"ip,omitempty"`
+	UserAgent *string   `json:"userAgent,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type LoginEventFilter struct {
+	UserID   *string    `json:"userID,omitempty"`
+	Email    *string    `json:"email,omitempty"`
+	Event    *string    `json:"event,omitempty"`
+	IP       *string    `json:"ip,omitempty"`
+	DateFrom *time.Time `json:"dateFrom,omitempty"`
+	DateTo   *time.Time `json:"dateTo,omitempty"`
+}
+
+type LoginEventPage struct {
+	Items      []*LoginEvent `json:"items"`
+	TotalCount int           `json:"totalCount"`
+}
+
 type Mutation struct {
 }
 
+type OrgInvite struct {
+	OrgID     string    `json:"orgID"`
+	OrgName   string    `json:"orgName"`
+	Role      string    `json:"role"`
+	InvitedBy *User     `json:"invitedBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type OrgMember struct {
+	User     *User     `json:"user"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+type Organization struct {
+	ID         string       `json:"id"`
+	Name       string       `json:"name"`
+	MyRole     string       `json:"myRole"`
+	Members    []*OrgMember `json:"members"`
+	UsedBytes  int          `json:"usedBytes"`
+	QuotaBytes int          `json:"quotaBytes"`
+	CreatedAt  time.Time    `json:"createdAt"`
+}
+
 type PaginationInput struct {
 	Limit  *int `json:"limit,omitempty"`
 	Offset *int `json:"offset,omitempty"`
@@ -52,10 +222,13 @@ type Query struct {
 }
 
 type RegisterFileInput struct {
-	Filename  string  `json:"filename"`
-	Hash      string  `json:"hash"`
-	SizeBytes int     `json:"sizeBytes"`
-	MimeType  *string `json:"mimeType,omitempty"`
+	Filename          string  `json:"filename"`
+	Hash              string  `json:"hash"`
+	SizeBytes         int     `json:"sizeBytes"`
+	MimeType          *string `json:"mimeType,omitempty"`
+	FolderID          *string `json:"folderID,omitempty"`
+	ReplaceUserFileID *string `json:"replaceUserFileID,omitempty"`
+	OrgID             *string `json:"orgID,omitempty"`
 }
 
 type RegisterFilePayload struct {
@@ -63,6 +236,30 @@ type RegisterFilePayload struct {
 	UserFile   *UserFile   `json:"userFile"`
 }
 
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  *string   `json:"userAgent,omitempty"`
+	IP         *string   `json:"ip,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"`
+}
+
+type Share struct {
+	ID                string     `json:"id"`
+	UserFileID        string     `json:"userFileID"`
+	Filename          string     `json:"filename"`
+	Token             string     `json:"token"`
+	URL               string     `json:"url"`
+	ExpiresAt         *time.Time `json:"expiresAt,omitempty"`
+	MaxDownloads      *int       `json:"maxDownloads,omitempty"`
+	DownloadCount     int        `json:"downloadCount"`
+	PasswordProtected bool       `json:"passwordProtected"`
+	Active            bool       `json:"active"`
+	CreatedAt         time.Time  `json:"createdAt"`
+}
+
 type StorageStats struct {
 	TotalDedupedBytes int     `json:"totalDedupedBytes"`
 	OriginalBytes     int     `json:"originalBytes"`
@@ -70,18 +267,171 @@ type StorageStats struct {
 	SavedPercent      float64 `json:"savedPercent"`
 }
 
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type UploadFileResult struct {
+	Filename string               `json:"filename"`
+	Payload  *RegisterFilePayload `json:"payload,omitempty"`
+	Error    *string              `json:"error,omitempty"`
+}
+
 type User struct {
-	ID        string    `json:"id"`
-	Email     string    `json:"email"`
-	Role      string    `json:"role"`
-	CreatedAt time.Time `json:"createdAt"`
+	ID            string    `json:"id"`
+	Email         string    `json:"email"`
+	Role          string    `json:"role"`
+	CreatedAt     time.Time `json:"createdAt"`
+	MfaEnabled    *bool     `json:"mfaEnabled,omitempty"`
+	EmailVerified *bool     `json:"emailVerified,omitempty"`
+}
+
+type UserAccount struct {
+	User        *User      `json:"user"`
+	SuspendedAt *time.Time `json:"suspendedAt,omitempty"`
+	UsedBytes   int        `json:"usedBytes"`
+	FileCount   int        `json:"fileCount"`
+	QuotaBytes  int        `json:"quotaBytes"`
+	CustomQuota bool       `json:"customQuota"`
+}
+
+type UserAccountPage struct {
+	Items      []*UserAccount `json:"items"`
+	TotalCount int            `json:"totalCount"`
 }
 
 type UserFile struct {
-	ID         string      `json:"id"`
-	User       *User       `json:"user"`
-	FileObject *FileObject `json:"fileObject"`
-	Filename   string      `json:"filename"`
-	Visibility string      `json:"visibility"`
-	UploadedAt time.Time   `json:"uploadedAt"`
+	ID         string             `json:"id"`
+	User       *User              `json:"user"`
+	FileObject *FileObject        `json:"fileObject"`
+	Filename   string             `json:"filename"`
+	Visibility string             `json:"visibility"`
+	UploadedAt time.Time          `json:"uploadedAt"`
+	DeletedAt  *time.Time         `json:"deletedAt,omitempty"`
+	FolderID   *string            `json:"folderID,omitempty"`
+	OrgID      *string            `json:"orgID,omitempty"`
+	Version    int                `json:"version"`
+	Versions   []*FileVersion     `json:"versions"`
+	Downloads  *DownloadEventPage `json:"downloads"`
+}
+
+type UserFilter struct {
+	Email     *string `json:"email,omitempty"`
+	Role      *string `json:"role,omitempty"`
+	Suspended *bool   `json:"suspended,omitempty"`
+}
+
+type Permission string
+
+const (
+	PermissionFilesRead    Permission = "FILES_READ"
+	PermissionFilesWrite   Permission = "FILES_WRITE"
+	PermissionSharesManage Permission = "SHARES_MANAGE"
+	PermissionAdmin        Permission = "ADMIN"
+)
+
+var AllPermission = []Permission{
+	PermissionFilesRead,
+	PermissionFilesWrite,
+	PermissionSharesManage,
+	PermissionAdmin,
+}
+
+func (e Permission) IsValid() bool {
+	switch e {
+	case PermissionFilesRead, PermissionFilesWrite, PermissionSharesManage, PermissionAdmin:
+		return true
+	}
+	return false
+}
+
+func (e Permission) String() string {
+	return string(e)
+}
+
+func (e *Permission) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Permission(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Permission", str)
+	}
+	return nil
+}
+
+func (e Permission) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *Permission) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e Permission) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
+}
+
+type Role string
+
+const (
+	RoleUser  Role = "USER"
+	RoleAdmin Role = "ADMIN"
+)
+
+var AllRole = []Role{
+	RoleUser,
+	RoleAdmin,
+}
+
+func (e Role) IsValid() bool {
+	switch e {
+	case RoleUser, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+func (e Role) String() string {
+	return string(e)
+}
+
+func (e *Role) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Role(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Role", str)
+	}
+	return nil
+}
+
+func (e Role) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+func (e *Role) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return err
+	}
+	return e.UnmarshalGQL(s)
+}
+
+func (e Role) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	e.MarshalGQL(&buf)
+	return buf.Bytes(), nil
 }
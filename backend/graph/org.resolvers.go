@@ -0,0 +1,225 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rishit911/file_vault_proj-backend/graph/model"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/orgs"
+	"github.com/rishit911/file_vault_proj-backend/internal/server"
+)
+
+func (r *queryResolver) Organizations(ctx context.Context) ([]*model.Organization, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	list, err := orgs.ListForUser(ctx, r.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*model.Organization, 0, len(list))
+	for i := range list {
+		o, err := r.orgModel(ctx, userID, &list[i])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, nil
+}
+
+func (r *queryResolver) Organization(ctx context.Context, orgID string) (*model.Organization, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	org, err := orgs.Get(ctx, r.DB, userID, orgID)
+	if errors.Is(err, orgs.ErrNotMember) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.orgModel(ctx, userID, org)
+}
+
+func (r *mutationResolver) CreateOrganization(ctx context.Context, name string) (*model.Organization, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	org, err := orgs.Create(ctx, r.DB, userID, name)
+	if err != nil {
+		return nil, err
+	}
+	return r.orgModel(ctx, userID, org)
+}
+
+func (r *mutationResolver) InviteMember(ctx context.Context, orgID string, email string, role *string) (bool, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return false, fmt.Errorf("unauthenticated")
+	}
+
+	memberRole := orgs.RoleMember
+	if role != nil {
+		memberRole = orgs.Role(*role)
+	}
+	if err := orgs.InviteMember(ctx, r.DB, userID, orgID, email, memberRole); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *queryResolver) OrgInvites(ctx context.Context) ([]*model.OrgInvite, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	invites, err := orgs.PendingInvites(ctx, r.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, inv := range invites {
+		if inv.InvitedBy != nil {
+			ids = append(ids, *inv.InvitedBy)
+		}
+	}
+	users, err := r.loadUsers(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*model.OrgInvite, len(invites))
+	for i, inv := range invites {
+		out[i] = &model.OrgInvite{OrgID: inv.OrgID, OrgName: inv.OrgName, Role: string(inv.Role), CreatedAt: inv.CreatedAt}
+		if inv.InvitedBy != nil {
+			out[i].InvitedBy = users[*inv.InvitedBy]
+		}
+	}
+	return out, nil
+}
+
+func (r *mutationResolver) AcceptOrgInvite(ctx context.Context, orgID string) (*model.Organization, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	if err := orgs.AcceptInvite(ctx, r.DB, userID, orgID); err != nil {
+		return nil, err
+	}
+	org, err := orgs.Get(ctx, r.DB, userID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return r.orgModel(ctx, userID, org)
+}
+
+func (r *mutationResolver) DeclineOrgInvite(ctx context.Context, orgID string) (*model.DeletePayload, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	if err := orgs.DeclineInvite(ctx, r.DB, userID, orgID); err != nil {
+		return nil, err
+	}
+	return &model.DeletePayload{Success: true}, nil
+}
+
+func (r *mutationResolver) RemoveMember(ctx context.Context, orgID string, userID string) (*model.DeletePayload, error) {
+	callerID := auth.UserIDFromContext(ctx)
+	if callerID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	if err := orgs.RemoveMember(ctx, r.DB, callerID, orgID, userID); err != nil {
+		return nil, err
+	}
+	return &model.DeletePayload{Success: true}, nil
+}
+
+func (r *mutationResolver) TransferOwnership(ctx context.Context, orgID string, userID string) (*model.Organization, error) {
+	callerID := auth.UserIDFromContext(ctx)
+	if callerID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	if err := orgs.TransferOwnership(ctx, r.DB, callerID, orgID, userID); err != nil {
+		return nil, err
+	}
+	org, err := orgs.Get(ctx, r.DB, callerID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return r.orgModel(ctx, callerID, org)
+}
+
+func (r *mutationResolver) SetOrgQuota(ctx context.Context, orgID string, quotaBytes *int) (bool, error) {
+	var quota *int64
+	if quotaBytes != nil {
+		q := int64(*quotaBytes)
+		quota = &q
+	}
+	if err := orgs.SetQuota(ctx, r.DB, orgID, quota); errors.Is(err, orgs.ErrOrgNotFound) {
+		return false, fmt.Errorf("not found")
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *Resolver) orgModel(ctx context.Context, userID string, org *orgs.Organization) (*model.Organization, error) {
+	role, err := orgs.MemberRole(ctx, r.DB, userID, org.ID)
+	if err != nil {
+		return nil, err
+	}
+	members, err := orgs.Members(ctx, r.DB, org.ID)
+	if err != nil {
+		return nil, err
+	}
+	memberModels, err := r.memberModels(ctx, members)
+	if err != nil {
+		return nil, err
+	}
+	used, quota, err := server.StorageUsage(r.DB, userID, &org.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.Organization{
+		ID:         org.ID,
+		Name:       org.Name,
+		MyRole:     string(role),
+		Members:    memberModels,
+		UsedBytes:  int(used),
+		QuotaBytes: int(quota),
+		CreatedAt:  org.CreatedAt,
+	}, nil
+}
+
+func (r *Resolver) memberModels(ctx context.Context, members []orgs.Member) ([]*model.OrgMember, error) {
+	ids := make([]string, len(members))
+	for i, m := range members {
+		ids[i] = m.UserID
+	}
+	users, err := r.loadUsers(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*model.OrgMember, len(members))
+	for i, m := range members {
+		out[i] = &model.OrgMember{User: users[m.UserID], Role: string(m.Role), JoinedAt: m.JoinedAt}
+	}
+	return out, nil
+}
@@ -1,11 +1,17 @@
 package graph
 
-import "github.com/jmoiron/sqlx"
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/rishit911/file_vault_proj-backend/internal/mail"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+)
 
 // This file will not be regenerated automatically.
 //
 // It serves as dependency injection for your app, add any dependencies you require here.
 
 type Resolver struct {
-	DB *sqlx.DB
+	DB    *sqlx.DB
+	Blobs storage.BlobStore
+	Mail  *mail.Sender
 }
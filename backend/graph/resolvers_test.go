@@ -3,7 +3,6 @@ package graph_test
 import (
 	"context"
 	"testing"
-	"time"
 
 	"github.com/rishit911/file_vault_proj-backend/internal/auth"
 )
@@ -29,34 +28,14 @@ func TestAuthFunctions(t *testing.T) {
 	}
 }
 
-func TestJWTWorkflow(t *testing.T) {
-	userID := "test-user-123"
-
-	// Generate JWT
-	token, err := auth.GenerateJWT(userID, time.Hour*24) // 24 hours
-	if err != nil {
-		t.Fatalf("Failed to generate JWT: %v", err)
-	}
-
-	// Validate JWT
-	parsedUserID, err := auth.ParseAndValidateJWT(token)
-	if err != nil {
-		t.Fatalf("Failed to parse JWT: %v", err)
-	}
-
-	if parsedUserID != userID {
-		t.Errorf("Expected userID %s, got %s", userID, parsedUserID)
-	}
-}
-
 func TestContextValues(t *testing.T) {
 	ctx := context.Background()
 	userID := "test-user-456"
 
 	// Test context with user ID
-	ctx = context.WithValue(ctx, "userID", userID)
-	retrievedUserID, ok := ctx.Value("userID").(string)
-	if !ok {
+	ctx = auth.WithIdentity(ctx, &auth.Identity{UserID: userID})
+	retrievedUserID := auth.UserIDFromContext(ctx)
+	if retrievedUserID == "" {
 		t.Fatal("Failed to retrieve userID from context")
 	}
 
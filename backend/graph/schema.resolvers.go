@@ -5,12 +5,15 @@ package graph
 import (
 	"context"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/rishit911/file_vault_proj-backend/graph/generated"
 	"github.com/rishit911/file_vault_proj-backend/graph/model"
 	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/authz"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
 )
 
 func (r *Resolver) Mutation() generated.MutationResolver { return &mutationResolver{r} }
@@ -21,6 +24,13 @@ type queryResolver struct{ *Resolver }
 
 // Register user
 func (m *mutationResolver) Register(ctx context.Context, email string, password string) (*model.AuthPayload, error) {
+	email, err := auth.ValidateEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if err := auth.ValidatePassword(password, email); err != nil {
+		return nil, err
+	}
 	hashed, err := auth.HashPassword(password)
 	if err != nil {
 		return nil, err
@@ -32,83 +42,109 @@ func (m *mutationResolver) Register(ctx context.Context, email string, password
 		return nil, err
 	}
 
-	token, err := auth.GenerateJWT(id, 24*time.Hour)
+	// until the address is verified the session can only read
+	if m.Mail != nil {
+		if err := auth.SendVerificationEmail(ctx, m.DB, m.Mail, id); err != nil {
+			log.Printf("send verification email: %v", err)
+		}
+	}
+
+	tokens, err := auth.StartSession(ctx, m.DB, id, auth.DeviceFromContext(ctx))
 	if err != nil {
 		return nil, err
 	}
 
-	return &model.AuthPayload{
-		Token: token,
-		User: &model.User{
-			ID:        id,
-			Email:     email,
-			Role:      "user",
-			CreatedAt: time.Now(),
-		},
-	}, nil
+	return sessionPayload(tokens, &model.User{
+		ID:        id,
+		Email:     email,
+		Role:      "user",
+		CreatedAt: time.Now(),
+	}), nil
 }
 
 // Login
 func (m *mutationResolver) Login(ctx context.Context, email string, password string) (*model.AuthPayload, error) {
-	var id, pwHash string
-	if err := m.DB.QueryRowx(`SELECT id, password_hash FROM users WHERE email=$1`, email).Scan(&id, &pwHash); err != nil {
+	id, err := auth.CheckPassword(ctx, m.DB, email, password, auth.DeviceFromContext(ctx))
+	if err != nil {
 		return nil, err
 	}
 
-	if err := auth.CompareHashAndPassword(pwHash, password); err != nil {
-		return nil, err
+	var createdAt time.Time
+	_ = m.DB.Get(&createdAt, "SELECT created_at FROM users WHERE id=$1", id)
+	user := &model.User{
+		ID:        id,
+		Email:     email,
+		Role:      "user",
+		CreatedAt: createdAt,
 	}
 
-	token, err := auth.GenerateJWT(id, 24*time.Hour)
+	// with two-factor authentication the password only earns an mfaToken
+	mfa, err := auth.GetMFAState(ctx, m.DB, id)
 	if err != nil {
 		return nil, err
 	}
+	if mfa.Pending() {
+		mfaToken, err := auth.GenerateMFAToken(id)
+		if err != nil {
+			return nil, err
+		}
+		return &model.AuthPayload{
+			User:                  user,
+			MfaRequired:           true,
+			MfaEnrollmentRequired: !mfa.Enabled,
+			MfaToken:              &mfaToken,
+		}, nil
+	}
 
-	var createdAt time.Time
-	_ = m.DB.Get(&createdAt, "SELECT created_at FROM users WHERE id=$1", id)
-
-	return &model.AuthPayload{
-		Token: token,
-		User: &model.User{
-			ID:        id,
-			Email:     email,
-			Role:      "user",
-			CreatedAt: createdAt,
-		},
-	}, nil
+	tokens, err := auth.StartSession(ctx, m.DB, id, auth.DeviceFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return sessionPayload(tokens, user), nil
 }
 
 // Me
 func (q *queryResolver) Me(ctx context.Context) (*model.User, error) {
-	// Extract user from context (AuthMiddleware sets user id on context)
-	if ctx == nil {
+	// the caller, if the request was authenticated
+	id := auth.UserIDFromContext(ctx)
+	if id == "" {
 		return nil, nil
 	}
 
-	if v := ctx.Value("userID"); v != nil {
-		if id, ok := v.(string); ok {
-			var u struct {
-				ID        string    `db:"id"`
-				Email     string    `db:"email"`
-				Role      string    `db:"role"`
-				CreatedAt time.Time `db:"created_at"`
-			}
-			if err := q.DB.Get(&u, "SELECT id, email, role, created_at FROM users WHERE id=$1", id); err == nil {
-				return &model.User{
-					ID:        u.ID,
-					Email:     u.Email,
-					Role:      u.Role,
-					CreatedAt: u.CreatedAt,
-				}, nil
-			}
-		}
+	var u struct {
+		ID        string    `db:"id"`
+		Email     string    `db:"email"`
+		Role      string    `db:"role"`
+		CreatedAt time.Time `db:"created_at"`
 	}
-	return nil, nil
+	if err := q.DB.Get(&u, "SELECT id, email, role, created_at FROM users WHERE id=$1", id); err != nil {
+		return nil, nil
+	}
+	return &model.User{
+		ID:        u.ID,
+		Email:     u.Email,
+		Role:      u.Role,
+		CreatedAt: u.CreatedAt,
+	}, nil
 }
 
 // DeleteFile is the resolver for the deleteFile field.
 func (r *mutationResolver) DeleteFile(ctx context.Context, userFileID string) (*model.DeletePayload, error) {
-	panic("not implemented: DeleteFile - deleteFile")
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	// owners and co-owners may delete
+	if _, err := authz.RequireFile(ctx, r.DB, userID, userFileID, authz.Delete); err != nil {
+		return nil, err
+	}
+
+	// move to the owner's trash; the reference is only dropped when it is purged
+	if err := storage.TrashUserFile(ctx, r.DB, userFileID); err != nil {
+		return nil, err
+	}
+	return &model.DeletePayload{Success: true}, nil
 }
 
 // File is the resolver for the file field.
@@ -118,24 +154,12 @@ func (r *queryResolver) File(ctx context.Context, userFileID string) (*model.Use
 
 // Stats is the resolver for the stats field.
 func (r *queryResolver) Stats(ctx context.Context) (*model.StorageStats, error) {
-	// Ensure user is admin
-	userID, _ := ctx.Value("userID").(string)
-	if userID == "" {
-		return nil, fmt.Errorf("unauthenticated")
-	}
-
-	var role string
-	err := r.DB.Get(&role, "SELECT role FROM users WHERE id=$1", userID)
-	if err != nil || role != "admin" {
-		return nil, fmt.Errorf("forbidden")
-	}
-
 	// Calculate storage statistics
 	var totalDedupedBytes int64
 	var originalBytes int64
 
-	// Get total unique file storage (deduplicated)
-	err = r.DB.Get(&totalDedupedBytes, "SELECT COALESCE(SUM(size_bytes), 0) FROM file_objects")
+	// Get total unique file storage (deduplicated); objects pending GC are no longer part of any vault
+	err := r.DB.Get(&totalDedupedBytes, "SELECT COALESCE(SUM(size_bytes), 0) FROM file_objects WHERE ref_count > 0")
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,50 @@
+package graph
+
+import (
+	"context"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+)
+
+// ownScopeDirectives are the directives that decide the scope of a field
+// themselves (see directives.go).
+var ownScopeDirectives = []string{"public", "session", "hasPermission"}
+
+// defaultScope returns the scope a root field needs when its directives do
+// not say: files:read for queries and files:write for mutations, or "" for
+// none.
+func defaultScope(rc *graphql.RootFieldContext) string {
+	if strings.HasPrefix(rc.Field.Name, "__") {
+		return ""
+	}
+	for _, name := range ownScopeDirectives {
+		if rc.Field.Definition.Directives.ForName(name) != nil {
+			return ""
+		}
+	}
+	if rc.Object == "Mutation" {
+		return auth.ScopeFilesWrite
+	}
+	return auth.ScopeFilesRead
+}
+
+// RequireScopes is a root field middleware that refuses fields the scopes of
+// the request's token do not cover, unless the field has its own
+// authorization directive. Unauthenticated requests are left to the
+// directives.
+func RequireScopes(ctx context.Context, next graphql.RootResolver) graphql.Marshaler {
+	rc := graphql.GetRootFieldContext(ctx)
+	id := auth.IdentityFromContext(ctx)
+	if rc == nil || id == nil {
+		return next(ctx)
+	}
+	if scope := defaultScope(rc); scope != "" {
+		if err := scopeError(id, scope); err != nil {
+			graphql.AddError(ctx, err)
+			return graphql.Null
+		}
+	}
+	return next(ctx)
+}
@@ -0,0 +1,52 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rishit911/file_vault_proj-backend/graph/model"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+)
+
+func (r *queryResolver) MySessions(ctx context.Context) ([]*model.Session, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+	current := auth.SessionIDFromContext(ctx)
+
+	sessions, err := auth.ListSessions(ctx, r.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*model.Session, len(sessions))
+	for i, s := range sessions {
+		out[i] = &model.Session{
+			ID:         s.ID,
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			CreatedAt:  s.CreatedAt,
+			LastUsedAt: s.LastUsedAt,
+			ExpiresAt:  s.ExpiresAt,
+			Current:    s.ID == current,
+		}
+	}
+	return out, nil
+}
+
+func (r *mutationResolver) RevokeSession(ctx context.Context, sessionID string) (*model.DeletePayload, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	err := auth.RevokeSession(ctx, r.DB, userID, sessionID)
+	if errors.Is(err, auth.ErrSessionNotFound) {
+		return nil, fmt.Errorf("not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.DeletePayload{Success: true}, nil
+}
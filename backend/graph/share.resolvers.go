@@ -0,0 +1,96 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rishit911/file_vault_proj-backend/graph/model"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/server"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+)
+
+func (r *queryResolver) MyShares(ctx context.Context) ([]*model.Share, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	shares, err := storage.ListShares(ctx, r.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*model.Share, len(shares))
+	for i := range shares {
+		items[i] = shareModel(&shares[i])
+	}
+	return items, nil
+}
+
+func (r *mutationResolver) CreateShare(ctx context.Context, userFileID string, expiresAt *time.Time, maxDownloads *int, password *string) (*model.Share, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("expiresAt must be in the future")
+	}
+	if maxDownloads != nil && *maxDownloads < 1 {
+		return nil, fmt.Errorf("maxDownloads must be at least 1")
+	}
+
+	in := storage.ShareInput{UserFileID: userFileID, ExpiresAt: expiresAt, MaxDownloads: maxDownloads}
+	if password != nil {
+		if *password == "" {
+			return nil, fmt.Errorf("password must not be empty")
+		}
+		hash, err := auth.HashPassword(*password)
+		if err != nil {
+			return nil, fmt.Errorf("hash failed: %v", err)
+		}
+		in.PasswordHash = &hash
+	}
+
+	share, err := storage.CreateShare(ctx, r.DB, userID, in)
+	if errors.Is(err, storage.ErrUserFileNotFound) {
+		return nil, fmt.Errorf("not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return shareModel(share), nil
+}
+
+func (r *mutationResolver) RevokeShare(ctx context.Context, shareID string) (*model.DeletePayload, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	err := storage.RevokeShare(ctx, r.DB, userID, shareID)
+	if errors.Is(err, storage.ErrShareNotFound) {
+		return nil, fmt.Errorf("not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.DeletePayload{Success: true}, nil
+}
+
+func shareModel(s *storage.Share) *model.Share {
+	return &model.Share{
+		ID:                s.ID,
+		UserFileID:        s.UserFileID,
+		Filename:          s.Filename,
+		Token:             s.Token,
+		URL:               server.ShareURL(s.Token),
+		ExpiresAt:         s.ExpiresAt,
+		MaxDownloads:      s.MaxDownloads,
+		DownloadCount:     int(s.DownloadCount),
+		PasswordProtected: s.PasswordHash != nil,
+		Active:            s.Check(time.Now()) == nil,
+		CreatedAt:         s.CreatedAt,
+	}
+}
@@ -0,0 +1,76 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rishit911/file_vault_proj-backend/graph/model"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+)
+
+func (r *queryResolver) MyAccessTokens(ctx context.Context) ([]*model.AccessToken, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	tokens, err := auth.ListPATs(ctx, r.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*model.AccessToken, len(tokens))
+	for i := range tokens {
+		out[i] = accessTokenModel(&tokens[i])
+	}
+	return out, nil
+}
+
+func (r *mutationResolver) CreateAccessToken(ctx context.Context, name string, scopes []string, expiresAt *time.Time, allowedIPs []string) (*model.CreatedAccessToken, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	t, token, err := auth.CreatePAT(ctx, r.DB, userID, auth.PATInput{
+		Name:       name,
+		Scopes:     scopes,
+		ExpiresAt:  expiresAt,
+		AllowedIPs: allowedIPs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &model.CreatedAccessToken{Token: token, AccessToken: accessTokenModel(t)}, nil
+}
+
+func (r *mutationResolver) RevokeAccessToken(ctx context.Context, tokenID string) (*model.DeletePayload, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	err := auth.RevokePAT(ctx, r.DB, userID, tokenID)
+	if errors.Is(err, auth.ErrTokenNotFound) {
+		return nil, fmt.Errorf("not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.DeletePayload{Success: true}, nil
+}
+
+func accessTokenModel(t *auth.PersonalAccessToken) *model.AccessToken {
+	return &model.AccessToken{
+		ID:         t.ID,
+		Name:       t.Name,
+		Prefix:     t.Prefix,
+		Scopes:     t.Scopes,
+		ExpiresAt:  t.ExpiresAt,
+		AllowedIPs: t.AllowedIPs,
+		LastUsedAt: t.LastUsedAt,
+		LastUsedIP: t.LastUsedIP,
+		CreatedAt:  t.CreatedAt,
+	}
+}
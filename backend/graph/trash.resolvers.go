@@ -0,0 +1,153 @@
+package graph
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rishit911/file_vault_proj-backend/graph/model"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+)
+
+func (r *queryResolver) Trash(ctx context.Context, pagination *model.PaginationInput) (*model.FilePage, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	limit := 20
+	offset := 0
+	if pagination != nil {
+		if pagination.Limit != nil {
+			limit = int(*pagination.Limit)
+		}
+		if pagination.Offset != nil {
+			offset = int(*pagination.Offset)
+		}
+	}
+
+	var total int
+	err := r.DB.Get(&total, "SELECT COUNT(1) FROM user_files WHERE user_id=$1 AND deleted_at IS NOT NULL", userID)
+	if err != nil {
+		return nil, fmt.Errorf("count query failed: %v", err)
+	}
+
+	var rows []struct {
+		ID          string    `db:"id"`
+		Filename    string    `db:"filename"`
+		UploadedAt  time.Time `db:"uploaded_at"`
+		DeletedAt   time.Time `db:"deleted_at"`
+		Visibility  string    `db:"visibility"`
+		FolderID    *string   `db:"folder_id"`
+		Version     int       `db:"version"`
+		FoID        string    `db:"fo_id"`
+		Hash        string    `db:"hash"`
+		StoragePath string    `db:"storage_path"`
+		SizeBytes   int64     `db:"size_bytes"`
+		MimeType    *string   `db:"mime_type"`
+		RefCount    int       `db:"ref_count"`
+		CreatedAt   time.Time `db:"created_at"`
+	}
+	err = r.DB.Select(&rows, `
+	SELECT
+		uf.id, uf.filename, uf.uploaded_at, uf.deleted_at, uf.visibility, uf.folder_id, uf.version,
+		fo.id AS fo_id, fo.hash, fo.storage_path, fo.size_bytes, fo.mime_type, fo.ref_count, fo.created_at
+	FROM user_files uf
+	JOIN file_objects fo ON uf.file_object_id = fo.id
+	WHERE uf.user_id = $1 AND uf.deleted_at IS NOT NULL
+	ORDER BY uf.deleted_at DESC LIMIT $2 OFFSET $3`, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("trash query failed: %v", err)
+	}
+
+	items := []*model.UserFile{}
+	for _, row := range rows {
+		deletedAt := row.DeletedAt
+		items = append(items, &model.UserFile{
+			ID:   row.ID,
+			User: &model.User{ID: userID},
+			FileObject: &model.FileObject{
+				ID:          row.FoID,
+				Hash:        row.Hash,
+				StoragePath: row.StoragePath,
+				SizeBytes:   int(row.SizeBytes),
+				MimeType:    row.MimeType,
+				RefCount:    row.RefCount,
+				CreatedAt:   row.CreatedAt,
+			},
+			Filename:   row.Filename,
+			Visibility: row.Visibility,
+			UploadedAt: row.UploadedAt,
+			DeletedAt:  &deletedAt,
+			FolderID:   row.FolderID,
+			Version:    row.Version,
+		})
+	}
+
+	return &model.FilePage{Items: items, TotalCount: total}, nil
+}
+
+func (r *mutationResolver) RestoreFile(ctx context.Context, userFileID string) (*model.UserFile, error) {
+	userID, foID, err := r.ownedUserFile(ctx, userFileID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := storage.RestoreUserFile(ctx, r.DB, userFileID); err != nil {
+		return nil, err
+	}
+
+	payload, err := r.registerFilePayload(userID, foID, userFileID)
+	if err != nil {
+		return nil, err
+	}
+	return payload.UserFile, nil
+}
+
+func (r *mutationResolver) PurgeFile(ctx context.Context, userFileID string) (*model.DeletePayload, error) {
+	if _, _, err := r.ownedUserFile(ctx, userFileID, true); err != nil {
+		return nil, err
+	}
+
+	if _, err := storage.PurgeUserFile(ctx, r.DB, userFileID); err != nil {
+		return nil, err
+	}
+	return &model.DeletePayload{Success: true}, nil
+}
+
+func (r *mutationResolver) EmptyTrash(ctx context.Context) (*model.EmptyTrashPayload, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return nil, fmt.Errorf("unauthenticated")
+	}
+
+	n, err := storage.EmptyTrash(ctx, r.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.EmptyTrashPayload{Purged: n}, nil
+}
+
+// ownedUserFile checks that userFileID belongs to the caller and is in the
+// trash (or not, if trashed is false), and returns the caller and the file's
+// file_object_id.
+func (r *Resolver) ownedUserFile(ctx context.Context, userFileID string, trashed bool) (string, string, error) {
+	userID := auth.UserIDFromContext(ctx)
+	if userID == "" {
+		return "", "", fmt.Errorf("unauthenticated")
+	}
+
+	var row struct {
+		UserID       string `db:"user_id"`
+		FileObjectID string `db:"file_object_id"`
+	}
+	err := r.DB.Get(&row, "SELECT user_id, file_object_id FROM user_files WHERE id=$1 AND (deleted_at IS NOT NULL) = $2", userFileID, trashed)
+	if err != nil {
+		return "", "", fmt.Errorf("not found")
+	}
+	if row.UserID != userID {
+		return "", "", fmt.Errorf("forbidden")
+	}
+	return userID, row.FileObjectID, nil
+}
@@ -0,0 +1,96 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rishit911/file_vault_proj-backend/graph/model"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/users"
+)
+
+func userAccount(u *users.User) *model.UserAccount {
+	return &model.UserAccount{
+		User:        &model.User{ID: u.ID, Email: u.Email, Role: u.Role, CreatedAt: u.CreatedAt},
+		SuspendedAt: u.SuspendedAt,
+		UsedBytes:   int(u.UsedBytes),
+		FileCount:   u.FileCount,
+		QuotaBytes:  int(u.Quota()),
+		CustomQuota: u.QuotaBytes != nil,
+	}
+}
+
+// userResult returns the account after a change to it, or err.
+func (r *Resolver) userResult(ctx context.Context, userID string, err error) (*model.UserAccount, error) {
+	if errors.Is(err, users.ErrUserNotFound) {
+		return nil, fmt.Errorf("not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	u, err := users.Get(ctx, r.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	return userAccount(u), nil
+}
+
+// Users is the resolver for the users field.
+func (r *queryResolver) Users(ctx context.Context, filter *model.UserFilter, pagination *model.PaginationInput) (*model.UserAccountPage, error) {
+	var f users.Filter
+	if filter != nil {
+		f = users.Filter{Email: filter.Email, Role: filter.Role, Suspended: filter.Suspended}
+	}
+	limit, offset := pageBounds(pagination)
+	list, total, err := users.List(ctx, r.DB, f, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*model.UserAccount, len(list))
+	for i := range list {
+		items[i] = userAccount(&list[i])
+	}
+	return &model.UserAccountPage{Items: items, TotalCount: total}, nil
+}
+
+// SetUserRole is the resolver for the setUserRole field.
+func (r *mutationResolver) SetUserRole(ctx context.Context, userID string, role string) (*model.UserAccount, error) {
+	err := users.SetRole(ctx, r.DB, auth.UserIDFromContext(ctx), userID, role)
+	return r.userResult(ctx, userID, err)
+}
+
+// SuspendUser is the resolver for the suspendUser field.
+func (r *mutationResolver) SuspendUser(ctx context.Context, userID string) (*model.UserAccount, error) {
+	err := users.Suspend(ctx, r.DB, auth.UserIDFromContext(ctx), userID)
+	return r.userResult(ctx, userID, err)
+}
+
+// ReactivateUser is the resolver for the reactivateUser field.
+func (r *mutationResolver) ReactivateUser(ctx context.Context, userID string) (*model.UserAccount, error) {
+	err := users.Reactivate(ctx, r.DB, userID)
+	return r.userResult(ctx, userID, err)
+}
+
+// SetUserQuota is the resolver for the setUserQuota field.
+func (r *mutationResolver) SetUserQuota(ctx context.Context, userID string, quotaBytes *int) (*model.UserAccount, error) {
+	var quota *int64
+	if quotaBytes != nil {
+		q := int64(*quotaBytes)
+		quota = &q
+	}
+	err := users.SetQuota(ctx, r.DB, userID, quota)
+	return r.userResult(ctx, userID, err)
+}
+
+// DeleteUser is the resolver for the deleteUser field.
+func (r *mutationResolver) DeleteUser(ctx context.Context, userID string) (*model.DeletePayload, error) {
+	_, err := users.Delete(ctx, r.DB, auth.UserIDFromContext(ctx), userID)
+	if errors.Is(err, users.ErrUserNotFound) {
+		return nil, fmt.Errorf("not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.DeletePayload{Success: true}, nil
+}
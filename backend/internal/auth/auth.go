@@ -6,11 +6,8 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
-var jwtSecret = []byte(getEnv("JWT_SECRET", "dev_jwt_secret"))
-
 func getEnv(k, def string) string {
 	if v := os.Getenv(k); v != "" {
 		return v
@@ -18,41 +15,30 @@ func getEnv(k, def string) string {
 	return def
 }
 
-func HashPassword(plain string) (string, error) {
-	b, err := bcrypt.GenerateFromPassword([]byte(plain), bcrypt.DefaultCost)
-	return string(b), err
-}
-
-func CompareHashAndPassword(hash, plain string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(plain))
-}
-
-func GenerateJWT(userID string, expiry time.Duration) (string, error) {
+// GenerateAccessToken issues a JWT for a session (the "sid" claim), so it
+// stops working when the session is revoked (see Authenticate).
+func GenerateAccessToken(userID, sessionID string, expiresAt time.Time) (string, error) {
 	claims := jwt.MapClaims{
 		"sub": userID,
-		"exp": time.Now().Add(expiry).Unix(),
+		"sid": sessionID,
+		"exp": expiresAt.Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return Keys().Sign(claims)
 }
 
-func ParseAndValidateJWT(tokenStr string) (string, error) {
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return jwtSecret, nil
-	})
-
-	if err != nil || !token.Valid {
-		return "", err
+// ParseAccessToken validates an access token and returns its user and
+// session ids. Tokens without a session are rejected.
+func ParseAccessToken(tokenStr string) (string, string, error) {
+	token, err := Keys().Parse(tokenStr)
+	if err != nil {
+		return "", "", err
 	}
 
 	claims := token.Claims.(jwt.MapClaims)
-	sub, ok := claims["sub"].(string)
-	if !ok {
-		return "", errors.New("invalid token claims")
+	sub, _ := claims["sub"].(string)
+	sid, _ := claims["sid"].(string)
+	if sub == "" || sid == "" {
+		return "", "", errors.New("invalid token claims")
 	}
-
-	return sub, nil
+	return sub, sid, nil
 }
@@ -1,9 +1,6 @@
 package auth
 
-import (
-	"testing"
-	"time"
-)
+import "testing"
 
 func TestHashPassword(t *testing.T) {
 	password := "testpassword123"
@@ -45,45 +42,3 @@ func TestCompareHashAndPassword(t *testing.T) {
 		t.Error("CompareHashAndPassword should fail for wrong password")
 	}
 }
-
-func TestJWTGeneration(t *testing.T) {
-	userID := "test-user-id"
-	duration := time.Hour
-
-	// Generate JWT
-	token, err := GenerateJWT(userID, duration)
-	if err != nil {
-		t.Fatalf("GenerateJWT failed: %v", err)
-	}
-
-	if token == "" {
-		t.Fatal("GenerateJWT returned empty token")
-	}
-
-	// Parse and validate JWT
-	parsedUserID, err := ParseAndValidateJWT(token)
-	if err != nil {
-		t.Fatalf("ParseAndValidateJWT failed: %v", err)
-	}
-
-	if parsedUserID != userID {
-		t.Errorf("Expected userID %s, got %s", userID, parsedUserID)
-	}
-}
-
-func TestJWTExpiration(t *testing.T) {
-	userID := "test-user-id"
-	duration := -time.Hour // Expired token
-
-	// Generate expired JWT
-	token, err := GenerateJWT(userID, duration)
-	if err != nil {
-		t.Fatalf("GenerateJWT failed: %v", err)
-	}
-
-	// Try to parse expired token
-	_, err = ParseAndValidateJWT(token)
-	if err == nil {
-		t.Error("ParseAndValidateJWT should fail for expired token")
-	}
-}
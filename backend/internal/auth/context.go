@@ -0,0 +1,70 @@
+package auth
+
+import "context"
+
+// Roles of users (users.role).
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Identity is who a request is authenticated as. It is resolved once per
+// request (see Authenticate) and carried in the request context.
+type Identity struct {
+	UserID string
+	Role   string
+	// SessionID is set for login sessions, TokenID for personal access tokens.
+	SessionID string
+	TokenID   string
+	Scopes    Scopes
+}
+
+// HasRole reports whether the user has role; admins have every role. It is
+// false for a nil Identity (an unauthenticated request).
+func (id *Identity) HasRole(role string) bool {
+	return id != nil && (id.Role == role || id.Role == RoleAdmin)
+}
+
+// IsAdmin reports whether the user is an admin.
+func (id *Identity) IsAdmin() bool {
+	return id.HasRole(RoleAdmin)
+}
+
+// Can reports whether the request's credentials grant permission, one of
+// the scopes. Login sessions can do everything once the email address is
+// verified; the admin permission is only of use to admins.
+func (id *Identity) Can(permission string) bool {
+	return id != nil && id.Scopes.Has(permission)
+}
+
+type identityKey struct{}
+
+// WithIdentity stores the caller in ctx.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the caller stored by WithIdentity; nil for
+// unauthenticated requests.
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// UserIDFromContext returns the caller's user id, or "" for unauthenticated
+// requests.
+func UserIDFromContext(ctx context.Context) string {
+	if id := IdentityFromContext(ctx); id != nil {
+		return id.UserID
+	}
+	return ""
+}
+
+// SessionIDFromContext returns the caller's login session, or "" for
+// personal access tokens and unauthenticated requests.
+func SessionIDFromContext(ctx context.Context) string {
+	if id := IdentityFromContext(ctx); id != nil {
+		return id.SessionID
+	}
+	return ""
+}
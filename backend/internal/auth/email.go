@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	mailer "github.com/rishit911/file_vault_proj-backend/internal/mail"
+)
+
+var (
+	ErrInvalidEmail = errors.New("invalid email address")
+	// ErrInvalidEmailToken covers unknown, used and expired verification and
+	// reset tokens.
+	ErrInvalidEmailToken = errors.New("invalid or expired link")
+	ErrAlreadyVerified   = errors.New("email address is already verified")
+)
+
+// Purposes of email tokens.
+const (
+	purposeVerifyEmail   = "verify_email"
+	purposeResetPassword = "reset_password"
+)
+
+// EmailVerifyTTL is how long a verification link works
+// (EMAIL_VERIFY_TTL, default 48h).
+func EmailVerifyTTL() time.Duration {
+	return envDuration("EMAIL_VERIFY_TTL", 48*time.Hour)
+}
+
+// PasswordResetTTL is how long a password reset link works
+// (PASSWORD_RESET_TTL, default 1h).
+func PasswordResetTTL() time.Duration {
+	return envDuration("PASSWORD_RESET_TTL", time.Hour)
+}
+
+// ValidateEmail accepts a bare address such as user@example.com and returns
+// it trimmed.
+func ValidateEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || !strings.Contains(email[strings.LastIndex(email, "@")+1:], ".") {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
+
+// SendVerificationEmail mails userID a link that verifies their address.
+// Earlier links stop working.
+func SendVerificationEmail(ctx context.Context, db *sqlx.DB, m *mailer.Sender, userID string) error {
+	var u struct {
+		Email    string `db:"email"`
+		Verified bool   `db:"verified"`
+	}
+	err := db.GetContext(ctx, &u, "SELECT email, email_verified_at IS NOT NULL AS verified FROM users WHERE id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("lookup user: %w", err)
+	}
+	if u.Verified {
+		return ErrAlreadyVerified
+	}
+
+	ttl := EmailVerifyTTL()
+	token, err := issueEmailToken(ctx, db, userID, purposeVerifyEmail, ttl)
+	if err != nil {
+		return err
+	}
+	return m.Send(ctx, u.Email, "verify_email", map[string]any{
+		"Email":     u.Email,
+		"Token":     token,
+		"Link":      m.Link("/api/v1/auth/verify-email?token=" + url.QueryEscape(token)),
+		"ExpiresIn": ttl.String(),
+	})
+}
+
+// VerifyEmail marks the address of a verification token's user verified.
+func VerifyEmail(ctx context.Context, db *sqlx.DB, token string) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	userID, err := consumeEmailToken(ctx, tx, purposeVerifyEmail, token)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET email_verified_at = COALESCE(email_verified_at, now()) WHERE id = $1", userID); err != nil {
+		return fmt.Errorf("verify email: %w", err)
+	}
+	return tx.Commit()
+}
+
+// resetMailTimeout bounds the work of a password reset request once the
+// caller has been answered.
+const resetMailTimeout = time.Minute
+
+// RequestPasswordReset mails a reset link to the account with email, if
+// there is one. The lookup and the mail happen in the background, after the
+// caller's request has ended, and failures are only logged: neither the
+// answer nor its timing says whether there is an account, so it cannot be
+// used to find out who has one.
+func RequestPasswordReset(ctx context.Context, db *sqlx.DB, m *mailer.Sender, email string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resetMailTimeout)
+	go func() {
+		defer cancel()
+		if err := sendPasswordReset(ctx, db, m, email); err != nil {
+			log.Printf("password reset: %v", err)
+		}
+	}()
+}
+
+// sendPasswordReset is the work of RequestPasswordReset.
+func sendPasswordReset(ctx context.Context, db *sqlx.DB, m *mailer.Sender, email string) error {
+	var userID string
+	err := db.GetContext(ctx, &userID, "SELECT id FROM users WHERE email = $1", strings.TrimSpace(email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("lookup user: %w", err)
+	}
+
+	ttl := PasswordResetTTL()
+	token, err := issueEmailToken(ctx, db, userID, purposeResetPassword, ttl)
+	if err != nil {
+		return err
+	}
+	return m.Send(ctx, email, "reset_password", map[string]any{
+		"Email":     email,
+		"Token":     token,
+		"Link":      m.Link("/reset-password?token=" + url.QueryEscape(token)),
+		"ExpiresIn": ttl.String(),
+	})
+}
+
+// ResetPassword sets a new password with a reset token and signs the user
+// out everywhere, revoking their personal access tokens too. The password
+// must pass ValidatePassword. Opening the mailed link also proves the
+// address, so it is marked verified.
+func ResetPassword(ctx context.Context, db *sqlx.DB, token, password string) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	userID, err := consumeEmailToken(ctx, tx, purposeResetPassword, token)
+	if err != nil {
+		return err
+	}
+	var email string
+	if err := tx.GetContext(ctx, &email, "SELECT email FROM users WHERE id = $1", userID); err != nil {
+		return fmt.Errorf("lookup user: %w", err)
+	}
+	// a rejected password rolls back, so the link still works
+	if err := ValidatePassword(password, email); err != nil {
+		return err
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+UPDATE users SET password_hash = $2, email_verified_at = COALESCE(email_verified_at, now()) WHERE id = $1`, userID, hash)
+	if err != nil {
+		return fmt.Errorf("set password: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+UPDATE sessions SET revoked_at = now(), revoke_reason = 'password_reset'
+WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return fmt.Errorf("revoke sessions: %w", err)
+	}
+	// access tokens may have been made by whoever had the old password
+	_, err = tx.ExecContext(ctx, `
+UPDATE personal_access_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		return fmt.Errorf("revoke access tokens: %w", err)
+	}
+	// other reset links of this user stop working too
+	_, err = tx.ExecContext(ctx, `
+UPDATE email_tokens SET used_at = now() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`, userID, purposeResetPassword)
+	if err != nil {
+		return fmt.Errorf("expire reset tokens: %w", err)
+	}
+	return tx.Commit()
+}
+
+// issueEmailToken creates a token for purpose, replacing unused ones.
+func issueEmailToken(ctx context.Context, db *sqlx.DB, userID, purpose string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, "DELETE FROM email_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL", userID, purpose)
+	if err != nil {
+		return "", fmt.Errorf("delete old tokens: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO email_tokens (user_id, purpose, token_hash, expires_at) VALUES ($1, $2, $3, $4)`,
+		userID, purpose, hashSecret(token), time.Now().Add(ttl))
+	if err != nil {
+		return "", fmt.Errorf("store token: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("commit: %w", err)
+	}
+	return token, nil
+}
+
+// consumeEmailToken marks a live token used and returns its user.
+func consumeEmailToken(ctx context.Context, tx *sqlx.Tx, purpose, token string) (string, error) {
+	var userID string
+	err := tx.GetContext(ctx, &userID, `
+UPDATE email_tokens SET used_at = now()
+WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()
+RETURNING user_id`, hashSecret(token), purpose)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrInvalidEmailToken
+	}
+	if err != nil {
+		return "", fmt.Errorf("use token: %w", err)
+	}
+	return userID, nil
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	mailer "github.com/rishit911/file_vault_proj-backend/internal/mail"
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+func TestValidateEmail(t *testing.T) {
+	cases := map[string]bool{
+		"user@example.com":        true,
+		" user@example.com ":      true,
+		"User <user@example.com>": false,
+		"user@localhost":          false,
+		"not an email":            false,
+		"":                        false,
+	}
+	for in, ok := range cases {
+		if _, err := ValidateEmail(in); (err == nil) != ok {
+			t.Errorf("ValidateEmail(%q): err = %v", in, err)
+		}
+	}
+}
+
+// captureMailer keeps sent messages.
+type captureMailer struct{ sent []mailer.Message }
+
+func (c *captureMailer) Send(ctx context.Context, m mailer.Message) error {
+	c.sent = append(c.sent, m)
+	return nil
+}
+
+func TestEmailVerificationAndPasswordReset(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	var userID, email string
+	err := db.QueryRowx(`
+INSERT INTO users (email, password_hash) VALUES ('reset-' || gen_random_uuid() || '@example.com', 'x')
+RETURNING id, email`).Scan(&userID, &email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture := &captureMailer{}
+	m := &mailer.Sender{Mailer: capture, Templates: mailer.NewTemplates(""), BaseURL: "https://vault.test"}
+
+	// unverified users only read
+	pair, err := StartSession(ctx, db, userID, Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := Authenticate(ctx, db, pair.AccessToken, "")
+	if err != nil || id.Scopes.Has(ScopeFilesWrite) || !id.Scopes.Has(ScopeFilesRead) {
+		t.Fatalf("unverified identity = %+v, %v", id, err)
+	}
+
+	if err := SendVerificationEmail(ctx, db, m, userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyEmail(ctx, db, tokenFromLink(t, capture)); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := Authenticate(ctx, db, pair.AccessToken, ""); err != nil || id.Scopes != nil {
+		t.Errorf("verified identity = %+v, %v", id, err)
+	}
+
+	_, pat, err := CreatePAT(ctx, db, userID, PATInput{Name: "ci", Scopes: []string{ScopeFilesRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unknown addresses are not mailed, and not reported either
+	if err := sendPasswordReset(ctx, db, m, "nobody-"+email); err != nil || len(capture.sent) != 1 {
+		t.Fatalf("reset for unknown email: %v, %d sent", err, len(capture.sent))
+	}
+	if err := sendPasswordReset(ctx, db, m, email); err != nil {
+		t.Fatal(err)
+	}
+	token := tokenFromLink(t, capture)
+	if err := ResetPassword(ctx, db, token, "new password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ResetPassword(ctx, db, token, "again"); !errors.Is(err, ErrInvalidEmailToken) {
+		t.Errorf("reused reset token: err = %v, want ErrInvalidEmailToken", err)
+	}
+	if _, err := Authenticate(ctx, db, pair.AccessToken, ""); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("session after reset: err = %v, want ErrSessionRevoked", err)
+	}
+	if _, err := Authenticate(ctx, db, pat, ""); !errors.Is(err, ErrTokenRejected) {
+		t.Errorf("access token after reset: err = %v, want ErrTokenRejected", err)
+	}
+	var hash string
+	if err := db.Get(&hash, "SELECT password_hash FROM users WHERE id = $1", userID); err != nil || CompareHashAndPassword(hash, "new password") != nil {
+		t.Errorf("password was not changed: %v", err)
+	}
+}
+
+// chanMailer hands sent messages to a channel.
+type chanMailer chan mailer.Message
+
+func (c chanMailer) Send(ctx context.Context, m mailer.Message) error {
+	c <- m
+	return nil
+}
+
+func TestRequestPasswordResetOutlivesRequest(t *testing.T) {
+	db := testdb.Open(t)
+	var email string
+	err := db.Get(&email, `
+INSERT INTO users (email, password_hash) VALUES ('reset-' || gen_random_uuid() || '@example.com', 'x')
+RETURNING email`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM users WHERE email = $1", email) })
+	sent := make(chanMailer, 1)
+	m := &mailer.Sender{Mailer: sent, Templates: mailer.NewTemplates(""), BaseURL: "https://vault.test"}
+
+	// the request is over before the mail goes out
+	ctx, cancel := context.WithCancel(context.Background())
+	RequestPasswordReset(ctx, db, m, email)
+	cancel()
+	select {
+	case msg := <-sent:
+		if msg.To != email {
+			t.Errorf("mailed %q, want %q", msg.To, email)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no reset mail sent")
+	}
+}
+
+// tokenFromLink returns the token in the link of the last mail sent.
+func tokenFromLink(t *testing.T, c *captureMailer) string {
+	t.Helper()
+	if len(c.sent) == 0 {
+		t.Fatal("no mail sent")
+	}
+	body := c.sent[len(c.sent)-1].Body
+	for _, word := range strings.Fields(body) {
+		if u, err := url.Parse(word); err == nil && u.Query().Get("token") != "" {
+			return u.Query().Get("token")
+		}
+	}
+	t.Fatalf("no link in %q", body)
+	return ""
+}
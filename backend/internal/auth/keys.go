@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms for JWT_KEYS_DIR keys.
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+)
+
+// defaultJWTSecret signs tokens when nothing is configured. It is public, so
+// it is only accepted in dev mode (see LoadKeyringFromEnv).
+const defaultJWTSecret = "dev_jwt_secret"
+
+// placeholderSecrets are secrets from examples that must not reach
+// production.
+var placeholderSecrets = []string{defaultJWTSecret, "replace_with_a_secure_random_value"}
+
+// Keyring signs tokens with one key and verifies them with any of several,
+// so signing keys can be rotated without logging anyone out.
+type Keyring struct {
+	// signer signs new tokens; without one they are signed with HS256 and
+	// secret.
+	signer *signingKey
+	// public verifies tokens by their kid header.
+	public map[string]verifyKey
+	// secret verifies HS256 tokens without a kid, such as those issued
+	// before signing keys were configured.
+	secret []byte
+}
+
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	key    crypto.Signer
+}
+
+type verifyKey struct {
+	method jwt.SigningMethod
+	key    crypto.PublicKey
+}
+
+// DevMode reports whether APP_ENV is dev or development.
+func DevMode() bool {
+	switch strings.ToLower(os.Getenv("APP_ENV")) {
+	case "dev", "development":
+		return true
+	}
+	return false
+}
+
+// LoadKeyringFromEnv builds the keyring from JWT_KEYS_DIR and JWT_SECRET.
+//
+// JWT_KEYS_DIR holds one PEM file per key, named <kid>.pem: Ed25519 (EdDSA)
+// or RSA (RS256) private keys, or public keys that only verify. The
+// private key JWT_SIGNING_KID names, or the only one there is, signs new
+// tokens. JWT_SECRET, if set, keeps verifying HS256 tokens from before.
+//
+// Without JWT_KEYS_DIR tokens are signed with HS256 and JWT_SECRET. Outside
+// dev mode (see DevMode) an unset, default or example JWT_SECRET is an
+// error.
+func LoadKeyringFromEnv() (*Keyring, error) {
+	k := &Keyring{public: map[string]verifyKey{}}
+
+	secret, dir := os.Getenv("JWT_SECRET"), os.Getenv("JWT_KEYS_DIR")
+	if !DevMode() {
+		if slices.Contains(placeholderSecrets, secret) {
+			return nil, errors.New("JWT_SECRET is set to an example value; set a random secret or JWT_KEYS_DIR (or APP_ENV=development)")
+		}
+		if secret == "" && dir == "" {
+			return nil, errors.New("JWT_KEYS_DIR or JWT_SECRET is required (or APP_ENV=development)")
+		}
+	}
+	if secret == "" && dir == "" {
+		secret = defaultJWTSecret
+	}
+	if secret != "" {
+		k.secret = []byte(secret)
+	}
+
+	if dir != "" {
+		signers, err := k.loadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		kid := os.Getenv("JWT_SIGNING_KID")
+		switch {
+		case kid != "":
+			if k.signer = signers[kid]; k.signer == nil {
+				return nil, fmt.Errorf("JWT_SIGNING_KID %q: no private key %s.pem in %s", kid, kid, dir)
+			}
+		case len(signers) == 1:
+			for _, s := range signers {
+				k.signer = s
+			}
+		default:
+			return nil, fmt.Errorf("JWT_KEYS_DIR %s has %d private keys; choose one with JWT_SIGNING_KID", dir, len(signers))
+		}
+	}
+	return k, nil
+}
+
+// loadDir adds the keys in dir and returns the private ones by kid.
+func (k *Keyring) loadDir(dir string) (map[string]*signingKey, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	signers := map[string]*signingKey{}
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read signing key: %w", err)
+		}
+		key, err := parseKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		method, pub, err := keyMethod(key)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		k.public[kid] = verifyKey{method: method, key: pub}
+		if signer, ok := key.(crypto.Signer); ok {
+			signers[kid] = &signingKey{kid: kid, method: method, key: signer}
+		}
+	}
+	return signers, nil
+}
+
+func parseKey(data []byte) (any, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+// keyMethod returns the algorithm and public key for a parsed key.
+func keyMethod(key any) (jwt.SigningMethod, crypto.PublicKey, error) {
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA, key.Public(), nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, key, nil
+	case *rsa.PrivateKey:
+		return keyMethod(&key.PublicKey)
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			return nil, nil, errors.New("RSA keys must have at least 2048 bits")
+		}
+		return jwt.SigningMethodRS256, key, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported key type %T (use Ed25519 or RSA)", key)
+}
+
+// GenerateSigningKey returns a new private key for alg (EdDSA or RS256) in
+// PEM, for a <kid>.pem file in JWT_KEYS_DIR.
+func GenerateSigningKey(alg string) ([]byte, error) {
+	var key any
+	var err error
+	switch alg {
+	case AlgEdDSA:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return nil, fmt.Errorf("algorithm must be %s or %s", AlgEdDSA, AlgRS256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// Sign signs claims with the signing key, or with HS256 without one.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	if k.signer == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.secret)
+	}
+	token := jwt.NewWithClaims(k.signer.method, claims)
+	token.Header["kid"] = k.signer.kid
+	return token.SignedString(k.signer.key)
+}
+
+// Parse validates a token signed by any key of the keyring.
+func (k *Keyring) Parse(tokenStr string) (*jwt.Token, error) {
+	return jwt.Parse(tokenStr, k.keyFunc)
+}
+
+func (k *Keyring) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || k.secret == nil {
+			return nil, errors.New("unexpected signing method")
+		}
+		return k.secret, nil
+	}
+	key, ok := k.public[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key.key, nil
+}
+
+// JWKS returns the public keys as a JSON Web Key Set (RFC 7517), for
+// services that verify vault tokens. HS256 secrets are never included.
+func (k *Keyring) JWKS() []byte {
+	kids := make([]string, 0, len(k.public))
+	for kid := range k.public {
+		kids = append(kids, kid)
+	}
+	slices.Sort(kids)
+
+	keys := []map[string]string{}
+	for _, kid := range kids {
+		key := k.public[kid]
+		jwk := map[string]string{"kid": kid, "alg": key.method.Alg(), "use": "sig"}
+		switch pub := key.key.(type) {
+		case ed25519.PublicKey:
+			jwk["kty"], jwk["crv"], jwk["x"] = "OKP", "Ed25519", b64(pub)
+		case *rsa.PublicKey:
+			jwk["kty"], jwk["n"], jwk["e"] = "RSA", b64(pub.N.Bytes()), b64(big.NewInt(int64(pub.E)).Bytes())
+		}
+		keys = append(keys, jwk)
+	}
+	b, _ := json.Marshal(map[string]any{"keys": keys})
+	return b
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+var (
+	keyringMu sync.RWMutex
+	keyring   *Keyring
+)
+
+// SetKeyring makes k sign and verify all tokens. The server sets it at
+// startup from LoadKeyringFromEnv.
+func SetKeyring(k *Keyring) {
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+	keyring = k
+}
+
+// Keys returns the keyring set with SetKeyring. Until one is set (in tests
+// and tools) tokens use HS256 with JWT_SECRET, or the dev default.
+func Keys() *Keyring {
+	keyringMu.RLock()
+	k := keyring
+	keyringMu.RUnlock()
+	if k != nil {
+		return k
+	}
+
+	keyringMu.Lock()
+	defer keyringMu.Unlock()
+	if keyring == nil {
+		keyring = &Keyring{secret: []byte(getEnv("JWT_SECRET", defaultJWTSecret))}
+	}
+	return keyring
+}
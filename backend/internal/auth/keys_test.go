@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKey generates a key for alg into dir as <kid>.pem, as a public key
+// when public is set.
+func writeKey(t *testing.T, dir, kid, alg string, public bool) {
+	t.Helper()
+	data, err := GenerateSigningKey(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public {
+		key, _ := parseKey(data)
+		_, pub, _ := keyMethod(key)
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useKeyring loads the keyring from the environment and makes it current
+// for the test.
+func useKeyring(t *testing.T) *Keyring {
+	t.Helper()
+	k, err := LoadKeyringFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeyring(k)
+	t.Cleanup(func() { SetKeyring(nil) })
+	return k
+}
+
+func TestKeyringRotation(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("JWT_KEYS_DIR", dir)
+	t.Setenv("JWT_SECRET", "legacy secret")
+	t.Setenv("JWT_SIGNING_KID", "")
+
+	// before keys: HS256 without a kid
+	SetKeyring(&Keyring{secret: []byte("legacy secret")})
+	legacy, err := GenerateAccessToken("user-1", "session-1", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeKey(t, dir, "old", AlgEdDSA, false)
+	useKeyring(t)
+	old, err := GenerateAccessToken("user-1", "session-1", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new key, signing; the old one still verifies
+	writeKey(t, dir, "new", AlgRS256, false)
+	t.Setenv("JWT_SIGNING_KID", "new")
+	k := useKeyring(t)
+	current, err := GenerateAccessToken("user-1", "session-1", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"legacy": legacy, "old": old, "current": current} {
+		if _, _, err := ParseAccessToken(token); err != nil {
+			t.Errorf("%s token rejected: %v", name, err)
+		}
+	}
+	if token, _ := k.Parse(current); token.Header["kid"] != "new" || token.Method.Alg() != AlgRS256 {
+		t.Errorf("signed with kid %v, alg %s; want new, RS256", token.Header["kid"], token.Method.Alg())
+	}
+
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(k.JWKS(), &jwks); err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 2 || jwks.Keys[0]["kid"] != "new" || jwks.Keys[0]["kty"] != "RSA" ||
+		jwks.Keys[1]["kid"] != "old" || jwks.Keys[1]["crv"] != "Ed25519" {
+		t.Errorf("JWKS = %v", jwks.Keys)
+	}
+
+	// the old key retired: its tokens stop working, and without JWT_SECRET
+	// HS256 ones too
+	if err := os.Remove(filepath.Join(dir, "old.pem")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("JWT_SECRET", "")
+	useKeyring(t)
+	if _, _, err := ParseAccessToken(old); err == nil {
+		t.Error("token of a removed key accepted")
+	}
+	if _, _, err := ParseAccessToken(legacy); err == nil {
+		t.Error("HS256 token accepted without JWT_SECRET")
+	}
+	if _, _, err := ParseAccessToken(current); err != nil {
+		t.Errorf("current token rejected: %v", err)
+	}
+}
+
+func TestKeyringPublicKeysOnlyVerify(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("JWT_KEYS_DIR", dir)
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("JWT_SIGNING_KID", "")
+
+	writeKey(t, dir, "other-instance", AlgEdDSA, true)
+	if _, err := LoadKeyringFromEnv(); err == nil {
+		t.Error("loaded a keyring without a private key")
+	}
+	writeKey(t, dir, "mine", AlgEdDSA, false)
+	k := useKeyring(t)
+	if k.signer.kid != "mine" || len(k.public) != 2 {
+		t.Errorf("signer %q, %d verification keys", k.signer.kid, len(k.public))
+	}
+}
+
+func TestKeyringRefusesDefaultSecret(t *testing.T) {
+	t.Setenv("JWT_KEYS_DIR", "")
+	cases := []struct {
+		env, secret string
+		ok          bool
+	}{
+		{"", "", false},
+		{"", "dev_jwt_secret", false},
+		{"production", "replace_with_a_secure_random_value", false},
+		{"", "a real secret", true},
+		{"development", "", true},
+		{"dev", "dev_jwt_secret", true},
+	}
+	for _, c := range cases {
+		t.Setenv("APP_ENV", c.env)
+		t.Setenv("JWT_SECRET", c.secret)
+		if _, err := LoadKeyringFromEnv(); (err == nil) != c.ok {
+			t.Errorf("APP_ENV=%q JWT_SECRET=%q: err = %v", c.env, c.secret, err)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrInvalidCredentials is returned for a wrong password and for an
+	// unknown email alike.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrUserNotFound       = errors.New("user not found")
+)
+
+// ThrottledError is returned for logins attempted too soon after failed
+// ones, whether or not the account exists.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("too many failed logins; try again in %s", e.RetryAfter)
+}
+
+// LoginPolicy decides how failed logins slow down further attempts.
+type LoginPolicy struct {
+	// After BackoffAfter failures of an account (IPBackoffAfter of a client
+	// address), each failure makes the next attempt wait 1s, 2s, 4s, ... up
+	// to MaxBackoff.
+	BackoffAfter   int
+	IPBackoffAfter int
+	MaxBackoff     time.Duration
+	// LockoutAfter failures lock the account for LockoutDuration; 0 never
+	// locks. The count starts over after a lockout.
+	LockoutAfter    int
+	LockoutDuration time.Duration
+	// Window is how long failures are remembered.
+	Window time.Duration
+	// MFATokenAttempts is how many codes one mfa_token may be tried with.
+	// Failed second factors also count against the user like failed
+	// passwords, under their own key, so a correct password does not reset
+	// them.
+	MFATokenAttempts int
+}
+
+// LoginPolicyFromEnv reads LOGIN_BACKOFF_AFTER (5), LOGIN_IP_BACKOFF_AFTER
+// (20), LOGIN_MAX_BACKOFF (15m), LOGIN_LOCKOUT_AFTER (10),
+// LOGIN_LOCKOUT_DURATION (30m), LOGIN_FAILURE_WINDOW (1h) and
+// MFA_TOKEN_MAX_ATTEMPTS (5).
+func LoginPolicyFromEnv() LoginPolicy {
+	return LoginPolicy{
+		BackoffAfter:     envInt("LOGIN_BACKOFF_AFTER", 5),
+		IPBackoffAfter:   envInt("LOGIN_IP_BACKOFF_AFTER", 20),
+		MaxBackoff:       envDuration("LOGIN_MAX_BACKOFF", 15*time.Minute),
+		LockoutAfter:     envInt("LOGIN_LOCKOUT_AFTER", 10),
+		LockoutDuration:  envDuration("LOGIN_LOCKOUT_DURATION", 30*time.Minute),
+		Window:           envDuration("LOGIN_FAILURE_WINDOW", time.Hour),
+		MFATokenAttempts: envInt("MFA_TOKEN_MAX_ATTEMPTS", 5),
+	}
+}
+
+// backoff is how long to wait after the given number of failures.
+func (p LoginPolicy) backoff(failures, after int) time.Duration {
+	if after <= 0 || failures < after {
+		return 0
+	}
+	n := failures - after
+	if n >= 30 {
+		return p.MaxBackoff
+	}
+	return min(time.Second<<n, p.MaxBackoff)
+}
+
+// CheckPassword is the password step of a login. Failures are counted per
+// account and per client address; once they pile up CheckPassword returns
+// a *ThrottledError without looking at the password. Unknown emails are
+// counted, hashed against and answered exactly like wrong passwords, so
+// neither the responses nor their timing tell which emails have accounts.
+// Suspended users get ErrAccountSuspended once the password checks out.
+// Every attempt is recorded in the login audit trail.
+func CheckPassword(ctx context.Context, db *sqlx.DB, email, password string, dev Device) (string, error) {
+	email = strings.TrimSpace(email)
+	keys := throttleKeys(email, dev.IP)
+
+	var u struct {
+		ID        string `db:"id"`
+		Hash      string `db:"password_hash"`
+		Suspended bool   `db:"suspended"`
+	}
+	err := db.GetContext(ctx, &u, `
+SELECT id, COALESCE(password_hash, '') AS password_hash, suspended_at IS NOT NULL AS suspended
+FROM users WHERE email = $1`, email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("lookup user: %w", err)
+	}
+
+	wait, err := throttled(ctx, db, keys)
+	if err != nil {
+		return "", err
+	}
+	if wait > 0 {
+		recordLoginEvent(ctx, db, &LoginEvent{Event: EventLoginThrottled, Email: email, UserID: optional(u.ID)}, dev)
+		return "", &ThrottledError{RetryAfter: wait}
+	}
+
+	hash := u.Hash
+	if hash == "" {
+		// accounts without a password (single sign-on) and unknown emails
+		hash = dummyHash()
+	}
+	if CompareHashAndPassword(hash, password) != nil || u.Hash == "" {
+		if err := recordLoginFailure(ctx, db, LoginPolicyFromEnv(), keys, email, u.ID, dev); err != nil {
+			return "", err
+		}
+		return "", ErrInvalidCredentials
+	}
+	if u.Suspended {
+		// only told to those who know the password
+		return "", ErrAccountSuspended
+	}
+
+	// the account starts over; the address keeps its count so one known
+	// password cannot be used to keep guessing others
+	if _, err := db.ExecContext(ctx, "DELETE FROM login_throttles WHERE key = $1", keys[0]); err != nil {
+		return "", fmt.Errorf("reset login failures: %w", err)
+	}
+	recordLoginEvent(ctx, db, &LoginEvent{Event: EventLoginSucceeded, Email: email, UserID: &u.ID}, dev)
+	if NeedsRehash(u.Hash) {
+		rehash(ctx, db, u.ID, u.Hash, password)
+	}
+	return u.ID, nil
+}
+
+// rehash upgrades a user's password hash to the current algorithm and
+// parameters (see NeedsRehash). It is skipped if the hash changed
+// meanwhile, and failures only cost the upgrade.
+func rehash(ctx context.Context, db *sqlx.DB, userID, oldHash, password string) {
+	hash, err := HashPassword(password)
+	if err == nil {
+		_, err = db.ExecContext(ctx, "UPDATE users SET password_hash = $3 WHERE id = $1 AND password_hash = $2", userID, oldHash, hash)
+	}
+	if err != nil {
+		log.Printf("rehash password of %s: %v", userID, err)
+	}
+}
+
+// UnlockAccount forgets the failed logins and second factors of a user's
+// account, ending a lockout. actorID is the admin doing it.
+func UnlockAccount(ctx context.Context, db *sqlx.DB, userID, actorID string) error {
+	var email string
+	err := db.GetContext(ctx, &email, "SELECT email FROM users WHERE id = $1", userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("lookup user: %w", err)
+	}
+	_, err = db.ExecContext(ctx, "DELETE FROM login_throttles WHERE key = ANY($1)",
+		pq.Array([]string{accountKey(email), mfaUserKey(userID)}))
+	if err != nil {
+		return fmt.Errorf("unlock account: %w", err)
+	}
+	recordLoginEvent(ctx, db, &LoginEvent{Event: EventAccountUnlocked, Email: email, UserID: &userID, ActorID: &actorID}, Device{})
+	return nil
+}
+
+// CheckSharePassword checks the password of a protected share link. Wrong
+// passwords are counted per link with the backoff of failed logins (no
+// lockout), so a link's password cannot be guessed from many addresses;
+// once they pile up it returns a *ThrottledError without checking.
+func CheckSharePassword(ctx context.Context, db *sqlx.DB, shareID, hash, password string) error {
+	key := "share:" + shareID
+	wait, err := throttled(ctx, db, []string{key})
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		return &ThrottledError{RetryAfter: wait}
+	}
+	if CompareHashAndPassword(hash, password) == nil {
+		return nil
+	}
+	p := LoginPolicyFromEnv()
+	if _, err := countFailure(ctx, db, p, key, p.BackoffAfter, false); err != nil {
+		return err
+	}
+	return ErrInvalidCredentials
+}
+
+func accountKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// throttleKeys returns the account key first.
+func throttleKeys(email, ip string) []string {
+	keys := []string{accountKey(email)}
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	return keys
+}
+
+// throttled returns how long the longest wait among keys still lasts.
+func throttled(ctx context.Context, db *sqlx.DB, keys []string) (time.Duration, error) {
+	var secs sql.NullFloat64
+	err := db.GetContext(ctx, &secs, `
+SELECT EXTRACT(EPOCH FROM MAX(locked_until) - now())::float8 FROM login_throttles
+WHERE key = ANY($1) AND locked_until > now()`, pq.Array(keys))
+	if err != nil {
+		return 0, fmt.Errorf("check login throttle: %w", err)
+	}
+	if !secs.Valid {
+		return 0, nil
+	}
+	return time.Duration(math.Ceil(secs.Float64)) * time.Second, nil
+}
+
+// recordLoginFailure counts a failure against each key and sets its wait.
+func recordLoginFailure(ctx context.Context, db *sqlx.DB, p LoginPolicy, keys []string, email, userID string, dev Device) error {
+	recordLoginEvent(ctx, db, &LoginEvent{Event: EventLoginFailed, Email: email, UserID: optional(userID)}, dev)
+
+	for i, key := range keys {
+		after := p.IPBackoffAfter
+		if i == 0 {
+			after = p.BackoffAfter
+		}
+		locked, err := countFailure(ctx, db, p, key, after, i == 0)
+		if err != nil {
+			return err
+		}
+		if locked {
+			recordLoginEvent(ctx, db, &LoginEvent{Event: EventAccountLocked, Email: email, UserID: optional(userID)}, dev)
+		}
+	}
+	return nil
+}
+
+// countFailure counts a failure against key and sets its wait: backoff after
+// backoffAfter failures, or a lockout if canLock and the policy's lockout
+// count is reached. It reports whether key is now locked out.
+func countFailure(ctx context.Context, db *sqlx.DB, p LoginPolicy, key string, backoffAfter int, canLock bool) (bool, error) {
+	var failures int
+	err := db.GetContext(ctx, &failures, `
+INSERT INTO login_throttles (key, failures, last_failure_at) VALUES ($1, 1, now())
+ON CONFLICT (key) DO UPDATE SET
+	failures = CASE WHEN login_throttles.last_failure_at < now() - make_interval(secs => $2)
+		THEN 1 ELSE login_throttles.failures + 1 END,
+	last_failure_at = now()
+RETURNING failures`, key, p.Window.Seconds())
+	if err != nil {
+		return false, fmt.Errorf("count login failure: %w", err)
+	}
+
+	lockout := canLock && p.LockoutAfter > 0 && failures >= p.LockoutAfter
+	wait := p.LockoutDuration
+	if !lockout {
+		wait = p.backoff(failures, backoffAfter)
+	}
+	if wait <= 0 {
+		return false, nil
+	}
+	_, err = db.ExecContext(ctx, `
+UPDATE login_throttles SET locked_until = now() + make_interval(secs => $2), lockout = $3,
+	failures = CASE WHEN $3 THEN 0 ELSE failures END
+WHERE key = $1`, key, wait.Seconds(), lockout)
+	if err != nil {
+		return false, fmt.Errorf("throttle logins: %w", err)
+	}
+	return lockout, nil
+}
+
+// PurgeLoginThrottles deletes failure counts that are no longer locked and
+// have been forgotten (LOGIN_FAILURE_WINDOW, and at least as long as an
+// mfa_token lives) and returns how many were deleted.
+func PurgeLoginThrottles(ctx context.Context, db *sqlx.DB) (int64, error) {
+	keep := max(LoginPolicyFromEnv().Window, mfaTokenTTL)
+	res, err := db.ExecContext(ctx, `
+DELETE FROM login_throttles
+WHERE last_failure_at < now() - make_interval(secs => $1) AND (locked_until IS NULL OR locked_until < now())`,
+		keep.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("purge login throttles: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// dummyHash is compared against for logins without a stored hash, so they
+// take as long as real ones.
+var dummyHash = sync.OnceValue(func() string {
+	h, _ := HashPassword("not the password of any account")
+	return h
+})
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func envInt(k string, def int) int {
+	n, err := strconv.Atoi(getEnv(k, ""))
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Events recorded in login_events.event.
+const (
+	// EventLoginSucceeded is a correct password; a second factor may follow.
+	EventLoginSucceeded  = "login_succeeded"
+	EventLoginFailed     = "login_failed"
+	EventLoginThrottled  = "login_throttled"
+	EventAccountLocked   = "account_locked"
+	EventAccountUnlocked = "account_unlocked"
+	// EventMFAFailed is a wrong second factor after a correct password.
+	EventMFAFailed = "mfa_failed"
+)
+
+// LoginEvent is one row of the login audit trail.
+type LoginEvent struct {
+	ID    string `db:"id"`
+	Event string `db:"event"`
+	// Email is as entered; UserID is nil when no account has it.
+	Email  string  `db:"email"`
+	UserID *string `db:"user_id"`
+	// ActorID is the admin behind an account_unlocked event.
+	ActorID   *string   `db:"actor_id"`
+	IP        *string   `db:"ip"`
+	UserAgent *string   `db:"user_agent"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// recordLoginEvent appends ev to the audit trail. A failure to record is
+// logged rather than failing the login.
+func recordLoginEvent(ctx context.Context, db *sqlx.DB, ev *LoginEvent, dev Device) {
+	ev.IP, ev.UserAgent = optional(dev.IP), optional(dev.UserAgent)
+	_, err := db.NamedExecContext(ctx, `
+INSERT INTO login_events (event, email, user_id, actor_id, ip, user_agent)
+VALUES (:event, :email, :user_id, :actor_id, :ip, :user_agent)`, ev)
+	if err != nil {
+		log.Printf("record login event %s: %v", ev.Event, err)
+	}
+}
+
+// LoginEventFilter narrows ListLoginEvents; zero fields match everything.
+type LoginEventFilter struct {
+	UserID *string
+	// Email matches case-insensitively.
+	Email *string
+	Event *string
+	IP    *string
+	From  *time.Time
+	To    *time.Time
+}
+
+func (f *LoginEventFilter) where(args *[]any) string {
+	parts := []string{"true"}
+	add := func(cond string, v any) {
+		*args = append(*args, v)
+		parts = append(parts, fmt.Sprintf(cond, len(*args)))
+	}
+	if f.UserID != nil {
+		add("user_id = $%d", *f.UserID)
+	}
+	if f.Email != nil {
+		add("lower(email) = lower($%d)", strings.TrimSpace(*f.Email))
+	}
+	if f.Event != nil {
+		add("event = $%d", *f.Event)
+	}
+	if f.IP != nil {
+		add("ip = $%d", *f.IP)
+	}
+	if f.From != nil {
+		add("created_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		add("created_at <= $%d", *f.To)
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// ListLoginEvents returns a page of matching events, newest first, and the
+// total number of matches.
+func ListLoginEvents(ctx context.Context, db *sqlx.DB, filter LoginEventFilter, limit, offset int) ([]LoginEvent, int, error) {
+	args := []any{}
+	where := filter.where(&args)
+
+	var total int
+	if err := db.GetContext(ctx, &total, "SELECT COUNT(*) FROM login_events WHERE "+where, args...); err != nil {
+		return nil, 0, fmt.Errorf("count login events: %w", err)
+	}
+
+	events := []LoginEvent{}
+	err := db.SelectContext(ctx, &events, fmt.Sprintf(`
+SELECT id, event, email, user_id, actor_id, ip, user_agent, created_at FROM login_events
+WHERE %s ORDER BY created_at DESC LIMIT $%d OFFSET $%d`, where, len(args)+1, len(args)+2),
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list login events: %w", err)
+	}
+	return events, total, nil
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	p := LoginPolicy{MaxBackoff: time.Minute}
+	cases := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{4, 0},
+		{5, time.Second},
+		{6, 2 * time.Second},
+		{10, 32 * time.Second},
+		{11, time.Minute},
+		{100, time.Minute},
+	}
+	for _, c := range cases {
+		if got := p.backoff(c.failures, 5); got != c.want {
+			t.Errorf("backoff(%d) = %s, want %s", c.failures, got, c.want)
+		}
+	}
+}
+
+func TestCheckPasswordLockout(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	t.Setenv("LOGIN_BACKOFF_AFTER", "0")
+	t.Setenv("LOGIN_IP_BACKOFF_AFTER", "0")
+	t.Setenv("LOGIN_LOCKOUT_AFTER", "3")
+
+	hash, err := HashPassword("right password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userID, email string
+	err = db.QueryRowx(`
+INSERT INTO users (email, password_hash) VALUES ('lockout-' || gen_random_uuid() || '@example.com', $1)
+RETURNING id, email`, hash).Scan(&userID, &email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := Device{IP: "192.0.2.10", UserAgent: "test"}
+
+	if id, err := CheckPassword(ctx, db, email, "right password", dev); err != nil || id != userID {
+		t.Fatalf("CheckPassword = %q, %v", id, err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := CheckPassword(ctx, db, email, "wrong", dev); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("failure %d: err = %v, want ErrInvalidCredentials", i+1, err)
+		}
+	}
+	// locked: even the right password is turned away
+	var throttled *ThrottledError
+	if _, err := CheckPassword(ctx, db, email, "right password", dev); !errors.As(err, &throttled) || throttled.RetryAfter <= 0 {
+		t.Fatalf("locked account: err = %v, want *ThrottledError", err)
+	}
+	// unknown emails are counted and answered the same way
+	unknown := "nobody-" + email
+	for i := 0; i < 3; i++ {
+		if _, err := CheckPassword(ctx, db, unknown, "wrong", dev); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("unknown email failure %d: err = %v", i+1, err)
+		}
+	}
+	if _, err := CheckPassword(ctx, db, unknown, "wrong", dev); !errors.As(err, &throttled) {
+		t.Fatalf("unknown email: err = %v, want *ThrottledError", err)
+	}
+
+	if err := UnlockAccount(ctx, db, userID, userID); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := CheckPassword(ctx, db, email, "right password", dev); err != nil || id != userID {
+		t.Fatalf("after unlock: CheckPassword = %q, %v", id, err)
+	}
+
+	events, total, err := ListLoginEvents(ctx, db, LoginEventFilter{UserID: &userID}, 20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{EventLoginSucceeded, EventAccountUnlocked, EventLoginThrottled, EventAccountLocked, EventLoginFailed, EventLoginFailed, EventLoginFailed, EventLoginSucceeded}
+	if total != len(want) {
+		t.Fatalf("got %d events, want %d", total, len(want))
+	}
+	for i, ev := range events {
+		if ev.Event != want[i] {
+			t.Errorf("event %d = %s, want %s", i, ev.Event, want[i])
+		}
+	}
+}
+
+func TestCheckSharePasswordBackoff(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	t.Setenv("LOGIN_BACKOFF_AFTER", "2")
+
+	hash, err := HashPassword("share password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var shareID string
+	if err := db.Get(&shareID, "SELECT gen_random_uuid()::text"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSharePassword(ctx, db, shareID, hash, "share password"); err != nil {
+		t.Fatalf("right password: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := CheckSharePassword(ctx, db, shareID, hash, "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("failure %d: err = %v, want ErrInvalidCredentials", i+1, err)
+		}
+	}
+	var throttled *ThrottledError
+	if err := CheckSharePassword(ctx, db, shareID, hash, "share password"); !errors.As(err, &throttled) {
+		t.Fatalf("after failures: err = %v, want *ThrottledError", err)
+	}
+	// other links are not affected
+	var other string
+	db.Get(&other, "SELECT gen_random_uuid()::text")
+	if err := CheckSharePassword(ctx, db, other, hash, "share password"); err != nil {
+		t.Errorf("other link: %v", err)
+	}
+}
@@ -0,0 +1,394 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrMFAAlreadyEnabled   = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrNoPendingEnrollment = errors.New("no two-factor enrollment in progress")
+	ErrInvalidMFACode      = errors.New("invalid two-factor code")
+	ErrInvalidMFAToken     = errors.New("invalid or expired mfa token")
+	// ErrMFARequired is returned when disabling two-factor authentication
+	// that the user's role requires.
+	ErrMFARequired = errors.New("two-factor authentication is required for this role")
+	ErrUnknownRole = errors.New("role must be user or admin")
+)
+
+// mfaTokenTTL is how long the second login step may take.
+const mfaTokenTTL = 5 * time.Minute
+
+const recoveryCodeCount = 10
+
+// MFAState is whether a user has two-factor authentication and whether their
+// role requires it.
+type MFAState struct {
+	Enabled  bool `db:"enabled"`
+	Required bool `db:"required"`
+}
+
+// Pending reports whether logging in needs a second step.
+func (s MFAState) Pending() bool {
+	return s.Enabled || s.Required
+}
+
+// GetMFAState returns userID's two-factor state.
+func GetMFAState(ctx context.Context, db sqlx.QueryerContext, userID string) (MFAState, error) {
+	var s MFAState
+	err := sqlx.GetContext(ctx, db, &s, `
+SELECT u.totp_enabled_at IS NOT NULL AS enabled, COALESCE(p.require_mfa, false) AS required
+FROM users u LEFT JOIN role_policies p ON p.role = u.role
+WHERE u.id = $1`, userID)
+	if err != nil {
+		return MFAState{}, fmt.Errorf("lookup mfa state: %w", err)
+	}
+	return s, nil
+}
+
+// GenerateMFAToken issues the partial token a password login returns when a
+// second factor is needed. It has no session, so it is not an access token;
+// it can only be exchanged through VerifyMFA. Its jti identifies it for the
+// attempt limit (see LoginPolicy.MFATokenAttempts).
+func GenerateMFAToken(userID string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate mfa token id: %w", err)
+	}
+	claims := jwt.MapClaims{
+		"sub": userID,
+		"jti": hex.EncodeToString(b),
+		"mfa": true,
+		"exp": time.Now().Add(mfaTokenTTL).Unix(),
+	}
+	return Keys().Sign(claims)
+}
+
+// ParseMFAToken validates a token from GenerateMFAToken and returns its user.
+func ParseMFAToken(tokenStr string) (string, error) {
+	userID, _, err := parseMFAToken(tokenStr)
+	return userID, err
+}
+
+// parseMFAToken returns the user and the jti of an mfa_token.
+func parseMFAToken(tokenStr string) (string, string, error) {
+	token, err := Keys().Parse(tokenStr)
+	if err != nil {
+		return "", "", ErrInvalidMFAToken
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	sub, _ := claims["sub"].(string)
+	jti, _ := claims["jti"].(string)
+	mfa, _ := claims["mfa"].(bool)
+	if sub == "" || jti == "" || !mfa {
+		return "", "", ErrInvalidMFAToken
+	}
+	return sub, jti, nil
+}
+
+// TOTPEnrollment is a secret waiting for its first code.
+type TOTPEnrollment struct {
+	Secret string
+	// URI is the otpauth:// URI for authenticator apps.
+	URI string
+}
+
+// BeginTOTPEnrollment generates a TOTP secret for userID. It only takes
+// effect once ConfirmTOTPEnrollment sees a code for it; starting over
+// replaces an unconfirmed secret.
+func BeginTOTPEnrollment(ctx context.Context, db *sqlx.DB, userID string) (*TOTPEnrollment, error) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	var email string
+	err = db.GetContext(ctx, &email, `
+UPDATE users SET totp_pending_secret = $2
+WHERE id = $1 AND totp_enabled_at IS NULL RETURNING email`, userID, secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if err != nil {
+		return nil, fmt.Errorf("start totp enrollment: %w", err)
+	}
+	return &TOTPEnrollment{Secret: secret, URI: TOTPURI(secret, email)}, nil
+}
+
+// ConfirmTOTPEnrollment enables the pending secret if code matches it and
+// returns new recovery codes, which are only shown this once.
+func ConfirmTOTPEnrollment(ctx context.Context, db *sqlx.DB, userID, code string) ([]string, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var u struct {
+		Pending *string `db:"totp_pending_secret"`
+		Enabled bool    `db:"enabled"`
+	}
+	err = tx.GetContext(ctx, &u, `
+SELECT totp_pending_secret, totp_enabled_at IS NOT NULL AS enabled FROM users WHERE id = $1 FOR UPDATE`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("lookup user: %w", err)
+	}
+	if u.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if u.Pending == nil {
+		return nil, ErrNoPendingEnrollment
+	}
+	step, ok := matchTOTP(*u.Pending, code, clock())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	_, err = tx.ExecContext(ctx, `
+UPDATE users SET totp_secret = totp_pending_secret, totp_pending_secret = NULL,
+	totp_enabled_at = now(), totp_last_step = $2
+WHERE id = $1`, userID, step)
+	if err != nil {
+		return nil, fmt.Errorf("enable totp: %w", err)
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off after checking a current
+// code or a recovery code.
+func DisableTOTP(ctx context.Context, db *sqlx.DB, userID, code string) error {
+	state, err := GetMFAState(ctx, db, userID)
+	if err != nil {
+		return err
+	}
+	if !state.Enabled {
+		return ErrMFANotEnabled
+	}
+	if state.Required {
+		return ErrMFARequired
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := checkSecondFactor(ctx, tx, userID, code); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+UPDATE users SET totp_secret = NULL, totp_pending_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL
+WHERE id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("disable totp: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("delete recovery codes: %w", err)
+	}
+	return tx.Commit()
+}
+
+// VerifyMFA completes a login: it checks code, a TOTP or recovery code,
+// for the user of mfaToken and returns them. A user whose role requires
+// two-factor authentication but who has not enabled it yet confirms their
+// enrollment (see BeginTOTPEnrollment) here instead, and gets their recovery
+// codes back.
+//
+// Each mfaToken can be tried LoginPolicy.MFATokenAttempts times, after which
+// it is ErrInvalidMFAToken. Wrong codes are also counted against the user,
+// who gets a *ThrottledError after too many of them, whichever token is used.
+func VerifyMFA(ctx context.Context, db *sqlx.DB, mfaToken, code string, dev Device) (string, []string, error) {
+	userID, jti, err := parseMFAToken(mfaToken)
+	if err != nil {
+		return "", nil, err
+	}
+	p := LoginPolicyFromEnv()
+	if err := useMFAToken(ctx, db, p, jti); err != nil {
+		return "", nil, err
+	}
+	wait, err := throttled(ctx, db, []string{mfaUserKey(userID)})
+	if err != nil {
+		return "", nil, err
+	}
+	if wait > 0 {
+		return "", nil, &ThrottledError{RetryAfter: wait}
+	}
+
+	id, codes, err := verifyMFA(ctx, db, userID, code)
+	if errors.Is(err, ErrInvalidMFACode) {
+		if err := recordMFAFailure(ctx, db, p, userID, dev); err != nil {
+			return "", nil, err
+		}
+	}
+	return id, codes, err
+}
+
+// useMFAToken counts an attempt with the mfa_token jti and fails once the
+// token has been tried too often. Attempts rather than failures are counted,
+// so concurrent guesses cannot get past the limit.
+func useMFAToken(ctx context.Context, db *sqlx.DB, p LoginPolicy, jti string) error {
+	var attempts int
+	err := db.GetContext(ctx, &attempts, `
+INSERT INTO login_throttles (key, failures, last_failure_at) VALUES ($1, 1, now())
+ON CONFLICT (key) DO UPDATE SET failures = login_throttles.failures + 1, last_failure_at = now()
+RETURNING failures`, mfaTokenKey(jti))
+	if err != nil {
+		return fmt.Errorf("count mfa attempt: %w", err)
+	}
+	if attempts > p.MFATokenAttempts {
+		return ErrInvalidMFAToken
+	}
+	return nil
+}
+
+// recordMFAFailure counts a wrong second factor against userID, with the
+// backoff and lockout of failed passwords.
+func recordMFAFailure(ctx context.Context, db *sqlx.DB, p LoginPolicy, userID string, dev Device) error {
+	var email string
+	if err := db.GetContext(ctx, &email, "SELECT email FROM users WHERE id = $1", userID); err != nil {
+		return fmt.Errorf("lookup user: %w", err)
+	}
+	recordLoginEvent(ctx, db, &LoginEvent{Event: EventMFAFailed, Email: email, UserID: &userID}, dev)
+	locked, err := countFailure(ctx, db, p, mfaUserKey(userID), p.BackoffAfter, true)
+	if err != nil {
+		return err
+	}
+	if locked {
+		recordLoginEvent(ctx, db, &LoginEvent{Event: EventAccountLocked, Email: email, UserID: &userID}, dev)
+	}
+	return nil
+}
+
+func mfaUserKey(userID string) string {
+	return "mfa:" + userID
+}
+
+func mfaTokenKey(jti string) string {
+	return "mfa-token:" + jti
+}
+
+func verifyMFA(ctx context.Context, db *sqlx.DB, userID, code string) (string, []string, error) {
+	state, err := GetMFAState(ctx, db, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if !state.Enabled {
+		if !state.Required {
+			return "", nil, ErrMFANotEnabled
+		}
+		codes, err := ConfirmTOTPEnrollment(ctx, db, userID, code)
+		if err != nil {
+			return "", nil, err
+		}
+		return userID, codes, nil
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+	if err := checkSecondFactor(ctx, tx, userID, code); err != nil {
+		return "", nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", nil, fmt.Errorf("commit: %w", err)
+	}
+	return userID, nil, nil
+}
+
+// SetMFARequired sets whether users with role must use two-factor
+// authentication. It applies from their next login.
+func SetMFARequired(ctx context.Context, db *sqlx.DB, role string, required bool) error {
+	if role != "user" && role != "admin" {
+		return ErrUnknownRole
+	}
+	_, err := db.ExecContext(ctx, `
+INSERT INTO role_policies (role, require_mfa) VALUES ($1, $2)
+ON CONFLICT (role) DO UPDATE SET require_mfa = EXCLUDED.require_mfa, updated_at = now()`, role, required)
+	if err != nil {
+		return fmt.Errorf("set role policy: %w", err)
+	}
+	return nil
+}
+
+// checkSecondFactor accepts a TOTP code newer than the last one used, or an
+// unused recovery code, which is then spent.
+func checkSecondFactor(ctx context.Context, tx *sqlx.Tx, userID, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		var secret string
+		if err := tx.GetContext(ctx, &secret, "SELECT totp_secret FROM users WHERE id = $1 FOR UPDATE", userID); err != nil {
+			return fmt.Errorf("lookup totp secret: %w", err)
+		}
+		step, ok := matchTOTP(secret, code, clock())
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		res, err := tx.ExecContext(ctx, `
+UPDATE users SET totp_last_step = $2 WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)`, userID, step)
+		if err != nil {
+			return fmt.Errorf("record totp step: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			// replayed
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	res, err := tx.ExecContext(ctx, `
+UPDATE recovery_codes SET used_at = now()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, hashSecret(normalizeRecoveryCode(code)))
+	if err != nil {
+		return fmt.Errorf("use recovery code: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sqlx.Tx, userID string) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, fmt.Errorf("delete recovery codes: %w", err)
+	}
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("generate recovery code: %w", err)
+		}
+		c := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+		codes[i] = c[:5] + "-" + c[5:]
+		_, err := tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, hashSecret(normalizeRecoveryCode(codes[i])))
+		if err != nil {
+			return nil, fmt.Errorf("store recovery code: %w", err)
+		}
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrPasswordMismatch = errors.New("password does not match")
+	ErrUnknownHash      = errors.New("unknown password hash format")
+
+	ErrPasswordTooLong = errors.New("password is too long")
+	ErrPasswordBanned  = errors.New("password is too common")
+	ErrPasswordIsEmail = errors.New("password must not be the email address")
+)
+
+// PasswordTooShortError is returned for passwords under the minimum length.
+type PasswordTooShortError struct {
+	Min int
+}
+
+func (e *PasswordTooShortError) Error() string {
+	return fmt.Sprintf("password must have at least %d characters", e.Min)
+}
+
+// maxPasswordLength bounds the work a single login can cause.
+const maxPasswordLength = 1024
+
+// Argon2Params are the argon2id cost parameters of a hash.
+type Argon2Params struct {
+	// Memory is in KiB.
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+// Argon2ParamsFromEnv reads PASSWORD_ARGON2_MEMORY (KiB, default 65536,
+// i.e. 64 MiB) and PASSWORD_ARGON2_TIME (iterations, default 3).
+func Argon2ParamsFromEnv() Argon2Params {
+	return Argon2Params{
+		Memory:  uint32(max(envInt("PASSWORD_ARGON2_MEMORY", 64*1024), 16)),
+		Time:    uint32(max(envInt("PASSWORD_ARGON2_TIME", 3), 1)),
+		Threads: 2,
+	}
+}
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// HashPassword hashes plain with argon2id and the current parameters, in
+// the PHC string format: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>.
+func HashPassword(plain string) (string, error) {
+	p := Argon2ParamsFromEnv()
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(plain), salt, p.Time, p.Memory, p.Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// CompareHashAndPassword checks plain against an argon2id hash from
+// HashPassword or a bcrypt hash from before.
+func CompareHashAndPassword(hash, plain string) error {
+	if isBcrypt(hash) {
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(plain)); err != nil {
+			return ErrPasswordMismatch
+		}
+		return nil
+	}
+
+	p, salt, key, err := parseArgon2(hash)
+	if err != nil {
+		return err
+	}
+	got := argon2.IDKey([]byte(plain), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(got, key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// NeedsRehash reports whether hash should be replaced by a new one from
+// HashPassword: bcrypt hashes, and argon2id ones with other parameters.
+func NeedsRehash(hash string) bool {
+	p, _, _, err := parseArgon2(hash)
+	return err != nil || p != Argon2ParamsFromEnv()
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func parseArgon2(hash string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return p, nil, nil, ErrUnknownHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrUnknownHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrUnknownHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, ErrUnknownHash
+	}
+	return p, salt, key, nil
+}
+
+//go:embed banned_passwords.txt
+var bannedPasswordList []byte
+
+// bannedPasswords is the built-in list of common passwords plus the one in
+// PASSWORD_BANNED_LIST (one per line), lowercased.
+var bannedPasswords = sync.OnceValue(func() map[string]bool {
+	banned := map[string]bool{}
+	add := func(data []byte) {
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				banned[strings.ToLower(line)] = true
+			}
+		}
+	}
+	add(bannedPasswordList)
+	if file := os.Getenv("PASSWORD_BANNED_LIST"); file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Printf("password banned list: %v", err)
+		}
+		add(data)
+	}
+	return banned
+})
+
+// ValidatePassword enforces the password policy for new passwords: at least
+// PASSWORD_MIN_LENGTH characters (default 8), not on the banned list and
+// not the account's email (which may be empty when unknown).
+func ValidatePassword(password, email string) error {
+	minLen := envInt("PASSWORD_MIN_LENGTH", 8)
+	switch n := utf8.RuneCountInString(password); {
+	case n < minLen:
+		return &PasswordTooShortError{Min: minLen}
+	case len(password) > maxPasswordLength:
+		return ErrPasswordTooLong
+	}
+	lower := strings.ToLower(password)
+	if bannedPasswords()[lower] {
+		return ErrPasswordBanned
+	}
+	if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+		if local, _, _ := strings.Cut(email, "@"); lower == email || lower == local {
+			return ErrPasswordIsEmail
+		}
+	}
+	return nil
+}
+
+// IsPasswordPolicyError reports whether err is a ValidatePassword error, to
+// be shown to the user.
+func IsPasswordPolicyError(err error) bool {
+	var short *PasswordTooShortError
+	return errors.As(err, &short) || errors.Is(err, ErrPasswordTooLong) || errors.Is(err, ErrPasswordBanned) || errors.Is(err, ErrPasswordIsEmail)
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+func TestArgon2Hash(t *testing.T) {
+	t.Setenv("PASSWORD_ARGON2_MEMORY", "1024")
+	t.Setenv("PASSWORD_ARGON2_TIME", "1")
+
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=2$") {
+		t.Errorf("hash = %q", hash)
+	}
+	if err := CompareHashAndPassword(hash, "correct horse"); err != nil {
+		t.Errorf("right password: %v", err)
+	}
+	if err := CompareHashAndPassword(hash, "wrong horse"); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("wrong password: err = %v", err)
+	}
+	if NeedsRehash(hash) {
+		t.Error("NeedsRehash for a hash with the current parameters")
+	}
+
+	// a stronger policy asks for a new hash; the old one still verifies
+	t.Setenv("PASSWORD_ARGON2_TIME", "2")
+	if !NeedsRehash(hash) {
+		t.Error("no NeedsRehash after the time cost changed")
+	}
+	if err := CompareHashAndPassword(hash, "correct horse"); err != nil {
+		t.Errorf("old parameters: %v", err)
+	}
+}
+
+func TestBcryptHashStillVerifies(t *testing.T) {
+	b, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := string(b)
+	if err := CompareHashAndPassword(hash, "correct horse"); err != nil {
+		t.Errorf("right password: %v", err)
+	}
+	if err := CompareHashAndPassword(hash, "wrong horse"); !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("wrong password: err = %v", err)
+	}
+	if !NeedsRehash(hash) {
+		t.Error("bcrypt hash does not need a rehash")
+	}
+	if err := CompareHashAndPassword("plaintext", "plaintext"); !errors.Is(err, ErrUnknownHash) {
+		t.Errorf("unknown format: err = %v", err)
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	cases := []struct {
+		password, email string
+		ok              bool
+	}{
+		{"short", "", false},
+		{"password123", "", false},
+		{"PassWord123", "", false},
+		{"alice.smith", "Alice.Smith@example.com", false},
+		{"alice@example.com", "alice@example.com", false},
+		{strings.Repeat("x", 2000), "", false},
+		{"correct horse battery", "alice@example.com", true},
+		{"ünïcödé!", "", true},
+	}
+	for _, c := range cases {
+		err := ValidatePassword(c.password, c.email)
+		if (err == nil) != c.ok {
+			t.Errorf("ValidatePassword(%.20q, %q) = %v", c.password, c.email, err)
+		}
+		if err != nil && !IsPasswordPolicyError(err) {
+			t.Errorf("ValidatePassword(%.20q): %v is not a policy error", c.password, err)
+		}
+	}
+}
+
+func TestCheckPasswordRehashesBcrypt(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	t.Setenv("PASSWORD_ARGON2_MEMORY", "1024")
+
+	b, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userID, email string
+	err = db.QueryRowx(`
+INSERT INTO users (email, password_hash) VALUES ('rehash-' || gen_random_uuid() || '@example.com', $1)
+RETURNING id, email`, string(b)).Scan(&userID, &email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CheckPassword(ctx, db, email, "correct horse", Device{}); err != nil {
+		t.Fatal(err)
+	}
+	var hash string
+	if err := db.Get(&hash, "SELECT password_hash FROM users WHERE id = $1", userID); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$") || NeedsRehash(hash) {
+		t.Errorf("hash after login = %q, want a current argon2id hash", hash)
+	}
+	if _, err := CheckPassword(ctx, db, email, "correct horse", Device{}); err != nil {
+		t.Errorf("login with the new hash: %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when an already rotated refresh token
+	// is presented again; the session has been revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected; session revoked")
+	// ErrSessionRevoked is returned for access tokens whose session has been
+	// revoked or has expired.
+	ErrSessionRevoked  = errors.New("session revoked or expired")
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrAccountSuspended is returned for users an admin has suspended, at
+	// login and for their tokens.
+	ErrAccountSuspended = errors.New("account suspended")
+)
+
+// AccessTokenTTL is how long access tokens are valid (ACCESS_TOKEN_TTL,
+// default 15m).
+func AccessTokenTTL() time.Duration {
+	return envDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
+
+// RefreshTokenTTL is how long a session lasts without being refreshed
+// (REFRESH_TOKEN_TTL, default 30 days).
+func RefreshTokenTTL() time.Duration {
+	return envDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+func envDuration(k string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(getEnv(k, ""))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
+// Device describes the client a session was started or last refreshed from.
+type Device struct {
+	UserAgent string
+	IP        string
+}
+
+// DeviceFromRequest returns the user agent and remote address of r.
+func DeviceFromRequest(r *http.Request) Device {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return Device{UserAgent: r.UserAgent(), IP: host}
+}
+
+type deviceKey struct{}
+
+// WithDevice stores the client device in ctx for resolvers that start
+// sessions.
+func WithDevice(ctx context.Context, d Device) context.Context {
+	return context.WithValue(ctx, deviceKey{}, d)
+}
+
+func DeviceFromContext(ctx context.Context) Device {
+	d, _ := ctx.Value(deviceKey{}).(Device)
+	return d
+}
+
+type Session struct {
+	ID         string     `db:"id"`
+	UserID     string     `db:"user_id"`
+	UserAgent  *string    `db:"user_agent"`
+	IP         *string    `db:"ip"`
+	CreatedAt  time.Time  `db:"created_at"`
+	LastUsedAt time.Time  `db:"last_used_at"`
+	ExpiresAt  time.Time  `db:"expires_at"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+}
+
+// TokenPair is what a login or refresh hands to the client.
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	SessionID    string
+	// ExpiresAt is when the access token expires.
+	ExpiresAt time.Time
+}
+
+// StartSession creates a session for userID and returns its first tokens.
+// Suspended users get ErrAccountSuspended.
+func StartSession(ctx context.Context, db *sqlx.DB, userID string, dev Device) (*TokenPair, error) {
+	secret, hash, err := newRefreshSecret()
+	if err != nil {
+		return nil, err
+	}
+	var sessionID string
+	err = db.GetContext(ctx, &sessionID, `
+INSERT INTO sessions (user_id, refresh_token_hash, user_agent, ip, expires_at)
+SELECT id, $2::text, NULLIF($3::text, ''), NULLIF($4::text, ''), $5::timestamptz FROM users WHERE id = $1 AND suspended_at IS NULL
+RETURNING id`,
+		userID, hash, dev.UserAgent, dev.IP, time.Now().Add(RefreshTokenTTL()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAccountSuspended
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create session: %w", err)
+	}
+	return issueTokens(userID, sessionID, secret)
+}
+
+// Refresh exchanges a refresh token for a new pair. The presented token is
+// rotated out; presenting it again revokes the session. Other wrong secrets
+// are rejected with ErrInvalidRefreshToken and leave the session alone.
+func Refresh(ctx context.Context, db *sqlx.DB, refreshToken string, dev Device) (*TokenPair, error) {
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var s struct {
+		UserID string `db:"user_id"`
+		Hash   string `db:"refresh_token_hash"`
+		Live   bool   `db:"live"`
+	}
+	err = tx.GetContext(ctx, &s, `
+SELECT user_id, refresh_token_hash, (revoked_at IS NULL AND expires_at > now()) AS live
+FROM sessions WHERE id::text = $1 FOR UPDATE`, sessionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lookup session: %w", err)
+	}
+	if !s.Live {
+		return nil, ErrInvalidRefreshToken
+	}
+	hash := hashSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(s.Hash)) != 1 {
+		// only a token this session issued and rotated out is reuse; the
+		// session id alone is no secret (access tokens carry it)
+		var retired bool
+		err := tx.GetContext(ctx, &retired,
+			"SELECT EXISTS (SELECT 1 FROM retired_refresh_tokens WHERE token_hash = $1 AND session_id = $2)", hash, sessionID)
+		if err != nil {
+			return nil, fmt.Errorf("lookup refresh token: %w", err)
+		}
+		if !retired {
+			return nil, ErrInvalidRefreshToken
+		}
+		// an earlier token of this family: someone else may hold the current one
+		_, err = tx.ExecContext(ctx,
+			"UPDATE sessions SET revoked_at = now(), revoke_reason = 'reuse' WHERE id = $1", sessionID)
+		if err != nil {
+			return nil, fmt.Errorf("revoke session: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("commit: %w", err)
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	newSecret, newHash, err := newRefreshSecret()
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO retired_refresh_tokens (token_hash, session_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", s.Hash, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("retire refresh token: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+UPDATE sessions SET refresh_token_hash = $2, last_used_at = now(), expires_at = $3,
+	user_agent = COALESCE(NULLIF($4, ''), user_agent), ip = COALESCE(NULLIF($5, ''), ip)
+WHERE id = $1`, sessionID, newHash, time.Now().Add(RefreshTokenTTL()), dev.UserAgent, dev.IP)
+	if err != nil {
+		return nil, fmt.Errorf("rotate refresh token: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return issueTokens(s.UserID, sessionID, newSecret)
+}
+
+// Logout revokes the session a refresh token belongs to. Unknown tokens are
+// ignored. Only the current token of a session can log it out.
+func Logout(ctx context.Context, db *sqlx.DB, refreshToken string) error {
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, `
+UPDATE sessions SET revoked_at = now(), revoke_reason = 'logout'
+WHERE id::text = $1 AND refresh_token_hash = $2 AND revoked_at IS NULL`, sessionID, hashSecret(secret))
+	if err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+	return nil
+}
+
+// RevokeSession revokes one of userID's sessions.
+func RevokeSession(ctx context.Context, db *sqlx.DB, userID, sessionID string) error {
+	res, err := db.ExecContext(ctx, `
+UPDATE sessions SET revoked_at = now(), revoke_reason = 'revoked'
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// ListSessions returns userID's live sessions, most recently used first.
+func ListSessions(ctx context.Context, db sqlx.QueryerContext, userID string) ([]Session, error) {
+	sessions := []Session{}
+	err := sqlx.SelectContext(ctx, db, &sessions, `
+SELECT id, user_id, user_agent, ip, created_at, last_used_at, expires_at, revoked_at
+FROM sessions WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now()
+ORDER BY last_used_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// authenticateSession validates an access token and checks that its session
+// is still live.
+func authenticateSession(ctx context.Context, db sqlx.QueryerContext, accessToken string) (*Identity, error) {
+	userID, sessionID, err := ParseAccessToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	var u struct {
+		Verified  bool   `db:"verified"`
+		Role      string `db:"role"`
+		Suspended bool   `db:"suspended"`
+	}
+	err = sqlx.GetContext(ctx, db, &u, `
+SELECT u.email_verified_at IS NOT NULL AS verified, u.role, u.suspended_at IS NOT NULL AS suspended
+FROM sessions s JOIN users u ON u.id = s.user_id
+WHERE s.id = $1 AND s.user_id = $2 AND s.revoked_at IS NULL AND s.expires_at > now()`, sessionID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionRevoked
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lookup session: %w", err)
+	}
+	if u.Suspended {
+		return nil, ErrAccountSuspended
+	}
+	id := &Identity{UserID: userID, Role: u.Role, SessionID: sessionID}
+	if !u.Verified {
+		id.Scopes = unverifiedScopes(nil)
+	}
+	return id, nil
+}
+
+// PurgeExpiredSessions deletes sessions that expired or were revoked more
+// than a day ago and returns how many were deleted.
+func PurgeExpiredSessions(ctx context.Context, db *sqlx.DB) (int64, error) {
+	res, err := db.ExecContext(ctx, `
+DELETE FROM sessions WHERE expires_at < now() - interval '1 day' OR revoked_at < now() - interval '1 day'`)
+	if err != nil {
+		return 0, fmt.Errorf("purge sessions: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func issueTokens(userID, sessionID, secret string) (*TokenPair, error) {
+	expiresAt := time.Now().Add(AccessTokenTTL())
+	access, err := GenerateAccessToken(userID, sessionID, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:  access,
+		RefreshToken: sessionID + "." + secret,
+		SessionID:    sessionID,
+		ExpiresAt:    expiresAt,
+	}, nil
+}
+
+func newRefreshSecret() (secret, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("generate refresh token: %w", err)
+	}
+	secret = base64.RawURLEncoding.EncodeToString(b)
+	return secret, hashSecret(secret), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+func TestAccessTokenRequiresSession(t *testing.T) {
+	token, err := GenerateAccessToken("user-1", "session-1", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, sessionID, err := ParseAccessToken(token)
+	if err != nil || userID != "user-1" || sessionID != "session-1" {
+		t.Errorf("ParseAccessToken = %q, %q, %v; want user-1, session-1, nil", userID, sessionID, err)
+	}
+
+	// tokens from before sessions existed cannot be revoked, so they are refused
+	legacy, err := Keys().Sign(jwt.MapClaims{"sub": "user-1", "exp": time.Now().Add(time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ParseAccessToken(legacy); err == nil {
+		t.Error("ParseAccessToken accepted a token without a session")
+	}
+
+	expired, err := GenerateAccessToken("user-1", "session-1", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ParseAccessToken(expired); err == nil {
+		t.Error("ParseAccessToken accepted an expired token")
+	}
+}
+
+func TestRefreshRotationAndReuse(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	var userID string
+	err := db.Get(&userID, "INSERT INTO users (email, password_hash) VALUES ('sessions-' || gen_random_uuid() || '@example.com', 'x') RETURNING id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM users WHERE id=$1", userID) })
+
+	first, err := StartSession(ctx, db, userID, Device{UserAgent: "test", IP: "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Refresh(ctx, db, first.RefreshToken, Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.SessionID != first.SessionID || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh did not rotate the token within the session")
+	}
+	if _, err := Authenticate(ctx, db, second.AccessToken, ""); err != nil {
+		t.Fatalf("Authenticate after refresh: %v", err)
+	}
+
+	// a made-up secret for a known session id is rejected without revoking it
+	if _, err := Refresh(ctx, db, second.SessionID+".not-a-token-of-this-session", Device{}); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("random secret: err = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := Authenticate(ctx, db, second.AccessToken, ""); err != nil {
+		t.Fatalf("session revoked by a random secret: %v", err)
+	}
+
+	// replaying the rotated token revokes the whole family
+	if _, err := Refresh(ctx, db, first.RefreshToken, Device{}); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("reused refresh token: err = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, err := Refresh(ctx, db, second.RefreshToken, Device{}); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("current token after reuse: err = %v, want ErrInvalidRefreshToken", err)
+	}
+	if _, err := Authenticate(ctx, db, second.AccessToken, ""); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("access token after reuse: err = %v, want ErrSessionRevoked", err)
+	}
+}
@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Scopes a personal access token can be limited to.
+const (
+	ScopeFilesRead    = "files:read"
+	ScopeFilesWrite   = "files:write"
+	ScopeSharesManage = "shares:manage"
+	// ScopeAdmin allows admin operations; the user must still be an admin.
+	ScopeAdmin = "admin"
+)
+
+// PATPrefix starts every personal access token, which tells them apart from
+// JWTs.
+const PATPrefix = "fvp_"
+
+var (
+	ErrInvalidScope   = errors.New("scopes must be files:read, files:write, shares:manage or admin")
+	ErrNoScopes       = errors.New("at least one scope is required")
+	ErrInvalidIPRange = errors.New("allowed IPs must be addresses or CIDR ranges")
+	ErrTokenNotFound  = errors.New("access token not found")
+	// ErrTokenRejected covers unknown, revoked and expired tokens and use
+	// from an address outside the allowlist.
+	ErrTokenRejected = errors.New("invalid access token")
+)
+
+// Scopes is what a request may do. A nil Scopes is a login session, which
+// may do everything.
+type Scopes []string
+
+// Has reports whether scope is allowed.
+func (s Scopes) Has(scope string) bool {
+	return s == nil || slices.Contains(s, scope)
+}
+
+func validScope(s string) bool {
+	switch s {
+	case ScopeFilesRead, ScopeFilesWrite, ScopeSharesManage, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// Authenticate validates a bearer token: a personal access token, used from
+// ip, or else a session access token. Users whose email address is not
+// verified yet only get ScopeFilesRead.
+func Authenticate(ctx context.Context, db sqlx.ExtContext, token, ip string) (*Identity, error) {
+	if strings.HasPrefix(token, PATPrefix) {
+		return authenticatePAT(ctx, db, token, ip)
+	}
+	return authenticateSession(ctx, db, token)
+}
+
+// unverifiedScopes limits s for users who have not verified their email
+// address: they can only read.
+func unverifiedScopes(s Scopes) Scopes {
+	if s.Has(ScopeFilesRead) {
+		return Scopes{ScopeFilesRead}
+	}
+	return Scopes{}
+}
+
+type PersonalAccessToken struct {
+	ID         string         `db:"id"`
+	UserID     string         `db:"user_id"`
+	Name       string         `db:"name"`
+	Prefix     string         `db:"prefix"`
+	Scopes     pq.StringArray `db:"scopes"`
+	AllowedIPs pq.StringArray `db:"allowed_ips"`
+	ExpiresAt  *time.Time     `db:"expires_at"`
+	LastUsedAt *time.Time     `db:"last_used_at"`
+	LastUsedIP *string        `db:"last_used_ip"`
+	CreatedAt  time.Time      `db:"created_at"`
+}
+
+const patColumns = "id, user_id, name, prefix, scopes, allowed_ips, expires_at, last_used_at, last_used_ip, created_at"
+
+// PATInput describes a new personal access token.
+type PATInput struct {
+	Name   string
+	Scopes []string
+	// ExpiresAt is optional; nil never expires.
+	ExpiresAt *time.Time
+	// AllowedIPs are addresses or CIDR ranges; empty allows any address.
+	AllowedIPs []string
+}
+
+// CreatePAT creates a personal access token for userID and returns it along
+// with the token itself, which is not stored and cannot be shown again.
+func CreatePAT(ctx context.Context, db *sqlx.DB, userID string, in PATInput) (*PersonalAccessToken, string, error) {
+	if len(in.Scopes) == 0 {
+		return nil, "", ErrNoScopes
+	}
+	for _, s := range in.Scopes {
+		if !validScope(s) {
+			return nil, "", ErrInvalidScope
+		}
+	}
+	ranges := make([]string, len(in.AllowedIPs))
+	for i, a := range in.AllowedIPs {
+		n, err := parseIPRange(a)
+		if err != nil {
+			return nil, "", err
+		}
+		ranges[i] = n.String()
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", fmt.Errorf("generate token: %w", err)
+	}
+	token := PATPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	var t PersonalAccessToken
+	err := db.GetContext(ctx, &t, `
+INSERT INTO personal_access_tokens (user_id, name, token_hash, prefix, scopes, allowed_ips, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING `+patColumns,
+		userID, strings.TrimSpace(in.Name), hashSecret(token), token[:len(PATPrefix)+4],
+		pq.Array(slices.Compact(slices.Sorted(slices.Values(in.Scopes)))), pq.Array(ranges), in.ExpiresAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("create access token: %w", err)
+	}
+	return &t, token, nil
+}
+
+// ListPATs returns userID's tokens that are not revoked, newest first.
+func ListPATs(ctx context.Context, db sqlx.QueryerContext, userID string) ([]PersonalAccessToken, error) {
+	tokens := []PersonalAccessToken{}
+	err := sqlx.SelectContext(ctx, db, &tokens, "SELECT "+patColumns+`
+FROM personal_access_tokens WHERE user_id = $1 AND revoked_at IS NULL
+ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list access tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// RevokePAT revokes one of userID's tokens.
+func RevokePAT(ctx context.Context, db *sqlx.DB, userID, tokenID string) error {
+	res, err := db.ExecContext(ctx, `
+UPDATE personal_access_tokens SET revoked_at = now()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("revoke access token: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTokenNotFound
+	}
+	return nil
+}
+
+func authenticatePAT(ctx context.Context, db sqlx.ExtContext, token, ip string) (*Identity, error) {
+	var t struct {
+		PersonalAccessToken
+		Verified  bool   `db:"verified"`
+		Role      string `db:"role"`
+		Suspended bool   `db:"suspended"`
+	}
+	err := sqlx.GetContext(ctx, db, &t, "SELECT "+patColumns+`, verified, role, suspended
+FROM personal_access_tokens CROSS JOIN LATERAL (
+	SELECT email_verified_at IS NOT NULL AS verified, role, suspended_at IS NOT NULL AS suspended
+	FROM users WHERE users.id = personal_access_tokens.user_id) u
+WHERE token_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())`, hashSecret(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTokenRejected
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lookup access token: %w", err)
+	}
+	if !ipAllowed(t.AllowedIPs, ip) {
+		return nil, ErrTokenRejected
+	}
+	if t.Suspended {
+		return nil, ErrAccountSuspended
+	}
+
+	// at most one write a minute per token
+	_, err = db.ExecContext(ctx, `
+UPDATE personal_access_tokens SET last_used_at = now(), last_used_ip = NULLIF($2, '')
+WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute' OR last_used_ip IS DISTINCT FROM NULLIF($2, ''))`,
+		t.ID, ip)
+	if err != nil {
+		return nil, fmt.Errorf("record token use: %w", err)
+	}
+	id := &Identity{UserID: t.UserID, Role: t.Role, TokenID: t.ID, Scopes: Scopes(t.Scopes)}
+	if !t.Verified {
+		id.Scopes = unverifiedScopes(id.Scopes)
+	}
+	return id, nil
+}
+
+// parseIPRange accepts a CIDR range or a single address.
+func parseIPRange(s string) (*net.IPNet, error) {
+	if _, n, err := net.ParseCIDR(s); err == nil {
+		return n, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, ErrInvalidIPRange
+	}
+	bits := 128
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits = v4, 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+func ipAllowed(ranges []string, ip string) bool {
+	if len(ranges) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, r := range ranges {
+		if _, n, err := net.ParseCIDR(r); err == nil && n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
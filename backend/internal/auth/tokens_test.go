@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+func TestScopesHas(t *testing.T) {
+	if !Scopes(nil).Has(ScopeAdmin) {
+		t.Error("a login session should have every scope")
+	}
+	s := Scopes{ScopeFilesRead}
+	if !s.Has(ScopeFilesRead) || s.Has(ScopeFilesWrite) {
+		t.Errorf("Scopes{files:read}: Has(files:read) = %v, Has(files:write) = %v", s.Has(ScopeFilesRead), s.Has(ScopeFilesWrite))
+	}
+}
+
+func TestIPAllowed(t *testing.T) {
+	n, err := parseIPRange("192.0.2.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.String() != "192.0.2.7/32" {
+		t.Errorf("parseIPRange(192.0.2.7) = %s, want 192.0.2.7/32", n)
+	}
+	if _, err := parseIPRange("not-an-ip"); !errors.Is(err, ErrInvalidIPRange) {
+		t.Errorf("parseIPRange(not-an-ip): err = %v, want ErrInvalidIPRange", err)
+	}
+
+	ranges := []string{"10.0.0.0/8", "2001:db8::/32"}
+	cases := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"2001:db8::1", true},
+		{"192.0.2.1", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if got := ipAllowed(ranges, c.ip); got != c.want {
+			t.Errorf("ipAllowed(%q) = %v, want %v", c.ip, got, c.want)
+		}
+	}
+	if !ipAllowed(nil, "192.0.2.1") {
+		t.Error("an empty allowlist should allow any address")
+	}
+}
+
+func TestPersonalAccessTokens(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	var userID string
+	err := db.Get(&userID, "INSERT INTO users (email, password_hash) VALUES ('pats-' || gen_random_uuid() || '@example.com', 'x') RETURNING id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := CreatePAT(ctx, db, userID, PATInput{Name: "ci", Scopes: []string{"files:delete"}}); !errors.Is(err, ErrInvalidScope) {
+		t.Fatalf("unknown scope: err = %v, want ErrInvalidScope", err)
+	}
+
+	pat, token, err := CreatePAT(ctx, db, userID, PATInput{
+		Name:       "ci",
+		Scopes:     []string{ScopeFilesRead},
+		AllowedIPs: []string{"192.0.2.0/24"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, pat.Prefix) {
+		t.Errorf("prefix %q does not start token", pat.Prefix)
+	}
+
+	id, err := Authenticate(ctx, db, token, "192.0.2.10")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if id.UserID != userID || id.TokenID != pat.ID || id.Scopes.Has(ScopeFilesWrite) {
+		t.Errorf("Authenticate = %+v", id)
+	}
+	if _, err := Authenticate(ctx, db, token, "198.51.100.1"); !errors.Is(err, ErrTokenRejected) {
+		t.Errorf("outside allowlist: err = %v, want ErrTokenRejected", err)
+	}
+
+	var lastIP string
+	if err := db.Get(&lastIP, "SELECT last_used_ip FROM personal_access_tokens WHERE id = $1", pat.ID); err != nil || lastIP != "192.0.2.10" {
+		t.Errorf("last_used_ip = %q, %v; want 192.0.2.10", lastIP, err)
+	}
+
+	if err := RevokePAT(ctx, db, userID, pat.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Authenticate(ctx, db, token, "192.0.2.10"); !errors.Is(err, ErrTokenRejected) {
+		t.Errorf("revoked token: err = %v, want ErrTokenRejected", err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	_, expired, err := CreatePAT(ctx, db, userID, PATInput{Name: "old", Scopes: []string{ScopeFilesRead}, ExpiresAt: &past})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Authenticate(ctx, db, expired, ""); !errors.Is(err, ErrTokenRejected) {
+		t.Errorf("expired token: err = %v, want ErrTokenRejected", err)
+	}
+}
@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+// base32 of the RFC 6238 SHA-1 test key "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// RFC 6238 appendix B, truncated to six digits
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, c := range cases {
+		got, err := TOTPCode(rfcSecret, time.Unix(c.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("TOTPCode at %d = %s, want %s", c.unix, got, c.want)
+		}
+	}
+}
+
+func TestMatchTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	prev, _ := TOTPCode(rfcSecret, now.Add(-totpPeriod*time.Second))
+	if step, ok := matchTOTP(rfcSecret, prev, now); !ok || step != totpStep(now)-1 {
+		t.Errorf("previous step's code: step %d, ok %v", step, ok)
+	}
+	old, _ := TOTPCode(rfcSecret, now.Add(-2*totpPeriod*time.Second))
+	if _, ok := matchTOTP(rfcSecret, old, now); ok {
+		t.Error("code from two steps ago was accepted")
+	}
+	if _, ok := matchTOTP(rfcSecret, "12345", now); ok {
+		t.Error("short code was accepted")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	u, err := url.Parse(TOTPURI(rfcSecret, "a@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Query().Get("secret") != rfcSecret {
+		t.Errorf("TOTPURI = %s", u)
+	}
+}
+
+func TestMFATokenIsNotAnAccessToken(t *testing.T) {
+	token, err := GenerateMFAToken("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := ParseMFAToken(token); err != nil || id != "user-1" {
+		t.Errorf("ParseMFAToken = %q, %v", id, err)
+	}
+	if _, _, err := ParseAccessToken(token); err == nil {
+		t.Error("ParseAccessToken accepted an mfa token")
+	}
+	access, _ := GenerateAccessToken("user-1", "session-1", time.Now().Add(time.Minute))
+	if _, err := ParseMFAToken(access); !errors.Is(err, ErrInvalidMFAToken) {
+		t.Errorf("ParseMFAToken(access token): err = %v, want ErrInvalidMFAToken", err)
+	}
+	// without a jti its attempts could not be counted
+	noJTI, _ := Keys().Sign(jwt.MapClaims{"sub": "user-1", "mfa": true, "exp": time.Now().Add(time.Minute).Unix()})
+	if _, err := ParseMFAToken(noJTI); !errors.Is(err, ErrInvalidMFAToken) {
+		t.Errorf("ParseMFAToken(no jti): err = %v, want ErrInvalidMFAToken", err)
+	}
+}
+
+func TestTOTPEnrollmentAndLogin(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	var userID string
+	err := db.Get(&userID, "INSERT INTO users (email, password_hash) VALUES ('totp-' || gen_random_uuid() || '@example.com', 'x') RETURNING id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = time.Now })
+
+	enrollment, err := BeginTOTPEnrollment(ctx, db, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := TOTPCode(enrollment.Secret, now)
+	recovery, err := ConfirmTOTPEnrollment(ctx, db, userID, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovery) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes", len(recovery))
+	}
+
+	mfaToken, _ := GenerateMFAToken(userID)
+	// the enrollment code has been used
+	if _, _, err := VerifyMFA(ctx, db, mfaToken, code, Device{}); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("replayed code: err = %v, want ErrInvalidMFACode", err)
+	}
+	now = now.Add(totpPeriod * time.Second)
+	code, _ = TOTPCode(enrollment.Secret, now)
+	if id, _, err := VerifyMFA(ctx, db, mfaToken, code, Device{}); err != nil || id != userID {
+		t.Errorf("VerifyMFA = %q, %v", id, err)
+	}
+
+	if _, _, err := VerifyMFA(ctx, db, mfaToken, strings.ToUpper(recovery[0]), Device{}); err != nil {
+		t.Errorf("recovery code: %v", err)
+	}
+	if _, _, err := VerifyMFA(ctx, db, mfaToken, recovery[0], Device{}); !errors.Is(err, ErrInvalidMFACode) {
+		t.Errorf("reused recovery code: err = %v, want ErrInvalidMFACode", err)
+	}
+
+	if err := DisableTOTP(ctx, db, userID, recovery[1]); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := GetMFAState(ctx, db, userID); state.Pending() {
+		t.Errorf("state after disabling = %+v", state)
+	}
+}
+
+func TestVerifyMFAAttemptLimits(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	t.Setenv("MFA_TOKEN_MAX_ATTEMPTS", "3")
+	t.Setenv("LOGIN_BACKOFF_AFTER", "0")
+	t.Setenv("LOGIN_LOCKOUT_AFTER", "5")
+
+	var userID string
+	err := db.Get(&userID, "INSERT INTO users (email, password_hash) VALUES ('mfa-limit-' || gen_random_uuid() || '@example.com', 'x') RETURNING id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM users WHERE id=$1", userID) })
+
+	now := time.Unix(1700000000, 0)
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = time.Now })
+	enrollment, err := BeginTOTPEnrollment(ctx, db, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := TOTPCode(enrollment.Secret, now)
+	if _, err := ConfirmTOTPEnrollment(ctx, db, userID, code); err != nil {
+		t.Fatal(err)
+	}
+	nextCode := func() string {
+		now = now.Add(totpPeriod * time.Second)
+		c, _ := TOTPCode(enrollment.Secret, now)
+		return c
+	}
+
+	// a token is spent after MFA_TOKEN_MAX_ATTEMPTS tries, even the right code
+	mfaToken, _ := GenerateMFAToken(userID)
+	for i := 0; i < 3; i++ {
+		if _, _, err := VerifyMFA(ctx, db, mfaToken, "000000", Device{}); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("attempt %d: err = %v, want ErrInvalidMFACode", i+1, err)
+		}
+	}
+	if _, _, err := VerifyMFA(ctx, db, mfaToken, nextCode(), Device{}); !errors.Is(err, ErrInvalidMFAToken) {
+		t.Errorf("spent token: err = %v, want ErrInvalidMFAToken", err)
+	}
+
+	// failures add up across tokens and lock the second factor
+	mfaToken, _ = GenerateMFAToken(userID)
+	for i := 0; i < 2; i++ {
+		if _, _, err := VerifyMFA(ctx, db, mfaToken, "000000", Device{}); !errors.Is(err, ErrInvalidMFACode) {
+			t.Fatalf("attempt %d: err = %v, want ErrInvalidMFACode", i+4, err)
+		}
+	}
+	mfaToken, _ = GenerateMFAToken(userID)
+	var throttled *ThrottledError
+	if _, _, err := VerifyMFA(ctx, db, mfaToken, nextCode(), Device{}); !errors.As(err, &throttled) {
+		t.Errorf("locked second factor: err = %v, want *ThrottledError", err)
+	}
+
+	if err := UnlockAccount(ctx, db, userID, userID); err != nil {
+		t.Fatal(err)
+	}
+	if id, _, err := VerifyMFA(ctx, db, mfaToken, nextCode(), Device{}); err != nil || id != userID {
+		t.Errorf("after unlock: VerifyMFA = %q, %v", id, err)
+	}
+}
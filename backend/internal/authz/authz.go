@@ -0,0 +1,207 @@
+// Package authz decides what a user may do with a file or folder: owners may
+// do anything, other users only what the role of their access grant allows.
+// Grants on a folder apply to everything below it. Members of an
+// organization are editors of its files, and its owner and admins co-owners.
+package authz
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rishit911/file_vault_proj-backend/internal/orgs"
+)
+
+var (
+	// ErrNotFound is returned when the file or folder does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrForbidden is returned when it exists but the user may not perform
+	// the action.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Role is a user's relationship to a file or folder.
+type Role string
+
+const (
+	RoleNone    Role = ""
+	RoleViewer  Role = "viewer"
+	RoleEditor  Role = "editor"
+	RoleCoOwner Role = "co-owner"
+	RoleOwner   Role = "owner"
+)
+
+func (r Role) rank() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleEditor:
+		return 2
+	case RoleCoOwner:
+		return 3
+	case RoleOwner:
+		return 4
+	}
+	return 0
+}
+
+// GrantableRole reports whether r can be given to another user.
+func GrantableRole(r Role) bool {
+	return r == RoleViewer || r == RoleEditor || r == RoleCoOwner
+}
+
+// Action is something done to a file or folder.
+type Action int
+
+const (
+	// Read covers listing, downloading and viewing versions.
+	Read Action = iota
+	// Write covers renaming, uploading new versions and restoring versions.
+	Write
+	// Delete covers moving to the trash.
+	Delete
+	// Manage covers sharing with other users and the download audit trail.
+	Manage
+)
+
+// Allows reports whether the role permits the action: viewers read, editors
+// also write, co-owners and owners may do everything.
+func (r Role) Allows(a Action) bool {
+	switch a {
+	case Read:
+		return r.rank() >= RoleViewer.rank()
+	case Write:
+		return r.rank() >= RoleEditor.rank()
+	default:
+		return r.rank() >= RoleCoOwner.rank()
+	}
+}
+
+// Access is a user's effective access to a file or folder.
+type Access struct {
+	OwnerID string
+	// OrgID is the organization of an org file.
+	OrgID *string
+	Role  Role
+}
+
+// FileAccess returns userID's access to a live file: owner, or the highest
+// role granted on the file or any folder above it (RoleNone if there is no
+// grant). A missing or trashed file is ErrNotFound.
+func FileAccess(ctx context.Context, db sqlx.QueryerContext, userID, userFileID string) (*Access, error) {
+	var f struct {
+		OwnerID  string  `db:"user_id"`
+		FolderID *string `db:"folder_id"`
+		OrgID    *string `db:"org_id"`
+	}
+	err := sqlx.GetContext(ctx, db, &f,
+		"SELECT user_id, folder_id, org_id FROM user_files WHERE id=$1 AND deleted_at IS NULL", userFileID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lookup file: %w", err)
+	}
+	if f.OwnerID == userID {
+		return &Access{OwnerID: f.OwnerID, OrgID: f.OrgID, Role: RoleOwner}, nil
+	}
+
+	var roles []Role
+	err = sqlx.SelectContext(ctx, db, &roles, ancestorsCTE+`
+SELECT role FROM access_grants
+WHERE grantee_id=$2 AND (user_file_id=$3 OR folder_id IN (SELECT id FROM up))
+UNION ALL
+SELECT CASE role WHEN 'member' THEN 'editor' ELSE 'co-owner' END FROM org_members
+WHERE user_id=$2 AND org_id=$4`, f.FolderID, userID, userFileID, f.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("lookup grants: %w", err)
+	}
+	return &Access{OwnerID: f.OwnerID, OrgID: f.OrgID, Role: highest(roles)}, nil
+}
+
+// FolderAccess is FileAccess for a folder.
+func FolderAccess(ctx context.Context, db sqlx.QueryerContext, userID, folderID string) (*Access, error) {
+	var ownerID string
+	err := sqlx.GetContext(ctx, db, &ownerID, "SELECT user_id FROM folders WHERE id=$1", folderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lookup folder: %w", err)
+	}
+	if ownerID == userID {
+		return &Access{OwnerID: ownerID, Role: RoleOwner}, nil
+	}
+
+	var roles []Role
+	err = sqlx.SelectContext(ctx, db, &roles, ancestorsCTE+`
+SELECT role FROM access_grants WHERE grantee_id=$2 AND folder_id IN (SELECT id FROM up)`, folderID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("lookup grants: %w", err)
+	}
+	return &Access{OwnerID: ownerID, Role: highest(roles)}, nil
+}
+
+// ancestorsCTE defines "up" as folder $1 and all folders above it.
+const ancestorsCTE = `
+WITH RECURSIVE up AS (
+	SELECT id, parent_id FROM folders WHERE id=$1
+	UNION ALL
+	SELECT f.id, f.parent_id FROM folders f JOIN up ON f.id = up.parent_id
+)`
+
+func highest(roles []Role) Role {
+	best := RoleNone
+	for _, r := range roles {
+		if r.rank() > best.rank() {
+			best = r
+		}
+	}
+	return best
+}
+
+// RequireFile returns userID's access to a file if it allows the action,
+// ErrForbidden if not, and ErrNotFound if the file does not exist.
+func RequireFile(ctx context.Context, db sqlx.QueryerContext, userID, userFileID string, a Action) (*Access, error) {
+	acc, err := FileAccess(ctx, db, userID, userFileID)
+	if err != nil {
+		return nil, err
+	}
+	if !acc.Role.Allows(a) {
+		return nil, ErrForbidden
+	}
+	return acc, nil
+}
+
+// RequireFolder is RequireFile for a folder.
+func RequireFolder(ctx context.Context, db sqlx.QueryerContext, userID, folderID string, a Action) (*Access, error) {
+	acc, err := FolderAccess(ctx, db, userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if !acc.Role.Allows(a) {
+		return nil, ErrForbidden
+	}
+	return acc, nil
+}
+
+// UploadTarget returns where an upload by userID is stored: as a new version
+// of replaceUserFileID, which userID must be allowed to write, or else as a
+// file of userID itself, in orgID if given, which userID must be a member of.
+func UploadTarget(ctx context.Context, db sqlx.QueryerContext, userID string, orgID, replaceUserFileID *string) (*Access, error) {
+	if replaceUserFileID != nil {
+		return RequireFile(ctx, db, userID, *replaceUserFileID, Write)
+	}
+	if orgID != nil {
+		_, err := orgs.MemberRole(ctx, db, userID, *orgID)
+		if errors.Is(err, orgs.ErrNotMember) {
+			return nil, ErrForbidden
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Access{OwnerID: userID, OrgID: orgID, Role: RoleOwner}, nil
+}
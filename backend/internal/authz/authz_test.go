@@ -0,0 +1,37 @@
+package authz
+
+import "testing"
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		role Role
+		want [4]bool // Read, Write, Delete, Manage
+	}{
+		{RoleNone, [4]bool{false, false, false, false}},
+		{RoleViewer, [4]bool{true, false, false, false}},
+		{RoleEditor, [4]bool{true, true, false, false}},
+		{RoleCoOwner, [4]bool{true, true, true, true}},
+		{RoleOwner, [4]bool{true, true, true, true}},
+		{Role("admin"), [4]bool{false, false, false, false}},
+	}
+	for _, tt := range tests {
+		for a, want := range tt.want {
+			if got := tt.role.Allows(Action(a)); got != want {
+				t.Errorf("%q.Allows(%d) = %v, want %v", tt.role, a, got, want)
+			}
+		}
+	}
+}
+
+func TestGrantableRole(t *testing.T) {
+	for _, r := range []Role{RoleViewer, RoleEditor, RoleCoOwner} {
+		if !GrantableRole(r) {
+			t.Errorf("GrantableRole(%q) = false", r)
+		}
+	}
+	for _, r := range []Role{RoleNone, RoleOwner, Role("admin")} {
+		if GrantableRole(r) {
+			t.Errorf("GrantableRole(%q) = true", r)
+		}
+	}
+}
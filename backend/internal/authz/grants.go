@@ -0,0 +1,169 @@
+package authz
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrGrantNotFound   = errors.New("grant not found")
+	ErrUserNotFound    = errors.New("no user with that email")
+	ErrInvalidRole     = errors.New("role must be viewer, editor or co-owner")
+	ErrAlreadyGranted  = errors.New("already shared with that user")
+	ErrGrantToOwner    = errors.New("cannot share with the owner")
+	ErrInvalidGrantRef = errors.New("exactly one of file and folder must be given")
+)
+
+// Grant is a role given to one user on a file or folder.
+type Grant struct {
+	ID         string    `db:"id"`
+	UserFileID *string   `db:"user_file_id"`
+	FolderID   *string   `db:"folder_id"`
+	GranteeID  string    `db:"grantee_id"`
+	Role       Role      `db:"role"`
+	GrantedBy  *string   `db:"granted_by"`
+	CreatedAt  time.Time `db:"created_at"`
+	// OwnerID owns the shared item.
+	OwnerID string `db:"owner_id"`
+}
+
+const grantColumns = `g.id, g.user_file_id, g.folder_id, g.grantee_id, g.role, g.granted_by, g.created_at,
+	COALESCE(uf.user_id, fd.user_id) AS owner_id`
+
+const grantJoins = `access_grants g
+	LEFT JOIN user_files uf ON uf.id = g.user_file_id
+	LEFT JOIN folders fd ON fd.id = g.folder_id`
+
+// itemAccess checks the action on whichever of userFileID and folderID is set.
+func itemAccess(ctx context.Context, db sqlx.QueryerContext, userID string, userFileID, folderID *string, a Action) (*Access, error) {
+	switch {
+	case userFileID != nil && folderID == nil:
+		return RequireFile(ctx, db, userID, *userFileID, a)
+	case folderID != nil && userFileID == nil:
+		return RequireFolder(ctx, db, userID, *folderID, a)
+	}
+	return nil, ErrInvalidGrantRef
+}
+
+// ShareWithUser gives the user with granteeEmail a role on a file or folder.
+// The caller needs Manage access; only owners and co-owners can share.
+func ShareWithUser(ctx context.Context, db *sqlx.DB, userID string, userFileID, folderID *string, granteeEmail string, role Role) (*Grant, error) {
+	if !GrantableRole(role) {
+		return nil, ErrInvalidRole
+	}
+	acc, err := itemAccess(ctx, db, userID, userFileID, folderID, Manage)
+	if err != nil {
+		return nil, err
+	}
+
+	var granteeID string
+	err = db.GetContext(ctx, &granteeID, "SELECT id FROM users WHERE email=$1", granteeEmail)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lookup user: %w", err)
+	}
+	if granteeID == acc.OwnerID {
+		return nil, ErrGrantToOwner
+	}
+
+	var id string
+	err = db.GetContext(ctx, &id, `
+INSERT INTO access_grants (user_file_id, folder_id, grantee_id, role, granted_by)
+VALUES ($1, $2, $3, $4, $5) RETURNING id`, userFileID, folderID, granteeID, role, userID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrAlreadyGranted
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create grant: %w", err)
+	}
+	return GetGrant(ctx, db, id)
+}
+
+// GetGrant returns a grant by id.
+func GetGrant(ctx context.Context, db sqlx.QueryerContext, grantID string) (*Grant, error) {
+	var g Grant
+	err := sqlx.GetContext(ctx, db, &g, "SELECT "+grantColumns+" FROM "+grantJoins+" WHERE g.id=$1", grantID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrGrantNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get grant: %w", err)
+	}
+	return &g, nil
+}
+
+// UpdateGrantRole changes the role of a grant. The caller needs Manage
+// access to the shared item.
+func UpdateGrantRole(ctx context.Context, db *sqlx.DB, userID, grantID string, role Role) (*Grant, error) {
+	if !GrantableRole(role) {
+		return nil, ErrInvalidRole
+	}
+	g, err := GetGrant(ctx, db, grantID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := itemAccess(ctx, db, userID, g.UserFileID, g.FolderID, Manage); err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE access_grants SET role=$2 WHERE id=$1", grantID, role); err != nil {
+		return nil, fmt.Errorf("update grant: %w", err)
+	}
+	g.Role = role
+	return g, nil
+}
+
+// Unshare deletes a grant. Besides users with Manage access to the item, the
+// grantee may always remove their own grant.
+func Unshare(ctx context.Context, db *sqlx.DB, userID, grantID string) error {
+	g, err := GetGrant(ctx, db, grantID)
+	if err != nil {
+		return err
+	}
+	if g.GranteeID != userID {
+		if _, err := itemAccess(ctx, db, userID, g.UserFileID, g.FolderID, Manage); err != nil {
+			return err
+		}
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM access_grants WHERE id=$1", grantID); err != nil {
+		return fmt.Errorf("delete grant: %w", err)
+	}
+	return nil
+}
+
+// ListGrants returns the grants on a file or folder (not those inherited from
+// folders above it). The caller needs Manage access.
+func ListGrants(ctx context.Context, db *sqlx.DB, userID string, userFileID, folderID *string) ([]Grant, error) {
+	if _, err := itemAccess(ctx, db, userID, userFileID, folderID, Manage); err != nil {
+		return nil, err
+	}
+	grants := []Grant{}
+	err := db.SelectContext(ctx, &grants, "SELECT "+grantColumns+" FROM "+grantJoins+`
+WHERE g.user_file_id IS NOT DISTINCT FROM $1 AND g.folder_id IS NOT DISTINCT FROM $2
+ORDER BY g.created_at`, userFileID, folderID)
+	if err != nil {
+		return nil, fmt.Errorf("list grants: %w", err)
+	}
+	return grants, nil
+}
+
+// SharedWith returns the grants given to userID, newest first. Grants on
+// trashed files are left out.
+func SharedWith(ctx context.Context, db *sqlx.DB, userID string) ([]Grant, error) {
+	grants := []Grant{}
+	err := db.SelectContext(ctx, &grants, "SELECT "+grantColumns+" FROM "+grantJoins+`
+WHERE g.grantee_id=$1 AND (g.folder_id IS NOT NULL OR uf.deleted_at IS NULL)
+ORDER BY g.created_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list shared: %w", err)
+	}
+	return grants, nil
+}
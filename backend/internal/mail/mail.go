@@ -0,0 +1,228 @@
+// Package mail sends the emails of account flows (address verification,
+// password reset) through a pluggable Mailer, rendered from templates that
+// can be replaced without rebuilding.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// SMTPMailer sends through an SMTP server, using STARTTLS when the server
+// offers it.
+type SMTPMailer struct {
+	// Addr is host:port.
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPMailer) Send(ctx context.Context, m Message) error {
+	host, _, _ := strings.Cut(s.Addr, ":")
+	var a smtp.Auth
+	if s.Username != "" {
+		a = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	if err := smtp.SendMail(s.Addr, a, s.From, []string{m.To}, format(s.From, m)); err != nil {
+		return fmt.Errorf("smtp send: %w", err)
+	}
+	return nil
+}
+
+// FileMailer writes each message to its own .eml file in Dir, for
+// development and tests.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (f *FileMailer) Send(ctx context.Context, m Message) error {
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return fmt.Errorf("create mail dir: %w", err)
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), sanitize(m.To))
+	if err := os.WriteFile(filepath.Join(f.Dir, name), format(f.From, m), 0o600); err != nil {
+		return fmt.Errorf("write mail: %w", err)
+	}
+	return nil
+}
+
+// LogMailer writes messages to the server log instead of sending them.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, m Message) error {
+	log.Printf("mail to %s: %s\n%s", m.To, m.Subject, m.Body)
+	return nil
+}
+
+// NewMailerFromEnv returns the mailer selected by MAIL_BACKEND: smtp, file
+// or log (the default).
+func NewMailerFromEnv() (Mailer, error) {
+	from := getEnv("MAIL_FROM", "File Vault <no-reply@localhost>")
+	switch backend := getEnv("MAIL_BACKEND", "log"); backend {
+	case "smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		if addr == "" {
+			return nil, fmt.Errorf("mail: SMTP_ADDR is required for MAIL_BACKEND=smtp")
+		}
+		return &SMTPMailer{
+			Addr:     addr,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	case "file":
+		return &FileMailer{Dir: getEnv("MAIL_DIR", "/data/mail"), From: from}, nil
+	case "log":
+		return LogMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_BACKEND %q", backend)
+	}
+}
+
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// Templates renders emails. Each template file defines a "subject" and a
+// "body" template.
+type Templates struct {
+	dir string
+
+	mu    sync.Mutex
+	cache map[string]*template.Template
+}
+
+// NewTemplates uses the built-in templates, each of which is replaced by a
+// file of the same name (e.g. verify_email.tmpl) in dir when dir is set.
+func NewTemplates(dir string) *Templates {
+	return &Templates{dir: dir, cache: map[string]*template.Template{}}
+}
+
+// Render executes template name with data.
+func (t *Templates) Render(name string, data any) (subject, body string, err error) {
+	tmpl, err := t.load(name)
+	if err != nil {
+		return "", "", err
+	}
+	var s, b bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&s, "subject", data); err != nil {
+		return "", "", fmt.Errorf("render %s subject: %w", name, err)
+	}
+	if err := tmpl.ExecuteTemplate(&b, "body", data); err != nil {
+		return "", "", fmt.Errorf("render %s body: %w", name, err)
+	}
+	return strings.TrimSpace(s.String()), strings.TrimSpace(b.String()) + "\n", nil
+}
+
+func (t *Templates) load(name string) (*template.Template, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tmpl, ok := t.cache[name]; ok {
+		return tmpl, nil
+	}
+
+	file := name + ".tmpl"
+	src, err := defaultTemplates.ReadFile("templates/" + file)
+	if t.dir != "" {
+		if custom, cerr := os.ReadFile(filepath.Join(t.dir, file)); cerr == nil {
+			src, err = custom, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("mail template %s: %w", name, err)
+	}
+	tmpl, err := template.New(name).Parse(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("parse mail template %s: %w", name, err)
+	}
+	t.cache[name] = tmpl
+	return tmpl, nil
+}
+
+// Sender renders templates and hands the result to a Mailer.
+type Sender struct {
+	Mailer    Mailer
+	Templates *Templates
+	// BaseURL prefixes the links in emails.
+	BaseURL string
+}
+
+// NewSenderFromEnv configures a Sender from MAIL_BACKEND (see
+// NewMailerFromEnv), MAIL_TEMPLATE_DIR and MAIL_LINK_BASE_URL, which
+// defaults to PUBLIC_BASE_URL.
+func NewSenderFromEnv() (*Sender, error) {
+	m, err := NewMailerFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return &Sender{
+		Mailer:    m,
+		Templates: NewTemplates(os.Getenv("MAIL_TEMPLATE_DIR")),
+		BaseURL:   getEnv("MAIL_LINK_BASE_URL", os.Getenv("PUBLIC_BASE_URL")),
+	}, nil
+}
+
+// Link returns BaseURL + path.
+func (s *Sender) Link(path string) string {
+	return strings.TrimSuffix(s.BaseURL, "/") + path
+}
+
+// Send renders template name with data and mails it to to.
+func (s *Sender) Send(ctx context.Context, to, name string, data any) error {
+	subject, body, err := s.Templates.Render(name, data)
+	if err != nil {
+		return err
+	}
+	return s.Mailer.Send(ctx, Message{To: to, Subject: subject, Body: body})
+}
+
+func format(from string, m Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r == '.' || r == '-' || r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+func getEnv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
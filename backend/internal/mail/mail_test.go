@@ -0,0 +1,65 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplatesDefaultAndOverride(t *testing.T) {
+	data := map[string]any{"Email": "a@example.com", "Link": "https://vault.test/x", "ExpiresIn": "1h0m0s"}
+
+	subject, body, err := NewTemplates("").Render("reset_password", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Reset your password" || !strings.Contains(body, "https://vault.test/x") {
+		t.Errorf("default template: %q / %q", subject, body)
+	}
+
+	dir := t.TempDir()
+	custom := `{{define "subject"}}Passwort zurücksetzen{{end}}{{define "body"}}Link: {{.Link}}{{end}}`
+	if err := os.WriteFile(filepath.Join(dir, "reset_password.tmpl"), []byte(custom), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := NewTemplates(dir)
+	subject, body, err = tmpl.Render("reset_password", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Passwort zurücksetzen" || body != "Link: https://vault.test/x\n" {
+		t.Errorf("override: %q / %q", subject, body)
+	}
+	// templates without an override still use the default
+	if subject, _, err := tmpl.Render("verify_email", data); err != nil || subject != "Confirm your email address" {
+		t.Errorf("verify_email = %q, %v", subject, err)
+	}
+	if _, _, err := tmpl.Render("missing", data); err == nil {
+		t.Error("unknown template rendered")
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	s := &Sender{Mailer: &FileMailer{Dir: dir, From: "vault@example.com"}, Templates: NewTemplates(""), BaseURL: "https://vault.test/"}
+	err := s.Send(context.Background(), "a@example.com", "verify_email", map[string]any{
+		"Email": "a@example.com", "Link": s.Link("/verify?token=t"), "ExpiresIn": "48h0m0s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("got %d files", len(files))
+	}
+	raw, _ := os.ReadFile(files[0])
+	msg := string(raw)
+	for _, want := range []string{"To: a@example.com\r\n", "Subject: Confirm your email address\r\n", "https://vault.test/verify?token=t"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message lacks %q:\n%s", want, msg)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+// mockProvider is an in-process OpenID provider. Its authorization endpoint
+// logs in subject straight away and redirects back with a code.
+type mockProvider struct {
+	*httptest.Server
+	t *testing.T
+
+	mu       sync.Mutex
+	key      *rsa.PrivateKey
+	kid      string
+	subject  string
+	claims   jwt.MapClaims
+	audience string
+	codes    map[string]pendingCode
+}
+
+type pendingCode struct {
+	nonce, challenge, redirect string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	m := &mockProvider{t: t, subject: "sub-1", codes: map[string]pendingCode{}}
+	m.rotateKey()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		pub := m.key.PublicKey
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": m.kid, "use": "sig", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("code_challenge_method") != "S256" || q.Get("response_type") != "code" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		code := randomString()
+		m.mu.Lock()
+		m.codes[code] = pendingCode{q.Get("nonce"), q.Get("code_challenge"), q.Get("redirect_uri")}
+		m.mu.Unlock()
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		pc, ok := m.codes[r.Form.Get("code")]
+		delete(m.codes, r.Form.Get("code"))
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != pc.challenge || r.Form.Get("redirect_uri") != pc.redirect {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "at", "token_type": "Bearer", "id_token": m.idToken(pc.nonce),
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockProvider) rotateKey() {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	m.key, m.kid = key, randomString()[:8]
+}
+
+// idToken signs an ID token; callers hold m.mu.
+func (m *mockProvider) idToken(nonce string) string {
+	aud := m.audience
+	if aud == "" {
+		aud = "vault"
+	}
+	claims := jwt.MapClaims{
+		"iss": m.URL, "sub": m.subject, "aud": aud, "nonce": nonce,
+		"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+	}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tok.Header["kid"] = m.kid
+	s, err := tok.SignedString(m.key)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return s
+}
+
+// login runs the browser's part of the flow and returns the callback's code
+// and state.
+func (m *mockProvider) login(t *testing.T, authURL string) (string, string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: %s %v", resp.Status, err)
+	}
+	return loc.Query().Get("code"), loc.Query().Get("state")
+}
+
+func newTestProvider(t *testing.T, m *mockProvider, cfg Config) *Provider {
+	t.Helper()
+	cfg.Issuer, cfg.ClientID, cfg.RedirectURL = m.URL, "vault", "http://vault.test/callback"
+	p, err := NewProvider(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	m := newMockProvider(t)
+	m.claims = jwt.MapClaims{"email": "a@example.com", "email_verified": true, "groups": []string{"staff", "vault-admins"}}
+	p := newTestProvider(t, m, Config{AdminGroups: []string{"vault-admins"}})
+	ctx := context.Background()
+
+	req, err := p.NewAuthRequest(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := m.login(t, req.URL)
+	if state != req.State {
+		t.Fatalf("state = %q, want %q", state, req.State)
+	}
+
+	claims, err := p.Exchange(ctx, code, req.Verifier, req.Nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "sub-1" || claims.Issuer != m.URL || claims.Email != "a@example.com" || !claims.EmailVerified {
+		t.Errorf("claims = %+v", claims)
+	}
+	if role := p.Role(claims); role != "admin" {
+		t.Errorf("Role = %q, want admin", role)
+	}
+
+	// codes are single use
+	if _, err := p.Exchange(ctx, code, req.Verifier, req.Nonce); err == nil {
+		t.Error("code was redeemed twice")
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m, Config{})
+	ctx := context.Background()
+
+	t.Run("wrong verifier", func(t *testing.T) {
+		req, _ := p.NewAuthRequest(ctx)
+		code, _ := m.login(t, req.URL)
+		if _, err := p.Exchange(ctx, code, randomString(), req.Nonce); err == nil {
+			t.Error("exchange with the wrong PKCE verifier succeeded")
+		}
+	})
+	t.Run("nonce", func(t *testing.T) {
+		req, _ := p.NewAuthRequest(ctx)
+		code, _ := m.login(t, req.URL)
+		if _, err := p.Exchange(ctx, code, req.Verifier, "other"); !errors.Is(err, ErrNonceMismatch) {
+			t.Errorf("err = %v, want ErrNonceMismatch", err)
+		}
+	})
+	t.Run("audience", func(t *testing.T) {
+		m.audience = "someone-else"
+		defer func() { m.audience = "" }()
+		req, _ := p.NewAuthRequest(ctx)
+		code, _ := m.login(t, req.URL)
+		if _, err := p.Exchange(ctx, code, req.Verifier, req.Nonce); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("err = %v, want ErrInvalidIDToken", err)
+		}
+	})
+	t.Run("expired", func(t *testing.T) {
+		p.now = func() time.Time { return time.Now().Add(time.Hour) }
+		defer func() { p.now = time.Now }()
+		m.mu.Lock()
+		raw := m.idToken("n")
+		m.mu.Unlock()
+		if _, err := p.VerifyIDToken(ctx, raw, "n"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("err = %v, want ErrInvalidIDToken", err)
+		}
+	})
+	t.Run("forged", func(t *testing.T) {
+		m.mu.Lock()
+		raw := m.idToken("n")
+		m.mu.Unlock()
+		if _, err := p.VerifyIDToken(ctx, raw[:len(raw)-4]+"AAAA", "n"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("err = %v, want ErrInvalidIDToken", err)
+		}
+	})
+}
+
+func TestKeyRotation(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m, Config{})
+	ctx := context.Background()
+
+	m.mu.Lock()
+	raw := m.idToken("n")
+	m.mu.Unlock()
+	if _, err := p.VerifyIDToken(ctx, raw, "n"); err != nil {
+		t.Fatal(err)
+	}
+
+	m.mu.Lock()
+	m.rotateKey()
+	raw = m.idToken("n")
+	m.mu.Unlock()
+	// the new key id is only fetched once the refetch interval has passed
+	p.now = func() time.Time { return time.Now().Add(keyRefetchInterval) }
+	if _, err := p.VerifyIDToken(ctx, raw, "n"); err != nil {
+		t.Errorf("token signed with a rotated key: %v", err)
+	}
+}
+
+func TestProvision(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	m := newMockProvider(t)
+	p := newTestProvider(t, m, Config{AdminGroups: []string{"vault-admins"}})
+	email := "sso-" + randomString()[:8] + "@example.com"
+
+	c := &Claims{Issuer: m.URL, Subject: randomString(), Email: email, Groups: []string{"vault-admins"}}
+	userID, err := Provision(ctx, db, p, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var role string
+	if err := db.Get(&role, "SELECT role FROM users WHERE id = $1", userID); err != nil || role != "admin" {
+		t.Errorf("role = %q, %v; want admin", role, err)
+	}
+
+	// the same identity, with a changed email and groups, is the same user
+	c.Email, c.Groups = "renamed-"+email, nil
+	again, err := Provision(ctx, db, p, c)
+	if err != nil || again != userID {
+		t.Errorf("second login = %q, %v; want %q", again, err, userID)
+	}
+	if err := db.Get(&role, "SELECT role FROM users WHERE id = $1", userID); err != nil || role != "user" {
+		t.Errorf("role after losing the group = %q, %v; want user", role, err)
+	}
+
+	// another identity with an existing email is refused unless linking is on
+	other := &Claims{Issuer: m.URL, Subject: randomString(), Email: email, EmailVerified: true}
+	if _, err := Provision(ctx, db, p, other); !errors.Is(err, ErrAccountExists) {
+		t.Errorf("err = %v, want ErrAccountExists", err)
+	}
+	p.cfg.LinkByEmail = true
+	if linked, err := Provision(ctx, db, p, other); err != nil || linked != userID {
+		t.Errorf("linked login = %q, %v; want %q", linked, err, userID)
+	}
+
+	req := &AuthRequest{State: randomString(), Nonce: "n", Verifier: "v"}
+	if err := SaveAuthRequest(ctx, db, req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TakeAuthRequest(ctx, db, req.State); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TakeAuthRequest(ctx, db, req.State); !errors.Is(err, ErrUnknownState) {
+		t.Errorf("replayed state: err = %v, want ErrUnknownState", err)
+	}
+}
@@ -0,0 +1,116 @@
+package oidc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrUnknownState = errors.New("oidc: unknown or expired login")
+	ErrNoEmail      = errors.New("oidc: the provider did not return an email address")
+	// ErrAccountExists is returned for a first login whose email belongs to
+	// an existing account that is not linked (see Config.LinkByEmail).
+	ErrAccountExists = errors.New("oidc: an account with this email already exists")
+)
+
+// LoginStateTTL is how long a user has to complete the provider's login.
+const LoginStateTTL = 10 * time.Minute
+
+// SaveAuthRequest remembers a login until its callback.
+func SaveAuthRequest(ctx context.Context, db *sqlx.DB, req *AuthRequest) error {
+	_, err := db.ExecContext(ctx, `
+INSERT INTO oidc_login_states (state, nonce, code_verifier, expires_at) VALUES ($1, $2, $3, $4)`,
+		req.State, req.Nonce, req.Verifier, time.Now().Add(LoginStateTTL))
+	if err != nil {
+		return fmt.Errorf("save login state: %w", err)
+	}
+	// piggyback cleanup of abandoned logins
+	_, _ = db.ExecContext(ctx, "DELETE FROM oidc_login_states WHERE expires_at < now()")
+	return nil
+}
+
+// TakeAuthRequest returns and forgets the login with state, so a callback
+// cannot be replayed.
+func TakeAuthRequest(ctx context.Context, db *sqlx.DB, state string) (*AuthRequest, error) {
+	var req AuthRequest
+	err := db.QueryRowxContext(ctx, `
+DELETE FROM oidc_login_states WHERE state = $1 AND expires_at > now()
+RETURNING state, nonce, code_verifier`, state).Scan(&req.State, &req.Nonce, &req.Verifier)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUnknownState
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load login state: %w", err)
+	}
+	return &req, nil
+}
+
+// Provision returns the vault user for verified claims, creating the user on
+// their first login. With AdminGroups configured the user's role follows
+// their groups on every login.
+func Provision(ctx context.Context, db *sqlx.DB, p *Provider, c *Claims) (string, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID string
+	err = tx.GetContext(ctx, &userID, `
+UPDATE user_identities SET last_login_at = now(), email = COALESCE(NULLIF($3, ''), email)
+WHERE issuer = $1 AND subject = $2 RETURNING user_id`, c.Issuer, c.Subject, c.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		userID, err = provisionIdentity(ctx, tx, p, c)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if role := p.Role(c); role != "" {
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET role = $2 WHERE id = $1", userID, role); err != nil {
+			return "", fmt.Errorf("update role: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("commit: %w", err)
+	}
+	return userID, nil
+}
+
+// provisionIdentity handles a first login: it links the identity to the
+// account with the same verified email when allowed, or creates a user.
+func provisionIdentity(ctx context.Context, tx *sqlx.Tx, p *Provider, c *Claims) (string, error) {
+	if c.Email == "" {
+		return "", ErrNoEmail
+	}
+
+	var userID string
+	err := tx.GetContext(ctx, &userID, "SELECT id FROM users WHERE email = $1", c.Email)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// the provider vouches for the address when it says it is verified
+		err = tx.GetContext(ctx, &userID, `
+INSERT INTO users (email, email_verified_at) VALUES ($1, CASE WHEN $2::boolean THEN now() END) RETURNING id`,
+			c.Email, c.EmailVerified)
+		if err != nil {
+			return "", fmt.Errorf("create user: %w", err)
+		}
+	case err != nil:
+		return "", fmt.Errorf("lookup user: %w", err)
+	case !p.cfg.LinkByEmail || !c.EmailVerified:
+		return "", ErrAccountExists
+	}
+
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO user_identities (user_id, issuer, subject, email) VALUES ($1, $2, $3, $4)`,
+		userID, c.Issuer, c.Subject, c.Email)
+	if err != nil {
+		return "", fmt.Errorf("link identity: %w", err)
+	}
+	return userID, nil
+}
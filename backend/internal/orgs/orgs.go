@@ -0,0 +1,375 @@
+// Package orgs manages organizations: groups of users who share the files
+// uploaded to the organization and the organization's storage quota. Every
+// org has exactly one owner; admins invite and remove members.
+package orgs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// ErrNotMember is returned when the caller is not a member of the org;
+	// unknown orgs are reported the same way.
+	ErrNotMember = errors.New("not a member of the organization")
+	// ErrForbidden is returned when the caller's role is too low.
+	ErrForbidden      = errors.New("forbidden")
+	ErrInvalidName    = errors.New("organization name must not be empty")
+	ErrInvalidRole    = errors.New("role must be admin or member")
+	ErrAlreadyMember  = errors.New("user is already a member")
+	ErrMemberNotFound = errors.New("member not found")
+	ErrOwnerRemoval   = errors.New("the owner cannot be removed; transfer ownership first")
+	ErrInviteNotFound = errors.New("invitation not found")
+	ErrOrgNotFound    = errors.New("organization not found")
+	ErrInvalidQuota   = errors.New("quota must not be negative")
+)
+
+// DefaultQuota is the storage quota of organizations without one of their
+// own (ORG_QUOTA_BYTES, default 10MB).
+func DefaultQuota() int64 {
+	quota, err := strconv.ParseInt(os.Getenv("ORG_QUOTA_BYTES"), 10, 64)
+	if err != nil || quota < 0 {
+		return 10485760 // 10MB
+	}
+	return quota
+}
+
+// Role is a member's role in an organization.
+type Role string
+
+const (
+	RoleMember Role = "member"
+	RoleAdmin  Role = "admin"
+	RoleOwner  Role = "owner"
+)
+
+func (r Role) rank() int {
+	switch r {
+	case RoleMember:
+		return 1
+	case RoleAdmin:
+		return 2
+	case RoleOwner:
+		return 3
+	}
+	return 0
+}
+
+type Organization struct {
+	ID   string `db:"id"`
+	Name string `db:"name"`
+	// QuotaBytes is the org's own quota, set by an admin; nil uses
+	// DefaultQuota.
+	QuotaBytes *int64    `db:"quota_bytes"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+// Quota returns the quota in effect for the organization.
+func (o *Organization) Quota() int64 {
+	if o.QuotaBytes != nil {
+		return *o.QuotaBytes
+	}
+	return DefaultQuota()
+}
+
+type Member struct {
+	OrgID    string    `db:"org_id"`
+	UserID   string    `db:"user_id"`
+	Email    string    `db:"email"`
+	Role     Role      `db:"role"`
+	JoinedAt time.Time `db:"joined_at"`
+}
+
+// Invite is a pending invitation of a user to an organization.
+type Invite struct {
+	OrgID     string    `db:"org_id"`
+	OrgName   string    `db:"org_name"`
+	UserID    string    `db:"user_id"`
+	Role      Role      `db:"role"`
+	InvitedBy *string   `db:"invited_by"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Create makes a new organization owned by userID.
+func Create(ctx context.Context, db *sqlx.DB, userID, name string) (*Organization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, ErrInvalidName
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var org Organization
+	err = tx.GetContext(ctx, &org, "INSERT INTO organizations (name) VALUES ($1) RETURNING id, name, quota_bytes, created_at", name)
+	if err != nil {
+		return nil, fmt.Errorf("create organization: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, $3)", org.ID, userID, RoleOwner)
+	if err != nil {
+		return nil, fmt.Errorf("add owner: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return &org, nil
+}
+
+// Get returns an organization userID is a member of.
+func Get(ctx context.Context, db sqlx.QueryerContext, userID, orgID string) (*Organization, error) {
+	var org Organization
+	err := sqlx.GetContext(ctx, db, &org, `
+SELECT o.id, o.name, o.quota_bytes, o.created_at FROM organizations o
+JOIN org_members m ON m.org_id = o.id AND m.user_id = $2
+WHERE o.id = $1`, orgID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotMember
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get organization: %w", err)
+	}
+	return &org, nil
+}
+
+// ListForUser returns the organizations userID is a member of, by name.
+func ListForUser(ctx context.Context, db sqlx.QueryerContext, userID string) ([]Organization, error) {
+	orgs := []Organization{}
+	err := sqlx.SelectContext(ctx, db, &orgs, `
+SELECT o.id, o.name, o.quota_bytes, o.created_at FROM organizations o
+JOIN org_members m ON m.org_id = o.id
+WHERE m.user_id = $1 ORDER BY o.name, o.id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list organizations: %w", err)
+	}
+	return orgs, nil
+}
+
+// Members returns the members of an organization, owner first.
+func Members(ctx context.Context, db sqlx.QueryerContext, orgID string) ([]Member, error) {
+	members := []Member{}
+	err := sqlx.SelectContext(ctx, db, &members, `
+SELECT m.org_id, m.user_id, u.email, m.role, m.joined_at
+FROM org_members m JOIN users u ON u.id = m.user_id
+WHERE m.org_id = $1
+ORDER BY CASE m.role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 ELSE 2 END, m.joined_at`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("list members: %w", err)
+	}
+	return members, nil
+}
+
+// MemberRole returns userID's role in an organization.
+func MemberRole(ctx context.Context, db sqlx.QueryerContext, userID, orgID string) (Role, error) {
+	var role Role
+	err := sqlx.GetContext(ctx, db, &role, "SELECT role FROM org_members WHERE org_id=$1 AND user_id=$2", orgID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotMember
+	}
+	if err != nil {
+		return "", fmt.Errorf("lookup membership: %w", err)
+	}
+	return role, nil
+}
+
+// RequireRole returns userID's role in an organization if it is at least min.
+func RequireRole(ctx context.Context, db sqlx.QueryerContext, userID, orgID string, min Role) (Role, error) {
+	role, err := MemberRole(ctx, db, userID, orgID)
+	if err != nil {
+		return "", err
+	}
+	if role.rank() < min.rank() {
+		return "", ErrForbidden
+	}
+	return role, nil
+}
+
+// InviteMember invites the user with the given email to an organization;
+// they join once they accept (see AcceptInvite). Owners and admins can
+// invite members; only the owner can invite admins. Inviting someone again
+// replaces the earlier invitation. Emails without an account are accepted
+// and ignored, so the result does not tell who has one.
+func InviteMember(ctx context.Context, db *sqlx.DB, userID, orgID, email string, role Role) error {
+	if role != RoleAdmin && role != RoleMember {
+		return ErrInvalidRole
+	}
+	min := RoleAdmin
+	if role == RoleAdmin {
+		min = RoleOwner
+	}
+	if _, err := RequireRole(ctx, db, userID, orgID, min); err != nil {
+		return err
+	}
+
+	var member bool
+	err := db.GetContext(ctx, &member, `
+SELECT EXISTS (SELECT 1 FROM org_members m JOIN users u ON u.id = m.user_id WHERE m.org_id = $1 AND u.email = $2)`, orgID, email)
+	if err != nil {
+		return fmt.Errorf("lookup membership: %w", err)
+	}
+	if member {
+		return ErrAlreadyMember
+	}
+
+	_, err = db.ExecContext(ctx, `
+INSERT INTO org_invites (org_id, user_id, role, invited_by)
+SELECT $1, id, $3, $4 FROM users WHERE email = $2
+ON CONFLICT (org_id, user_id) DO UPDATE SET role = EXCLUDED.role, invited_by = EXCLUDED.invited_by, created_at = now()`,
+		orgID, email, role, userID)
+	if err != nil {
+		return fmt.Errorf("invite member: %w", err)
+	}
+	return nil
+}
+
+// PendingInvites returns the invitations userID has not answered yet, newest
+// first.
+func PendingInvites(ctx context.Context, db sqlx.QueryerContext, userID string) ([]Invite, error) {
+	invites := []Invite{}
+	err := sqlx.SelectContext(ctx, db, &invites, `
+SELECT i.org_id, o.name AS org_name, i.user_id, i.role, i.invited_by, i.created_at
+FROM org_invites i JOIN organizations o ON o.id = i.org_id
+WHERE i.user_id = $1 ORDER BY i.created_at DESC, i.org_id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list invitations: %w", err)
+	}
+	return invites, nil
+}
+
+// AcceptInvite makes userID a member of an organization that invited them,
+// with the role of the invitation.
+func AcceptInvite(ctx context.Context, db *sqlx.DB, userID, orgID string) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var role Role
+	err = tx.GetContext(ctx, &role, "DELETE FROM org_invites WHERE org_id=$1 AND user_id=$2 RETURNING role", orgID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInviteNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("accept invitation: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, $3)
+ON CONFLICT (org_id, user_id) DO NOTHING`, orgID, userID, role)
+	if err != nil {
+		return fmt.Errorf("add member: %w", err)
+	}
+	return tx.Commit()
+}
+
+// DeclineInvite drops an invitation of userID to an organization.
+func DeclineInvite(ctx context.Context, db *sqlx.DB, userID, orgID string) error {
+	res, err := db.ExecContext(ctx, "DELETE FROM org_invites WHERE org_id=$1 AND user_id=$2", orgID, userID)
+	if err != nil {
+		return fmt.Errorf("decline invitation: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+// SetQuota sets an organization's own quota; nil reverts to DefaultQuota.
+// It is an admin operation and does not check membership.
+func SetQuota(ctx context.Context, db *sqlx.DB, orgID string, quota *int64) error {
+	if quota != nil && *quota < 0 {
+		return ErrInvalidQuota
+	}
+	res, err := db.ExecContext(ctx, "UPDATE organizations SET quota_bytes = $2 WHERE id = $1", orgID, quota)
+	if err != nil {
+		return fmt.Errorf("set quota: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrOrgNotFound
+	}
+	return nil
+}
+
+// RemoveMember removes memberID from an organization. Members may leave on
+// their own; otherwise the caller must outrank the member (admins remove
+// members, the owner removes anyone else). The owner cannot be removed. The
+// org files the member uploaded stay in the org and pass to the owner.
+func RemoveMember(ctx context.Context, db *sqlx.DB, userID, orgID, memberID string) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	callerRole, err := MemberRole(ctx, tx, userID, orgID)
+	if err != nil {
+		return err
+	}
+	memberRole := callerRole
+	if memberID != userID {
+		if memberRole, err = MemberRole(ctx, tx, memberID, orgID); errors.Is(err, ErrNotMember) {
+			return ErrMemberNotFound
+		} else if err != nil {
+			return err
+		}
+	}
+	if memberRole == RoleOwner {
+		return ErrOwnerRemoval
+	}
+	if memberID != userID && callerRole.rank() <= memberRole.rank() {
+		return ErrForbidden
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM org_members WHERE org_id=$1 AND user_id=$2", orgID, memberID); err != nil {
+		return fmt.Errorf("remove member: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+UPDATE user_files SET user_id = (SELECT user_id FROM org_members WHERE org_id=$1 AND role='owner')
+WHERE org_id=$1 AND user_id=$2`, orgID, memberID)
+	if err != nil {
+		return fmt.Errorf("reassign files: %w", err)
+	}
+	return tx.Commit()
+}
+
+// TransferOwnership makes newOwnerID, who must already be a member, the
+// owner of an organization. Only the owner can do this; they stay on as an
+// admin.
+func TransferOwnership(ctx context.Context, db *sqlx.DB, userID, orgID, newOwnerID string) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := RequireRole(ctx, tx, userID, orgID, RoleOwner); err != nil {
+		return err
+	}
+	if newOwnerID == userID {
+		return nil
+	}
+	if _, err := MemberRole(ctx, tx, newOwnerID, orgID); errors.Is(err, ErrNotMember) {
+		return ErrMemberNotFound
+	} else if err != nil {
+		return err
+	}
+
+	// demote first: there is at most one owner per org
+	if _, err := tx.ExecContext(ctx, "UPDATE org_members SET role='admin' WHERE org_id=$1 AND user_id=$2", orgID, userID); err != nil {
+		return fmt.Errorf("demote owner: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE org_members SET role='owner' WHERE org_id=$1 AND user_id=$2", orgID, newOwnerID); err != nil {
+		return fmt.Errorf("promote owner: %w", err)
+	}
+	return tx.Commit()
+}
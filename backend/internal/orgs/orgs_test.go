@@ -0,0 +1,165 @@
+package orgs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+func createTestUser(t *testing.T, db *sqlx.DB) (id, email string) {
+	t.Helper()
+	err := db.QueryRowx("INSERT INTO users (email, password_hash) VALUES ('orgs-' || gen_random_uuid() || '@example.com', 'x') RETURNING id, email").
+		Scan(&id, &email)
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM user_files WHERE user_id=$1", id)
+		db.Exec("DELETE FROM users WHERE id=$1", id)
+	})
+	return id, email
+}
+
+func TestMembershipAndOrgFiles(t *testing.T) {
+	db := testdb.Open(t)
+	store, err := storage.NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	ownerID, _ := createTestUser(t, db)
+	memberID, memberEmail := createTestUser(t, db)
+
+	org, err := Create(ctx, db, ownerID, "Team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM user_files WHERE org_id=$1", org.ID)
+		db.Exec("DELETE FROM organizations WHERE id=$1", org.ID)
+	})
+
+	// unknown emails get the same answer as registered ones
+	if err := InviteMember(ctx, db, ownerID, org.ID, "nobody-"+org.ID+"@example.com", RoleMember); err != nil {
+		t.Errorf("inviting an unknown email: %v", err)
+	}
+	if err := InviteMember(ctx, db, ownerID, org.ID, memberEmail, RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	// invitees are not members until they accept
+	if _, err := MemberRole(ctx, db, memberID, org.ID); !errors.Is(err, ErrNotMember) {
+		t.Errorf("invitee role: err = %v, want ErrNotMember", err)
+	}
+	invites, err := PendingInvites(ctx, db, memberID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invites) != 1 || invites[0].OrgID != org.ID || invites[0].OrgName != "Team" || invites[0].Role != RoleMember {
+		t.Fatalf("pending invites = %+v", invites)
+	}
+	if err := DeclineInvite(ctx, db, memberID, org.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := AcceptInvite(ctx, db, memberID, org.ID); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("accepting a declined invite: err = %v, want ErrInviteNotFound", err)
+	}
+	if err := InviteMember(ctx, db, ownerID, org.ID, memberEmail, RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if err := AcceptInvite(ctx, db, memberID, org.ID); err != nil {
+		t.Fatal(err)
+	}
+	if role, err := MemberRole(ctx, db, memberID, org.ID); err != nil || role != RoleMember {
+		t.Errorf("member role = %q, %v; want member", role, err)
+	}
+	if err := InviteMember(ctx, db, ownerID, org.ID, memberEmail, RoleMember); !errors.Is(err, ErrAlreadyMember) {
+		t.Errorf("inviting a member: err = %v, want ErrAlreadyMember", err)
+	}
+	if err := RemoveMember(ctx, db, memberID, org.ID, ownerID); !errors.Is(err, ErrOwnerRemoval) {
+		t.Errorf("member removing owner: err = %v, want ErrOwnerRemoval", err)
+	}
+
+	// same name in the org from two members is one file with two versions
+	attach := func(userID, content string) *storage.AttachResult {
+		t.Helper()
+		staged, err := store.Put(ctx, bytes.NewReader([]byte(content+org.ID)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer staged.Discard()
+		res, err := storage.AttachContent(ctx, db, storage.AttachInput{
+			UserID: userID, OrgID: &org.ID, Filename: "plan.txt",
+			Hash: staged.Hash(), Blob: staged,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	v1 := attach(ownerID, "one")
+	v2 := attach(memberID, "two")
+	if v2.UserFileID != v1.UserFileID || v2.Version != 2 {
+		t.Fatalf("org same-name upload: file %s version %d, want %s version 2", v2.UserFileID, v2.Version, v1.UserFileID)
+	}
+
+	if err := TransferOwnership(ctx, db, memberID, org.ID, memberID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("member transferring ownership: err = %v, want ErrForbidden", err)
+	}
+	if err := TransferOwnership(ctx, db, ownerID, org.ID, memberID); err != nil {
+		t.Fatal(err)
+	}
+	if role, _ := MemberRole(ctx, db, ownerID, org.ID); role != RoleAdmin {
+		t.Errorf("previous owner role = %q, want admin", role)
+	}
+
+	// the old owner leaves; the file they uploaded passes to the new owner
+	if err := RemoveMember(ctx, db, ownerID, org.ID, ownerID); err != nil {
+		t.Fatal(err)
+	}
+	var uploader string
+	if err := db.Get(&uploader, "SELECT user_id FROM user_files WHERE id=$1", v1.UserFileID); err != nil {
+		t.Fatal(err)
+	}
+	if uploader != memberID {
+		t.Errorf("org file owner after leaving = %s, want %s", uploader, memberID)
+	}
+}
+
+func TestOrgQuota(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	ownerID, _ := createTestUser(t, db)
+	org, err := Create(ctx, db, ownerID, "Quota")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM organizations WHERE id=$1", org.ID) })
+
+	t.Setenv("ORG_QUOTA_BYTES", "4096")
+	if got := org.Quota(); got != 4096 {
+		t.Errorf("default quota = %d, want 4096", got)
+	}
+	quota := int64(1024)
+	if err := SetQuota(ctx, db, org.ID, &quota); err != nil {
+		t.Fatal(err)
+	}
+	if org, err = Get(ctx, db, ownerID, org.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := org.Quota(); got != 1024 {
+		t.Errorf("quota = %d, want 1024", got)
+	}
+	negative := int64(-1)
+	if err := SetQuota(ctx, db, org.ID, &negative); !errors.Is(err, ErrInvalidQuota) {
+		t.Errorf("negative quota: err = %v, want ErrInvalidQuota", err)
+	}
+	if err := SetQuota(ctx, db, "00000000-0000-0000-0000-000000000000", nil); !errors.Is(err, ErrOrgNotFound) {
+		t.Errorf("unknown org: err = %v, want ErrOrgNotFound", err)
+	}
+}
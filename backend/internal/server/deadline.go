@@ -0,0 +1,74 @@
+package server
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// transferIdleTimeout bounds how long a single read or write on a streaming
+// transfer may stall. The server-wide ReadTimeout/WriteTimeout would
+// otherwise cut off any upload or download that takes longer than them.
+const transferIdleTimeout = 30 * time.Second
+
+// deadlineWriter pushes the connection's write deadline forward on every
+// write, so a transfer only fails if the client stops reading.
+type deadlineWriter struct {
+	http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func newDeadlineWriter(w http.ResponseWriter) *deadlineWriter {
+	d := &deadlineWriter{ResponseWriter: w, rc: http.NewResponseController(w)}
+	d.extend()
+	return d
+}
+
+func (d *deadlineWriter) extend() {
+	// not every ResponseWriter supports deadlines (e.g. httptest); ignore
+	_ = d.rc.SetWriteDeadline(time.Now().Add(transferIdleTimeout))
+}
+
+func (d *deadlineWriter) Write(p []byte) (int, error) {
+	d.extend()
+	return d.ResponseWriter.Write(p)
+}
+
+func (d *deadlineWriter) Unwrap() http.ResponseWriter {
+	return d.ResponseWriter
+}
+
+// deadlineReader pushes the connection's read deadline forward on every
+// read of the request body, and the write deadline with it, so the response
+// to a long upload can still be written once the body is in.
+type deadlineReader struct {
+	io.Reader
+	rc *http.ResponseController
+}
+
+func newDeadlineReader(w http.ResponseWriter, body io.Reader) *deadlineReader {
+	return &deadlineReader{Reader: body, rc: http.NewResponseController(w)}
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	deadline := time.Now().Add(transferIdleTimeout)
+	_ = d.rc.SetReadDeadline(deadline)
+	_ = d.rc.SetWriteDeadline(deadline)
+	return d.Reader.Read(p)
+}
+
+// MultipartDeadlines lets multipart request bodies (GraphQL file uploads)
+// take longer than the server ReadTimeout: as with tus, the deadlines move
+// forward while data flows, so only a stalled upload is cut off.
+func MultipartDeadlines(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "multipart/form-data" {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{newDeadlineReader(w, r.Body), r.Body}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultipartDeadlinesOutlastReadTimeout(t *testing.T) {
+	h := MultipartDeadlines(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := io.Copy(io.Discard, r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, strconv.FormatInt(n, 10))
+	}))
+	srv := httptest.NewUnstartedServer(h)
+	srv.Config.ReadTimeout = 200 * time.Millisecond
+	srv.Config.WriteTimeout = 200 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	// a body that takes several ReadTimeouts to arrive
+	pr, pw := io.Pipe()
+	go func() {
+		for i := 0; i < 8; i++ {
+			time.Sleep(100 * time.Millisecond)
+			pw.Write([]byte(strings.Repeat("x", 1024)))
+		}
+		pw.Close()
+	}()
+	req, _ := http.NewRequest("POST", srv.URL, pr)
+	req.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("slow upload cut off: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "8192" {
+		t.Errorf("status %d, body %q", resp.StatusCode, body)
+	}
+}
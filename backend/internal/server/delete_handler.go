@@ -1,12 +1,13 @@
 package server
 
 import (
+	"errors"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/rishit911/file_vault_proj-backend/internal/authz"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
 )
 
 func DeleteFileHandler(db *sqlx.DB) http.HandlerFunc {
@@ -29,89 +30,35 @@ func DeleteFileHandler(db *sqlx.DB) http.HandlerFunc {
 			return
 		}
 
-		// fetch owner & file object id
-		var ownerID, fileObjectID string
-		err := db.Get(&ownerID, "SELECT user_id FROM user_files WHERE id=$1", id)
-		if err != nil {
-			http.Error(w, "not found", http.StatusNotFound)
-			return
-		}
-
-		if ownerID != userID {
-			http.Error(w, "forbidden", http.StatusForbidden)
-			return
-		}
-
-		// start tx
-		tx, err := db.Beginx()
-		if err != nil {
-			http.Error(w, "tx error: "+err.Error(), http.StatusInternalServerError)
+		// owners and co-owners may delete
+		if _, err := authz.RequireFile(r.Context(), db, userID, id, authz.Delete); err != nil {
+			http.Error(w, err.Error(), authzStatus(err))
 			return
 		}
 
-		// get file_object_id
-		if err := tx.Get(&fileObjectID, "SELECT file_object_id FROM user_files WHERE id=$1", id); err != nil {
-			tx.Rollback()
+		// move to the owner's trash; the reference is only dropped when it is purged
+		err := storage.TrashUserFile(r.Context(), db, id)
+		if errors.Is(err, storage.ErrUserFileNotFound) {
 			http.Error(w, "not found", http.StatusNotFound)
 			return
 		}
-
-		// delete the user_files entry
-		if _, err := tx.Exec("DELETE FROM user_files WHERE id=$1", id); err != nil {
-			tx.Rollback()
-			http.Error(w, "delete user_file failed: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		// check current ref_count
-		var refCount int
-		if err := tx.Get(&refCount, "SELECT ref_count FROM file_objects WHERE id=$1", fileObjectID); err != nil {
-			tx.Rollback()
-			http.Error(w, "file object not found", http.StatusInternalServerError)
-			return
-		}
-
-		if refCount > 1 {
-			// simply decrement
-			if _, err := tx.Exec("UPDATE file_objects SET ref_count = ref_count - 1 WHERE id=$1", fileObjectID); err != nil {
-				tx.Rollback()
-				http.Error(w, "decrement failed", http.StatusInternalServerError)
-				return
-			}
-
-			if err := tx.Commit(); err != nil {
-				http.Error(w, "commit failed", http.StatusInternalServerError)
-				return
-			}
-
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-
-		// ref_count == 1 -> delete file_objects and remove blob
-		var storagePath string
-		if err := tx.Get(&storagePath, "SELECT storage_path FROM file_objects WHERE id=$1", fileObjectID); err != nil {
-			tx.Rollback()
-			http.Error(w, "storage path fetch failed", http.StatusInternalServerError)
-			return
-		}
-
-		if _, err := tx.Exec("DELETE FROM file_objects WHERE id=$1", fileObjectID); err != nil {
-			tx.Rollback()
-			http.Error(w, "delete file_object failed", http.StatusInternalServerError)
-			return
-		}
-
-		if err := tx.Commit(); err != nil {
-			http.Error(w, "commit failed", http.StatusInternalServerError)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		// remove blob from disk but don't fail the API if remove fails
-		if storagePath != "" {
-			_ = os.Remove(filepath.Clean(storagePath))
-		}
-
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
+
+// authzStatus maps an authz error to an HTTP status code.
+func authzStatus(err error) int {
+	switch {
+	case errors.Is(err, authz.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, authz.ErrForbidden):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+)
+
+// auditWriter records the status and the number of body bytes written.
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (a *auditWriter) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *auditWriter) Write(p []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	n, err := a.ResponseWriter.Write(p)
+	a.n += int64(n)
+	return n, err
+}
+
+func (a *auditWriter) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+// recordDownload writes the audit row for a served GET. Only responses that
+// carry content (200 and 206) are recorded. The transfer counts as completed
+// when every byte of the served range was written.
+func recordDownload(r *http.Request, store downloadStore, aw *auditWriter, ev storage.DownloadEvent) {
+	if r.Method != http.MethodGet || (aw.status != http.StatusOK && aw.status != http.StatusPartialContent) {
+		return
+	}
+
+	ip := auditIP(r.RemoteAddr)
+	ev.IP = &ip
+	if ua := r.UserAgent(); ua != "" {
+		ev.UserAgent = &ua
+	}
+	ev.BytesSent = aw.n
+
+	start, end, ok := servedRange(aw.Header(), aw.status)
+	if ok {
+		ev.RangeStart, ev.RangeEnd = &start, &end
+		ev.Completed = aw.n == end-start+1
+	}
+
+	// the request context is cancelled when the client goes away mid-transfer,
+	// which is exactly the case worth recording
+	if err := store.record(context.WithoutCancel(r.Context()), &ev); err != nil {
+		log.Printf("audit download of %s: %v", ev.UserFileID, err)
+	}
+}
+
+// servedRange returns the inclusive byte range of a 200 or 206 response from
+// its Content-Length or Content-Range header.
+func servedRange(h http.Header, status int) (int64, int64, bool) {
+	if status == http.StatusOK {
+		n, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		return 0, n - 1, true
+	}
+	// "bytes 0-1023/4096"; multipart range responses have no Content-Range
+	spec, ok := strings.CutPrefix(h.Get("Content-Range"), "bytes ")
+	if !ok {
+		return 0, 0, false
+	}
+	spec, _, _ = strings.Cut(spec, "/")
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, err1 := strconv.ParseInt(from, 10, 64)
+	end, err2 := strconv.ParseInt(to, 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// auditIP returns the client address to store. With DOWNLOAD_IP_TRUNCATE set
+// the host part is dropped: IPv4 addresses keep their /24, IPv6 their /48.
+func auditIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	if v, _ := strconv.ParseBool(os.Getenv("DOWNLOAD_IP_TRUNCATE")); !v {
+		return host
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
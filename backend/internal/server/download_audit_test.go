@@ -0,0 +1,46 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuditIP(t *testing.T) {
+	if got := auditIP("203.0.113.57:51234"); got != "203.0.113.57" {
+		t.Errorf("auditIP = %q, want full address", got)
+	}
+
+	t.Setenv("DOWNLOAD_IP_TRUNCATE", "true")
+	if got := auditIP("203.0.113.57:51234"); got != "203.0.113.0" {
+		t.Errorf("truncated IPv4 = %q, want 203.0.113.0", got)
+	}
+	if got := auditIP("[2001:db8:abcd:12::1]:443"); got != "2001:db8:abcd::" {
+		t.Errorf("truncated IPv6 = %q, want 2001:db8:abcd::", got)
+	}
+}
+
+func TestAuditWriterRange(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 100)
+	serve := func(rng string) (*auditWriter, int64, int64, bool) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		aw := &auditWriter{ResponseWriter: httptest.NewRecorder()}
+		http.ServeContent(aw, req, "f.txt", time.Time{}, bytes.NewReader(content))
+		start, end, ok := servedRange(aw.Header(), aw.status)
+		return aw, start, end, ok
+	}
+
+	aw, start, end, ok := serve("")
+	if !ok || aw.status != http.StatusOK || start != 0 || end != 99 || aw.n != 100 {
+		t.Errorf("full: status %d range %d-%d (%v) bytes %d", aw.status, start, end, ok, aw.n)
+	}
+	aw, start, end, ok = serve("bytes=10-19")
+	if !ok || aw.status != http.StatusPartialContent || start != 10 || end != 19 || aw.n != 10 {
+		t.Errorf("range: status %d range %d-%d (%v) bytes %d", aw.status, start, end, ok, aw.n)
+	}
+}
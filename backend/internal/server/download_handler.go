@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/authz"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+)
+
+type downloadRow struct {
+	ID          string         `db:"id"`
+	UserID      string         `db:"user_id"`
+	Filename    string         `db:"filename"`
+	Hash        string         `db:"hash"`
+	StoragePath string         `db:"storage_path"`
+	MimeType    sql.NullString `db:"mime_type"`
+	CreatedAt   time.Time      `db:"created_at"`
+	// ContentPending is set for files registered by hash alone whose owner
+	// has not uploaded the content; they cannot be downloaded.
+	ContentPending bool `db:"content_pending"`
+}
+
+// DownloadHandler serves GET/HEAD /api/v1/files/{id}/content. Range,
+// If-Range and If-None-Match are handled by http.ServeContent using the
+// SHA-256 hash as a strong ETag. Users the file is shared with (any role) and
+// admins may download it too.
+func DownloadHandler(db *sqlx.DB, blobs storage.BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := r.PathValue("id")
+		if id == "" {
+			http.Error(w, "file id required", http.StatusBadRequest)
+			return
+		}
+
+		userID := GetUserIDFromContext(r)
+		if userID == "" {
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+
+		acc, err := authz.FileAccess(r.Context(), db, userID, id)
+		if err != nil {
+			http.Error(w, err.Error(), authzStatus(err))
+			return
+		}
+
+		source := storage.DownloadSourceOwner
+		switch {
+		case acc.Role == authz.RoleOwner:
+		case acc.Role.Allows(authz.Read):
+			source = storage.DownloadSourceGrant
+		default:
+			if !auth.IdentityFromContext(r.Context()).IsAdmin() {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			source = storage.DownloadSourceAdmin
+		}
+
+		serveDownload(w, r, dbDownloads{db}, blobs, id, storage.DownloadEvent{UserID: &userID, Source: source}, nil)
+	}
+}
+
+// downloadStore is what serving a download needs from the database.
+type downloadStore interface {
+	// file looks up a user file that is not in the trash, with its content.
+	file(ctx context.Context, id string) (*downloadRow, error)
+	record(ctx context.Context, ev *storage.DownloadEvent) error
+}
+
+type dbDownloads struct{ db *sqlx.DB }
+
+func (d dbDownloads) file(ctx context.Context, id string) (*downloadRow, error) {
+	var f downloadRow
+	err := d.db.GetContext(ctx, &f, `
+SELECT uf.id, uf.user_id, uf.filename, uf.content_pending, fo.hash, fo.storage_path, fo.mime_type, fo.created_at
+FROM user_files uf
+JOIN file_objects fo ON uf.file_object_id = fo.id
+WHERE uf.id = $1 AND uf.deleted_at IS NULL`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (d dbDownloads) record(ctx context.Context, ev *storage.DownloadEvent) error {
+	return storage.RecordDownload(ctx, d.db, ev)
+}
+
+// serveDownload streams the content of user file id with the download
+// headers shared by the authenticated and the share link routes, and records
+// the download in the audit trail (ev describes who is downloading). admit,
+// if set, runs once the content is known to exist (size is its length); it
+// returns false after writing its own response to refuse the download.
+func serveDownload(w http.ResponseWriter, r *http.Request, store downloadStore, blobs storage.BlobStore, id string, ev storage.DownloadEvent, admit func(f *downloadRow, size int64) bool) {
+	f, err := store.file(r.Context(), id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	// a hash alone does not prove the registrant has the bytes
+	if f.ContentPending {
+		http.Error(w, "file content not uploaded", http.StatusNotFound)
+		return
+	}
+
+	// stat first so a missing blob is a 404 rather than a truncated 200
+	info, err := blobs.Stat(r.Context(), f.StoragePath)
+	if errors.Is(err, storage.ErrBlobNotFound) {
+		http.Error(w, "file content missing", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "stat blob: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if admit != nil && !admit(f, info.Size) {
+		return
+	}
+	blob := storage.NewBlobReadSeeker(r.Context(), blobs, f.StoragePath, info.Size)
+	defer blob.Close()
+
+	contentType := "application/octet-stream"
+	if f.MimeType.Valid && f.MimeType.String != "" {
+		contentType = f.MimeType.String
+	}
+
+	// the type comes from the uploader, so only types a browser cannot run
+	// script in are shown inline
+	disposition := "attachment"
+	if r.URL.Query().Get("disposition") == "inline" && inlineSafe(contentType) {
+		disposition = "inline"
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Disposition", contentDisposition(disposition, f.Filename))
+	h.Set("ETag", `"`+f.Hash+`"`)
+	h.Set("Cache-Control", "private, no-cache")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "sandbox")
+
+	aw := &auditWriter{ResponseWriter: newDeadlineWriter(w)}
+	http.ServeContent(aw, r, f.Filename, f.CreatedAt, blob)
+
+	ev.UserFileID = f.ID
+	recordDownload(r, store, aw, ev)
+}
+
+// inlineSafe reports whether content of contentType may be shown inline:
+// raster images, PDF and plain text.
+func inlineSafe(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mt == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mt, "image/"), mt == "application/pdf", mt == "text/plain":
+		return true
+	}
+	return false
+}
+
+// contentDisposition builds a Content-Disposition value. Non-ASCII names are
+// encoded with the RFC 2231 filename* parameter by mime.FormatMediaType.
+func contentDisposition(dispType, filename string) string {
+	if v := mime.FormatMediaType(dispType, map[string]string{"filename": filename}); v != "" {
+		return v
+	}
+	return dispType
+}
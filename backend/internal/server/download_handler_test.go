@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+)
+
+// fakeDownloads serves rows from a map and keeps the recorded events.
+type fakeDownloads struct {
+	rows   map[string]*downloadRow
+	events []storage.DownloadEvent
+}
+
+func (f *fakeDownloads) file(ctx context.Context, id string) (*downloadRow, error) {
+	row, ok := f.rows[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return row, nil
+}
+
+func (f *fakeDownloads) record(ctx context.Context, ev *storage.DownloadEvent) error {
+	f.events = append(f.events, *ev)
+	return nil
+}
+
+func TestServeDownload(t *testing.T) {
+	ctx := context.Background()
+	blobs, err := storage.NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged, err := blobs.Put(ctx, strings.NewReader("0123456789abcdefghij"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := staged.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	store := &fakeDownloads{rows: map[string]*downloadRow{
+		"file": {
+			ID: "file", Filename: "résumé 2026.txt", Hash: staged.Hash(), StoragePath: staged.Key(),
+			MimeType: sql.NullString{String: "text/plain", Valid: true}, CreatedAt: modified,
+		},
+		"lost": {ID: "lost", Filename: "lost.txt", Hash: strings.Repeat("0", 64), StoragePath: storage.BlobKey(strings.Repeat("0", 64))},
+	}}
+	etag := `"` + staged.Hash() + `"`
+
+	serve := func(id string, header map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/files/"+id+"/content", nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		serveDownload(rec, req, store, blobs, id, storage.DownloadEvent{Source: storage.DownloadSourceOwner}, nil)
+		return rec
+	}
+
+	rec := serve("file", nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "0123456789abcdefghij" {
+		t.Errorf("full: %d %q", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("ETag"); got != etag {
+		t.Errorf("ETag = %q, want %q", got, etag)
+	}
+	if got, want := rec.Header().Get("Content-Disposition"), `attachment; filename*=utf-8''r%C3%A9sum%C3%A9%202026.txt`; got != want {
+		t.Errorf("Content-Disposition = %q, want %q", got, want)
+	}
+
+	rec = serve("file", map[string]string{"Range": "bytes=0-9"})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "0123456789" {
+		t.Errorf("range: %d %q", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("Content-Range"); got != "bytes 0-9/20" {
+		t.Errorf("Content-Range = %q", got)
+	}
+
+	rec = serve("file", map[string]string{"Range": "bytes=50-60"})
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("unsatisfiable range: %d", rec.Code)
+	}
+
+	rec = serve("file", map[string]string{"If-None-Match": etag})
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("If-None-Match: %d %q", rec.Code, rec.Body)
+	}
+
+	// a stale If-Range gets the whole file instead of the range
+	rec = serve("file", map[string]string{"Range": "bytes=0-9", "If-Range": `"` + strings.Repeat("f", 64) + `"`})
+	if rec.Code != http.StatusOK || rec.Body.Len() != 20 {
+		t.Errorf("stale If-Range: %d %q", rec.Code, rec.Body)
+	}
+
+	if rec := serve("lost", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("missing blob: %d", rec.Code)
+	}
+	if rec := serve("unknown", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown file: %d", rec.Code)
+	}
+
+	store.rows["file"].ContentPending = true
+	if rec := serve("file", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("pending content: %d", rec.Code)
+	}
+
+	// 200, 206 and the stale If-Range 200 are audited; 304, 404 and 416 not
+	if len(store.events) != 3 {
+		t.Errorf("recorded %d downloads, want 3", len(store.events))
+	}
+	for _, ev := range store.events {
+		if ev.UserFileID != "file" {
+			t.Errorf("recorded download of %q", ev.UserFileID)
+		}
+	}
+}
+
+func TestServeDownloadInline(t *testing.T) {
+	ctx := context.Background()
+	blobs, err := storage.NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged, err := blobs.Put(ctx, strings.NewReader("<script>alert(1)</script>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := staged.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	store := &fakeDownloads{rows: map[string]*downloadRow{}}
+	cases := []struct {
+		mimeType string
+		inline   bool
+	}{
+		{"image/png", true},
+		{"application/pdf", true},
+		{"text/plain; charset=utf-8", true},
+		{"text/html", false},
+		{"image/svg+xml", false},
+		{"application/xhtml+xml", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		store.rows["file"] = &downloadRow{
+			ID: "file", Filename: "f", Hash: staged.Hash(), StoragePath: staged.Key(),
+			MimeType: sql.NullString{String: c.mimeType, Valid: c.mimeType != ""},
+		}
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/files/file/content?disposition=inline", nil)
+		rec := httptest.NewRecorder()
+		serveDownload(rec, req, store, blobs, "file", storage.DownloadEvent{}, nil)
+
+		want := "attachment"
+		if c.inline {
+			want = "inline"
+		}
+		if got := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(got, want+";") {
+			t.Errorf("%q: Content-Disposition = %q, want %s", c.mimeType, got, want)
+		}
+		if got := rec.Header().Get("Content-Security-Policy"); got != "sandbox" {
+			t.Errorf("%q: Content-Security-Policy = %q", c.mimeType, got)
+		}
+	}
+}
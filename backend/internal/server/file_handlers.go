@@ -5,67 +5,84 @@ import (
 	"net/http"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/rishit911/file_vault_proj-backend/internal/authz"
 	"github.com/rishit911/file_vault_proj-backend/internal/storage"
 )
 
 type fileMetaReq struct {
-	Filename  string `json:"filename"`
-	Hash      string `json:"hash"` // client can provide hash; future: compute on upload
-	SizeBytes int64  `json:"size_bytes"`
-	MimeType  string `json:"mime_type"`
+	Filename string `json:"filename"`
+	Hash     string `json:"hash"` // client can provide hash; future: compute on upload
+	// SizeBytes and MimeType are the client's word only and are not stored:
+	// the file has no size or type until its content is uploaded. SizeBytes
+	// is checked against the quota when the content is new to the server.
+	SizeBytes int64   `json:"size_bytes"`
+	MimeType  string  `json:"mime_type"`
+	FolderID  *string `json:"folder_id,omitempty"`
+	// ReplaceUserFileID registers the content as a new version of that file.
+	ReplaceUserFileID *string `json:"replace_user_file_id,omitempty"`
+	// OrgID registers the file in an organization the caller belongs to.
+	OrgID *string `json:"org_id,omitempty"`
 }
 
 func RegisterFileHandler(db *sqlx.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req fileMetaReq
-		_ = json.NewDecoder(r.Body).Decode(&req)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
 
 		if req.Filename == "" || req.Hash == "" {
 			http.Error(w, "filename & hash required", http.StatusBadRequest)
 			return
 		}
+		if !storage.ValidHash(req.Hash) {
+			http.Error(w, "hash must be a hex-encoded SHA-256", http.StatusBadRequest)
+			return
+		}
+		if req.SizeBytes < 0 {
+			http.Error(w, "size_bytes must not be negative", http.StatusBadRequest)
+			return
+		}
 
-		// check existing file_object by hash
-		fo, err := storage.FindFileObjectByHash(db, req.Hash)
-		if err != nil {
-			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		userID := GetUserIDFromContext(r)
+		if userID == "" {
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
 			return
 		}
 
-		if fo == nil {
-			// create file object - for now store storage_path placeholder
-			storagePath := "/data/files/" + req.Hash
-			fo, err = storage.CreateFileObject(db, req.Hash, storagePath, req.SizeBytes, req.MimeType)
-			if err != nil {
-				http.Error(w, "create failed", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			// increment refcount
-			if err := storage.IncrementRefCount(db, fo.ID); err != nil {
-				http.Error(w, "inc ref failed", http.StatusInternalServerError)
-				return
-			}
+		// a new version of a file shared with the caller goes to the owner's vault
+		target, err := authz.UploadTarget(r.Context(), db, userID, req.OrgID, req.ReplaceUserFileID)
+		if err != nil {
+			http.Error(w, err.Error(), authzStatus(err))
+			return
 		}
 
-		// create user_files entry linking to this user - for now we require Authorization header with Bearer token
-		// TODO: parse JWT and get userID; for now, accept X-User-Id header (temporary)
-		userID := r.Header.Get("X-User-Id")
-		if userID == "" {
-			http.Error(w, "X-User-Id header required (temp)", http.StatusBadRequest)
+		if err := CheckRegistrationQuota(db, target, req.Hash, req.SizeBytes); err != nil {
+			http.Error(w, err.Error(), ingestErrorStatus(err))
 			return
 		}
 
-		var userFileID string
-		err = db.Get(&userFileID, "INSERT INTO user_files (id, user_id, file_object_id, filename) VALUES (gen_random_uuid(), $1,$2,$3) RETURNING id", userID, fo.ID, req.Filename)
+		// the blob key is derived from the hash; content is uploaded separately
+		res, err := storage.AttachContent(r.Context(), db, storage.AttachInput{
+			UserID:            target.OwnerID,
+			Filename:          req.Filename,
+			Hash:              req.Hash,
+			FolderID:          req.FolderID,
+			ReplaceUserFileID: req.ReplaceUserFileID,
+			OrgID:             target.OrgID,
+		})
 		if err != nil {
-			http.Error(w, "create user_file failed: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, err.Error(), ingestErrorStatus(err))
 			return
 		}
 
-		_ = json.NewEncoder(w).Encode(map[string]string{
-			"file_object_id": fo.ID,
-			"user_file_id":   userFileID,
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"file_object_id":  res.FileObject.ID,
+			"user_file_id":    res.UserFileID,
+			"filename":        res.Filename,
+			"version":         res.Version,
+			"content_pending": res.ContentPending,
 		})
 	}
 }
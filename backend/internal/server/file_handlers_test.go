@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+func TestRegisterFileHandler(t *testing.T) {
+	db := testdb.Open(t)
+	var userID, hash string
+	err := db.Get(&userID, "INSERT INTO users (email, password_hash, quota_bytes) VALUES ('register-' || gen_random_uuid() || '@example.com', 'x', 100) RETURNING id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&hash, "SELECT encode(sha256(gen_random_uuid()::text::bytea), 'hex')"); err != nil {
+		t.Fatal(err)
+	}
+	h := RegisterFileHandler(db)
+	post := func(body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/files/register", strings.NewReader(body))
+		req = req.WithContext(auth.WithIdentity(req.Context(), &auth.Identity{UserID: userID, Role: auth.RoleUser}))
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+
+	if rec := post(`{"filename": "a.txt", "hash": `); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed body: %d", rec.Code)
+	}
+	if rec := post(`{"filename": "a.txt", "hash": "` + hash + `", "size_bytes": 101}`); rec.Code != http.StatusForbidden {
+		t.Errorf("over quota: %d %s", rec.Code, rec.Body)
+	}
+	if rec := post(`{"filename": "a.txt", "hash": "` + hash + `", "size_bytes": 100}`); rec.Code != http.StatusOK {
+		t.Errorf("within quota: %d %s", rec.Code, rec.Body)
+	}
+}
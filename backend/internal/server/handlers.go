@@ -2,12 +2,17 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/mail"
 )
 
 type registerReq struct {
@@ -20,7 +25,10 @@ type loginReq struct {
 	Password string `json:"password"`
 }
 
-func RegisterHandler(db *sqlx.DB) http.HandlerFunc {
+// RegisterHandler creates an account and mails a link that verifies its
+// email address. Until then the account can only read (see
+// auth.Authenticate).
+func RegisterHandler(db *sqlx.DB, m *mail.Sender) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req registerReq
 		_ = json.NewDecoder(r.Body).Decode(&req)
@@ -29,6 +37,15 @@ func RegisterHandler(db *sqlx.DB) http.HandlerFunc {
 			http.Error(w, "email & password required", http.StatusBadRequest)
 			return
 		}
+		email, err := auth.ValidateEmail(req.Email)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := auth.ValidatePassword(req.Password, email); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		pwHash, err := auth.HashPassword(req.Password)
 		if err != nil {
@@ -37,17 +54,29 @@ func RegisterHandler(db *sqlx.DB) http.HandlerFunc {
 		}
 
 		id := uuid.New().String()
-		_, err = db.Exec(`INSERT INTO users (id, email, password_hash) VALUES ($1,$2,$3)`, id, req.Email, pwHash)
+		_, err = db.Exec(`INSERT INTO users (id, email, password_hash) VALUES ($1,$2,$3)`, id, email, pwHash)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			http.Error(w, "email already registered", http.StatusConflict)
+			return
+		}
 		if err != nil {
 			http.Error(w, "user create failed", http.StatusInternalServerError)
 			return
 		}
 
+		// the account exists either way; the link can be sent again
+		if err := auth.SendVerificationEmail(r.Context(), db, m, id); err != nil {
+			log.Printf("send verification email: %v", err)
+		}
+
 		w.WriteHeader(http.StatusCreated)
 		_ = json.NewEncoder(w).Encode(map[string]string{"id": id})
 	}
 }
 
+// LoginHandler checks a password (see auth.CheckPassword for the throttling
+// of failed attempts) and starts a session.
 func LoginHandler(db *sqlx.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req loginReq
@@ -58,24 +87,299 @@ func LoginHandler(db *sqlx.DB) http.HandlerFunc {
 			return
 		}
 
-		var id, pwHash string
-		err := db.QueryRowx(`SELECT id, password_hash FROM users WHERE email=$1`, req.Email).Scan(&id, &pwHash)
-		if err != nil {
+		id, err := auth.CheckPassword(r.Context(), db, req.Email, req.Password, auth.DeviceFromRequest(r))
+		var throttled *auth.ThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			w.Header().Set("Retry-After", strconv.Itoa(int(throttled.RetryAfter.Seconds())))
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case errors.Is(err, auth.ErrInvalidCredentials):
 			http.Error(w, "invalid credentials", http.StatusUnauthorized)
 			return
+		case errors.Is(err, auth.ErrAccountSuspended):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
 		}
 
-		if err := auth.CompareHashAndPassword(pwHash, req.Password); err != nil {
-			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		res, err := loginResult(r, db, id)
+		if errors.Is(err, auth.ErrAccountSuspended) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, "token error", http.StatusInternalServerError)
 			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
 
-		token, err := auth.GenerateJWT(id, 24*time.Hour)
+// loginResult finishes a login whose first factor checked out: it starts a
+// session, or with two-factor authentication returns a partial token for
+// POST /api/v1/auth/mfa/verify.
+func loginResult(r *http.Request, db *sqlx.DB, userID string) (map[string]any, error) {
+	mfa, err := auth.GetMFAState(r.Context(), db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa.Pending() {
+		mfaToken, err := auth.GenerateMFAToken(userID)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{
+			"mfa_required":            true,
+			"mfa_token":               mfaToken,
+			"mfa_enrollment_required": !mfa.Enabled,
+		}, nil
+	}
+
+	tokens, err := auth.StartSession(r.Context(), db, userID, auth.DeviceFromRequest(r))
+	if err != nil {
+		return nil, err
+	}
+	return tokenFields(tokens), nil
+}
+
+type refreshReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshHandler exchanges a refresh token for a new access and refresh
+// token. Each refresh token works once; reusing one revokes its session.
+func RefreshHandler(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req refreshReq
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		if req.RefreshToken == "" {
+			http.Error(w, "refresh_token required", http.StatusBadRequest)
+			return
+		}
+
+		tokens, err := auth.Refresh(r.Context(), db, req.RefreshToken, auth.DeviceFromRequest(r))
+		if errors.Is(err, auth.ErrInvalidRefreshToken) || errors.Is(err, auth.ErrRefreshTokenReused) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
 		if err != nil {
 			http.Error(w, "token error", http.StatusInternalServerError)
 			return
 		}
 
-		_ = json.NewEncoder(w).Encode(map[string]string{"token": token})
+		writeTokens(w, tokens)
+	}
+}
+
+// LogoutHandler revokes the session of a refresh token. It always succeeds,
+// so it can be called with a token that has already been revoked.
+func LogoutHandler(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req refreshReq
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		if req.RefreshToken == "" {
+			http.Error(w, "refresh_token required", http.StatusBadRequest)
+			return
+		}
+
+		if err := auth.Logout(r.Context(), db, req.RefreshToken); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type mfaReq struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// MFAVerifyHandler is the second login step: it exchanges the mfa_token from
+// login and a TOTP or recovery code for a session. For users who had to
+// enroll first (see MFAEnrollHandler) the code confirms the enrollment and
+// the response also has their recovery codes.
+func MFAVerifyHandler(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req mfaReq
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		if req.MFAToken == "" || req.Code == "" {
+			http.Error(w, "mfa_token & code required", http.StatusBadRequest)
+			return
+		}
+
+		userID, recoveryCodes, err := auth.VerifyMFA(r.Context(), db, req.MFAToken, req.Code, auth.DeviceFromRequest(r))
+		var throttled *auth.ThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			w.Header().Set("Retry-After", strconv.Itoa(int(throttled.RetryAfter.Seconds())))
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case errors.Is(err, auth.ErrInvalidMFAToken), errors.Is(err, auth.ErrInvalidMFACode):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case errors.Is(err, auth.ErrMFANotEnabled), errors.Is(err, auth.ErrNoPendingEnrollment):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+
+		tokens, err := auth.StartSession(r.Context(), db, userID, auth.DeviceFromRequest(r))
+		if errors.Is(err, auth.ErrAccountSuspended) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, "token error", http.StatusInternalServerError)
+			return
+		}
+		res := tokenFields(tokens)
+		if recoveryCodes != nil {
+			res["recovery_codes"] = recoveryCodes
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+// MFAEnrollHandler starts TOTP enrollment for a user whose role requires
+// two-factor authentication and who logged in without it
+// (mfa_enrollment_required). Logged-in users enroll over GraphQL.
+func MFAEnrollHandler(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req mfaReq
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		userID, err := auth.ParseMFAToken(req.MFAToken)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		enrollment, err := auth.BeginTOTPEnrollment(r.Context(), db, userID)
+		if errors.Is(err, auth.ErrMFAAlreadyEnabled) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"secret": enrollment.Secret,
+			"uri":    enrollment.URI,
+		})
+	}
+}
+
+type tokenReq struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// VerifyEmailHandler verifies an email address with the token from the
+// registration email: GET with ?token= (the mailed link) or POST with
+// {"token": ...}.
+func VerifyEmailHandler(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if r.Method == http.MethodPost {
+			var req tokenReq
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			token = req.Token
+		}
+		if token == "" {
+			http.Error(w, "token required", http.StatusBadRequest)
+			return
+		}
+
+		err := auth.VerifyEmail(r.Context(), db, token)
+		if errors.Is(err, auth.ErrInvalidEmailToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = w.Write([]byte("Your email address is verified. You can close this page.\n"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ForgotPasswordHandler mails a password reset link. It responds 202 whether
+// or not the email belongs to an account.
+func ForgotPasswordHandler(db *sqlx.DB, m *mail.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req registerReq
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		if req.Email == "" {
+			http.Error(w, "email required", http.StatusBadRequest)
+			return
+		}
+		auth.RequestPasswordReset(r.Context(), db, m, req.Email)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// ResetPasswordHandler sets a new password with the token from a reset
+// email. All of the user's sessions are signed out.
+func ResetPasswordHandler(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req tokenReq
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		if req.Token == "" || req.Password == "" {
+			http.Error(w, "token & password required", http.StatusBadRequest)
+			return
+		}
+		err := auth.ResetPassword(r.Context(), db, req.Token, req.Password)
+		if errors.Is(err, auth.ErrInvalidEmailToken) || auth.IsPasswordPolicyError(err) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeTokens(w http.ResponseWriter, t *auth.TokenPair) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(tokenFields(t))
+}
+
+func tokenFields(t *auth.TokenPair) map[string]any {
+	return map[string]any{
+		"token":         t.AccessToken,
+		"refresh_token": t.RefreshToken,
+		"expires_at":    t.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// JWKSHandler serves the public keys tokens are signed with (see
+// auth.Keyring.JWKS).
+func JWKSHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		_, _ = w.Write(auth.Keys().JWKS())
 	}
 }
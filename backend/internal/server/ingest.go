@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rishit911/file_vault_proj-backend/internal/authz"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+)
+
+// IngestInput is one file being added to a user's vault.
+type IngestInput struct {
+	UserID   string
+	Filename string
+	// DeclaredMime is the client-supplied content type, if any. It must agree
+	// with the sniffed type (see checkMime).
+	DeclaredMime string
+	Content      io.Reader
+	// FolderID is the destination folder; nil is the root.
+	FolderID *string
+	// ReplaceUserFileID makes the upload a new version of that file (see
+	// storage.AttachInput).
+	ReplaceUserFileID *string
+	// OrgID makes the upload a file of that organization, which the user
+	// must be a member of.
+	OrgID *string
+}
+
+// IngestResult describes the stored file. The JSON shape is the one the
+// REST upload endpoint has always returned.
+type IngestResult struct {
+	Filename     string `json:"filename"`
+	FileObjectID string `json:"file_object_id"`
+	UserFileID   string `json:"user_file_id"`
+	Hash         string `json:"hash"`
+	SizeBytes    int64  `json:"size_bytes"`
+	MimeType     string `json:"mime_type"`
+	Version      int    `json:"version"`
+	// OwnerID owns the file; it differs from the uploader when a new
+	// version is added to a file shared with them.
+	OwnerID string `json:"-"`
+	// Unchanged is set when the content matched the current version.
+	Unchanged bool `json:"unchanged,omitempty"`
+}
+
+type MimeMismatchError struct {
+	Filename, Declared, Detected string
+}
+
+func (e *MimeMismatchError) Error() string {
+	return fmt.Sprintf("mime mismatch for %s: declared=%s detected=%s", e.Filename, e.Declared, e.Detected)
+}
+
+type QuotaExceededError struct {
+	Used int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("storage quota exceeded: used %d bytes", e.Used)
+}
+
+// IngestFile is the upload pipeline shared by every way content enters the
+// vault: MIME sniffing, streaming SHA-256 into the blob store, the quota
+// check, and storage.AttachContent for dedup and the user_files insert.
+func IngestFile(ctx context.Context, db *sqlx.DB, blobs storage.BlobStore, in IngestInput) (*IngestResult, error) {
+	// editors of a shared file add versions to the owner's vault and quota;
+	// org files count against the org's own quota
+	target, err := authz.UploadTarget(ctx, db, in.UserID, in.OrgID, in.ReplaceUserFileID)
+	if err != nil {
+		return nil, err
+	}
+
+	// read first 512 bytes for mime detection
+	head := make([]byte, 512)
+	n, err := io.ReadFull(in.Content, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("read upload: %w", err)
+	}
+	detectedMime := http.DetectContentType(head[:n])
+	if err := checkMime(in.Filename, in.DeclaredMime, detectedMime); err != nil {
+		return nil, err
+	}
+
+	// stream head + rest into the blob store's staging area, hashing on the way
+	staged, err := blobs.Put(ctx, io.MultiReader(bytes.NewReader(head[:n]), in.Content))
+	if err != nil {
+		return nil, fmt.Errorf("store upload: %w", err)
+	}
+
+	totalSize := staged.Size()
+	hash := staged.Hash()
+
+	// Check storage quota before processing
+	ok, used, err := CheckStorageQuota(db, target.OwnerID, target.OrgID, totalSize)
+	if err != nil {
+		staged.Discard()
+		return nil, fmt.Errorf("quota check failed: %w", err)
+	}
+	if !ok {
+		staged.Discard()
+		return nil, &QuotaExceededError{Used: used}
+	}
+
+	// dedup + ref count + user_files insert in one transaction
+	res, err := storage.AttachContent(ctx, db, storage.AttachInput{
+		UserID:            target.OwnerID,
+		Filename:          in.Filename,
+		FolderID:          in.FolderID,
+		ReplaceUserFileID: in.ReplaceUserFileID,
+		OrgID:             target.OrgID,
+		Hash:              hash,
+		MimeType:          detectedMime,
+		Blob:              staged,
+	})
+	// no-op if the blob was committed
+	staged.Discard()
+	if err != nil {
+		return nil, err
+	}
+
+	return &IngestResult{
+		Filename:     res.Filename,
+		FileObjectID: res.FileObject.ID,
+		UserFileID:   res.UserFileID,
+		Hash:         hash,
+		SizeBytes:    totalSize,
+		MimeType:     detectedMime,
+		Version:      res.Version,
+		Unchanged:    res.Unchanged,
+		OwnerID:      target.OwnerID,
+	}, nil
+}
+
+func checkMime(filename, declared, detected string) error {
+	if declared != "" && declared != detected {
+		// Allow charset variations for text types
+		if !strings.HasPrefix(declared, "text/") || !strings.HasPrefix(detected, declared) {
+			return &MimeMismatchError{Filename: filename, Declared: declared, Detected: detected}
+		}
+	}
+	return nil
+}
+
+// ingestErrorStatus maps an IngestFile error to an HTTP status code.
+func ingestErrorStatus(err error) int {
+	var mimeErr *MimeMismatchError
+	var quotaErr *QuotaExceededError
+	switch {
+	case errors.As(err, &mimeErr):
+		return http.StatusBadRequest
+	case errors.As(err, &quotaErr):
+		return http.StatusForbidden
+	case errors.Is(err, storage.ErrFolderNotFound), errors.Is(err, storage.ErrUserFileNotFound),
+		errors.Is(err, authz.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, authz.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, storage.ErrOrgFolder):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrNameConflict):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
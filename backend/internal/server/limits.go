@@ -1,51 +1,72 @@
 package server
 
 import (
+	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"golang.org/x/time/rate"
 )
 
+// limiterIdleTTL is how long a key keeps its limiter without requests. A
+// limiter idle that long has refilled, so forgetting it loses nothing.
+const limiterIdleTTL = 10 * time.Minute
+
 type RateLimiterStore struct {
-	mu sync.Mutex
-	m  map[string]*rate.Limiter
-	r  rate.Limit
-	b  int
+	mu        sync.Mutex
+	m         map[string]*limiterEntry
+	r         rate.Limit
+	b         int
+	lastSweep time.Time
+}
+
+type limiterEntry struct {
+	l        *rate.Limiter
+	lastSeen time.Time
 }
 
 func NewRateLimiterStore(r rate.Limit, b int) *RateLimiterStore {
 	return &RateLimiterStore{
-		m: map[string]*rate.Limiter{},
-		r: r,
-		b: b,
+		m:         map[string]*limiterEntry{},
+		r:         r,
+		b:         b,
+		lastSweep: time.Now(),
 	}
 }
 
-func (s *RateLimiterStore) Get(userID string) *rate.Limiter {
+func (s *RateLimiterStore) Get(key string) *rate.Limiter {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if l, ok := s.m[userID]; ok {
-		return l
+	now := time.Now()
+	if now.Sub(s.lastSweep) > limiterIdleTTL {
+		s.sweep(now)
+	}
+	if e, ok := s.m[key]; ok {
+		e.lastSeen = now
+		return e.l
 	}
 
-	l := rate.NewLimiter(s.r, s.b)
-	s.m[userID] = l
-	return l
+	e := &limiterEntry{l: rate.NewLimiter(s.r, s.b), lastSeen: now}
+	s.m[key] = e
+	return e.l
 }
 
-func RateLimitMiddleware(store *RateLimiterStore, next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		userID := GetUserIDFromContext(r)
-
-		// Use IP address for unauthenticated requests, userID for authenticated ones
-		key := userID
-		if key == "" {
-			key = "anonymous:" + r.RemoteAddr
+// sweep drops the limiters of keys idle for limiterIdleTTL, so the store does
+// not grow with every address ever seen.
+func (s *RateLimiterStore) sweep(now time.Time) {
+	for k, e := range s.m {
+		if now.Sub(e.lastSeen) > limiterIdleTTL {
+			delete(s.m, k)
 		}
+	}
+	s.lastSweep = now
+}
 
-		limiter := store.Get(key)
+func RateLimitMiddleware(store *RateLimiterStore, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limiter := store.Get(rateLimitKey(r))
 		if !limiter.Allow() {
 			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
 			return
@@ -54,3 +75,16 @@ func RateLimitMiddleware(store *RateLimiterStore, next http.HandlerFunc) http.Ha
 		next(w, r)
 	}
 }
+
+// rateLimitKey is the user for authenticated requests and the client host
+// otherwise; the port is left out, as every new connection gets its own.
+func rateLimitKey(r *http.Request) string {
+	if userID := GetUserIDFromContext(r); userID != "" {
+		return userID
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "anonymous:" + host
+}
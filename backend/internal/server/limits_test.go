@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimitKeyIgnoresPort(t *testing.T) {
+	a := httptest.NewRequest("GET", "/", nil)
+	a.RemoteAddr = "192.0.2.1:40000"
+	b := httptest.NewRequest("GET", "/", nil)
+	b.RemoteAddr = "192.0.2.1:40001"
+	if ka, kb := rateLimitKey(a), rateLimitKey(b); ka != kb || ka != "anonymous:192.0.2.1" {
+		t.Errorf("keys %q and %q, want both anonymous:192.0.2.1", ka, kb)
+	}
+}
+
+func TestRateLimiterStoreEvictsIdle(t *testing.T) {
+	s := NewRateLimiterStore(rate.Limit(1), 1)
+	s.Get("idle")
+	s.m["idle"].lastSeen = time.Now().Add(-2 * limiterIdleTTL)
+	s.Get("active")
+	s.lastSweep = time.Now().Add(-2 * limiterIdleTTL)
+
+	s.Get("active")
+	if _, ok := s.m["idle"]; ok {
+		t.Error("idle limiter not evicted")
+	}
+	if _, ok := s.m["active"]; !ok {
+		t.Error("active limiter evicted")
+	}
+}
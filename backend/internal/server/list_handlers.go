@@ -2,21 +2,27 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/rishit911/file_vault_proj-backend/internal/authz"
+	"github.com/rishit911/file_vault_proj-backend/internal/orgs"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
 )
 
 type fileListItem struct {
-	UserFileID        string `db:"user_file_id" json:"user_file_id"`
-	FileObjectID      string `db:"file_object_id" json:"file_object_id"`
-	Filename          string `db:"filename" json:"filename"`
-	SizeBytes         int64  `db:"size_bytes" json:"size_bytes"`
-	MimeType          string `db:"mime_type" json:"mime_type"`
-	RefCount          int    `db:"ref_count" json:"ref_count"`
-	StoragePath       string `db:"storage_path" json:"storage_path"`
-	UploadedAt        string `db:"uploaded_at" json:"uploaded_at"`
-	StorageSavedBytes int64  `json:"storage_saved_bytes"`
+	UserFileID        string  `db:"user_file_id" json:"user_file_id"`
+	FileObjectID      string  `db:"file_object_id" json:"file_object_id"`
+	Filename          string  `db:"filename" json:"filename"`
+	SizeBytes         int64   `db:"size_bytes" json:"size_bytes"`
+	MimeType          string  `db:"mime_type" json:"mime_type"`
+	RefCount          int     `db:"ref_count" json:"ref_count"`
+	StoragePath       string  `db:"storage_path" json:"storage_path"`
+	UploadedAt        string  `db:"uploaded_at" json:"uploaded_at"`
+	FolderID          *string `db:"folder_id" json:"folder_id"`
+	Version           int     `db:"version" json:"version"`
+	StorageSavedBytes int64   `json:"storage_saved_bytes"`
 }
 
 func ListFilesHandler(db *sqlx.DB) http.HandlerFunc {
@@ -27,6 +33,51 @@ func ListFilesHandler(db *sqlx.DB) http.HandlerFunc {
 			return
 		}
 
+		// ?folder_id= or ?path= lists a single folder ("/" is the root);
+		// without either, all of the user's files are listed. folder_id may
+		// also be a folder shared with the user, listing the owner's files.
+		// ?org_id= lists the files of an organization the user belongs to.
+		ownerID := userID
+		var folderID, orgID *string
+		inFolder := false
+		switch {
+		case r.URL.Query().Get("org_id") != "":
+			id := r.URL.Query().Get("org_id")
+			_, err := orgs.MemberRole(r.Context(), db, userID, id)
+			if errors.Is(err, orgs.ErrNotMember) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			orgID = &id
+		case r.URL.Query().Get("folder_id") != "":
+			id := r.URL.Query().Get("folder_id")
+			acc, err := authz.RequireFolder(r.Context(), db, userID, id, authz.Read)
+			if errors.Is(err, authz.ErrNotFound) || errors.Is(err, authz.ErrForbidden) {
+				http.Error(w, "folder not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			ownerID, folderID, inFolder = acc.OwnerID, &id, true
+		case r.URL.Query().Has("path"):
+			id, err := storage.ResolveFolderPath(r.Context(), db, userID, r.URL.Query().Get("path"))
+			if errors.Is(err, storage.ErrFolderNotFound) {
+				http.Error(w, "folder not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			folderID, inFolder = id, true
+		}
+
 		var items []fileListItem
 		query := `
 SELECT
@@ -37,13 +88,17 @@ SELECT
     fo.mime_type,
     fo.ref_count,
     fo.storage_path,
-    uf.uploaded_at
+    uf.uploaded_at,
+    uf.folder_id,
+    uf.version
 FROM user_files uf
 JOIN file_objects fo ON uf.file_object_id = fo.id
-WHERE uf.user_id = $1
+WHERE uf.deleted_at IS NULL
+    AND CASE WHEN $4::uuid IS NULL THEN uf.user_id = $1 AND uf.org_id IS NULL ELSE uf.org_id = $4 END
+    AND (NOT $2 OR uf.folder_id IS NOT DISTINCT FROM $3)
 ORDER BY uf.uploaded_at DESC`
 
-		if err := db.Select(&items, query, userID); err != nil {
+		if err := db.Select(&items, query, ownerID, inFolder, folderID, orgID); err != nil {
 			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
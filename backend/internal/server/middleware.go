@@ -1,48 +1,121 @@
 package server
 
 import (
-	"context"
+	"errors"
 	"net/http"
 	"strings"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/rishit911/file_vault_proj-backend/internal/auth"
 )
 
-type ctxKey string
+var errInvalidAuthHeader = errors.New("invalid Authorization header")
 
-const userIDKey ctxKey = "userID"
+// identify authenticates the bearer token of r; a nil Identity means there
+// is none.
+func identify(r *http.Request, db *sqlx.DB) (*auth.Identity, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		return nil, errInvalidAuthHeader
+	}
+	id, err := auth.Authenticate(r.Context(), db, parts[1], auth.DeviceFromRequest(r).IP)
+	if err != nil {
+		return nil, err
+	}
+	if id.UserID == "" {
+		return nil, errors.New("invalid token")
+	}
+	return id, nil
+}
 
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// Authenticate attaches the caller to requests with a valid token and lets
+// the others through unauthenticated, for handlers that check per operation
+// (the GraphQL endpoint). Suspended users are turned away.
+func Authenticate(db *sqlx.DB, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "missing Authorization header", http.StatusUnauthorized)
+		// tokens of revoked sessions are ignored
+		id, err := identify(r, db)
+		if errors.Is(err, auth.ErrAccountSuspended) {
+			http.Error(w, err.Error(), http.StatusForbidden)
 			return
 		}
-
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			http.Error(w, "invalid Authorization header", http.StatusUnauthorized)
-			return
+		if err == nil && id != nil {
+			r = r.WithContext(auth.WithIdentity(r.Context(), id))
 		}
+		next(w, r)
+	}
+}
 
-		token := parts[1]
-		userID, err := auth.ParseAndValidateJWT(token)
-		if err != nil || userID == "" {
+// AuthMiddleware requires a valid access token whose session has not been
+// revoked, or a personal access token that grants scope.
+func AuthMiddleware(db *sqlx.DB, scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := identify(r, db)
+		switch {
+		case id == nil && err == nil:
+			http.Error(w, "missing Authorization header", http.StatusUnauthorized)
+			return
+		case errors.Is(err, errInvalidAuthHeader), errors.Is(err, auth.ErrSessionRevoked), errors.Is(err, auth.ErrTokenRejected):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case errors.Is(err, auth.ErrAccountSuspended):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
 			http.Error(w, "invalid token", http.StatusUnauthorized)
 			return
 		}
+		if !id.Can(scope) {
+			msg := "token lacks the " + scope + " scope"
+			if id.SessionID != "" {
+				// sessions are only limited until the email address is verified
+				msg = "verify your email address first"
+			}
+			http.Error(w, msg, http.StatusForbidden)
+			return
+		}
 
-		ctx := context.WithValue(r.Context(), userIDKey, userID)
-		next(w, r.WithContext(ctx))
+		next(w, r.WithContext(auth.WithIdentity(r.Context(), id)))
 	}
 }
 
-func GetUserIDFromContext(r *http.Request) string {
-	if v := r.Context().Value(userIDKey); v != nil {
-		if s, ok := v.(string); ok {
-			return s
+// RequireRole refuses requests of users without role (admins have every
+// role). It goes inside AuthMiddleware, e.g. for admin routes:
+//
+//	AuthMiddleware(db, auth.ScopeAdmin, RequireRole(auth.RoleAdmin, h))
+func RequireRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := auth.IdentityFromContext(r.Context())
+		if id == nil {
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+		if !id.HasRole(role) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
 		}
+		next(w, r)
+	}
+}
+
+func GetUserIDFromContext(r *http.Request) string {
+	return auth.UserIDFromContext(r.Context())
+}
+
+// GetSessionIDFromContext returns the session of the access token used.
+func GetSessionIDFromContext(r *http.Request) string {
+	return auth.SessionIDFromContext(r.Context())
+}
+
+// GetScopesFromContext returns the scopes of the token used; nil for a
+// login session.
+func GetScopesFromContext(r *http.Request) auth.Scopes {
+	if id := auth.IdentityFromContext(r.Context()); id != nil {
+		return id.Scopes
 	}
-	return ""
+	return nil
 }
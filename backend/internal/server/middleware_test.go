@@ -0,0 +1,32 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+)
+
+func TestRequireRole(t *testing.T) {
+	h := RequireRole(auth.RoleAdmin, func(w http.ResponseWriter, r *http.Request) {})
+	cases := []struct {
+		id   *auth.Identity
+		want int
+	}{
+		{nil, http.StatusUnauthorized},
+		{&auth.Identity{UserID: "u1", Role: auth.RoleUser}, http.StatusForbidden},
+		{&auth.Identity{UserID: "u2", Role: auth.RoleAdmin}, http.StatusOK},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/admin", nil)
+		if c.id != nil {
+			r = r.WithContext(auth.WithIdentity(r.Context(), c.id))
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != c.want {
+			t.Errorf("identity %+v: status %d, want %d", c.id, w.Code, c.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/oidc"
+)
+
+// oidcStateCookie binds a login to the browser that started it. It holds a
+// hash of the state, which the callback has to match, so a callback URL from
+// someone else's login (login CSRF) is refused.
+const oidcStateCookie = "fv_oidc_state"
+
+// OIDCLoginHandler starts single sign-on: it sends the browser to the
+// provider's login page.
+func OIDCLoginHandler(db *sqlx.DB, p *oidc.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := p.NewAuthRequest(r.Context())
+		if err != nil {
+			log.Printf("oidc login: %v", err)
+			http.Error(w, "identity provider unavailable", http.StatusBadGateway)
+			return
+		}
+		if err := oidc.SaveAuthRequest(r.Context(), db, req); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		setOIDCStateCookie(w, r, stateHash(req.State), int(oidc.LoginStateTTL.Seconds()))
+		http.Redirect(w, r, req.URL, http.StatusFound)
+	}
+}
+
+// OIDCCallbackHandler is where the provider sends the browser back. It
+// verifies the login, provisions the user on their first login and responds
+// like POST /api/v1/auth/login. With OIDC_POST_LOGIN_REDIRECT set the
+// browser is redirected there instead, with the response in the URL
+// fragment.
+func OIDCCallbackHandler(db *sqlx.DB, p *oidc.Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if e := q.Get("error"); e != "" {
+			http.Error(w, fmt.Sprintf("login failed: %s %s", e, q.Get("error_description")), http.StatusUnauthorized)
+			return
+		}
+
+		// SameSite=Lax still sends the cookie on the provider's redirect
+		cookie, err := r.Cookie(oidcStateCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(stateHash(q.Get("state")))) != 1 {
+			http.Error(w, "login was not started in this browser", http.StatusBadRequest)
+			return
+		}
+		setOIDCStateCookie(w, r, "", -1)
+
+		req, err := oidc.TakeAuthRequest(r.Context(), db, q.Get("state"))
+		if errors.Is(err, oidc.ErrUnknownState) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+
+		claims, err := p.Exchange(r.Context(), q.Get("code"), req.Verifier, req.Nonce)
+		if err != nil {
+			log.Printf("oidc callback: %v", err)
+			http.Error(w, "login failed", http.StatusUnauthorized)
+			return
+		}
+		userID, err := oidc.Provision(r.Context(), db, p, claims)
+		if errors.Is(err, oidc.ErrNoEmail) || errors.Is(err, oidc.ErrAccountExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+
+		res, err := loginResult(r, db, userID)
+		if errors.Is(err, auth.ErrAccountSuspended) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, "token error", http.StatusInternalServerError)
+			return
+		}
+		if target := os.Getenv("OIDC_POST_LOGIN_REDIRECT"); target != "" {
+			frag := url.Values{}
+			for k, v := range res {
+				frag.Set(k, fmt.Sprint(v))
+			}
+			http.Redirect(w, r, target+"#"+frag.Encode(), http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+func setOIDCStateCookie(w http.ResponseWriter, r *http.Request, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/api/v1/auth/oidc/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func stateHash(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
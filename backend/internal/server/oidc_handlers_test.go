@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	// the state check comes before the database or the provider are used
+	h := OIDCCallbackHandler(nil, nil)
+	cases := []struct {
+		name   string
+		cookie *http.Cookie
+	}{
+		{"no cookie", nil},
+		{"other login", &http.Cookie{Name: oidcStateCookie, Value: stateHash("attacker-state")}},
+		{"raw state", &http.Cookie{Name: oidcStateCookie, Value: "victim-state"}},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/api/v1/auth/oidc/callback?state=victim-state&code=abc", nil)
+		if c.cookie != nil {
+			r.AddCookie(c.cookie)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", c.name, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestOIDCStateCookie(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/v1/auth/oidc/login", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+	setOIDCStateCookie(w, r, stateHash("s"), 600)
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies", len(cookies))
+	}
+	c := cookies[0]
+	if c.Name != oidcStateCookie || c.Value != stateHash("s") || c.MaxAge != 600 {
+		t.Errorf("cookie = %+v", c)
+	}
+	if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookie attributes: HttpOnly=%v Secure=%v SameSite=%v", c.HttpOnly, c.Secure, c.SameSite)
+	}
+}
@@ -1,27 +1,21 @@
 package server
 
 import (
-	"os"
-	"strconv"
+	"database/sql"
+	"errors"
+	"fmt"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/rishit911/file_vault_proj-backend/internal/authz"
+	"github.com/rishit911/file_vault_proj-backend/internal/orgs"
+	"github.com/rishit911/file_vault_proj-backend/internal/users"
 )
 
-func CheckStorageQuota(db *sqlx.DB, userID string, incomingBytes int64) (bool, int64, error) {
-	// default quota from env (bytes)
-	quotaStr := os.Getenv("STORAGE_QUOTA_BYTES")
-	if quotaStr == "" {
-		quotaStr = "10485760" // 10MB
-	}
-	quota, _ := strconv.ParseInt(quotaStr, 10, 64)
-
-	var used int64
-	// calculate deduplicated usage: sum of size_bytes for file_objects referenced by the user (unique file_objects)
-	err := db.Get(&used, `
-		SELECT COALESCE(SUM(fo.size_bytes),0) 
-		FROM file_objects fo
-		JOIN user_files uf ON uf.file_object_id = fo.id
-		WHERE uf.user_id = $1`, userID)
+// CheckStorageQuota reports whether incomingBytes more fit in the quota of
+// the user's own files, or of orgID's files when it is set, and how much is
+// used now.
+func CheckStorageQuota(db *sqlx.DB, userID string, orgID *string, incomingBytes int64) (bool, int64, error) {
+	used, quota, err := StorageUsage(db, userID, orgID)
 	if err != nil {
 		return false, 0, err
 	}
@@ -32,3 +26,56 @@ func CheckStorageQuota(db *sqlx.DB, userID string, incomingBytes int64) (bool, i
 
 	return true, used, nil
 }
+
+// CheckRegistrationQuota checks a registration of hash by its size against
+// the quota of the upload target, like uploads are checked. Content the
+// server already has counts with its stored size, anything else with the
+// declared one. It returns a *QuotaExceededError if it does not fit.
+func CheckRegistrationQuota(db *sqlx.DB, target *authz.Access, hash string, declaredSize int64) error {
+	size := declaredSize
+	err := db.Get(&size, "SELECT size_bytes FROM file_objects WHERE hash = $1", hash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("quota check failed: %w", err)
+	}
+	ok, used, err := CheckStorageQuota(db, target.OwnerID, target.OrgID, size)
+	if err != nil {
+		return fmt.Errorf("quota check failed: %w", err)
+	}
+	if !ok {
+		return &QuotaExceededError{Used: used}
+	}
+	return nil
+}
+
+// StorageUsage returns the bytes used and the quota of the user's own files,
+// or of orgID's files when it is set. Each user and each organization has
+// its own quota, set by an admin, or STORAGE_QUOTA_BYTES and ORG_QUOTA_BYTES
+// respectively; an org's quota is separate from its members' quotas.
+func StorageUsage(db *sqlx.DB, userID string, orgID *string) (used, quota int64, err error) {
+	if orgID != nil {
+		err = db.Get(&quota, "SELECT COALESCE(quota_bytes, $2) FROM organizations WHERE id = $1", *orgID, orgs.DefaultQuota())
+	} else {
+		err = db.Get(&quota, "SELECT COALESCE(quota_bytes, $2) FROM users WHERE id = $1", userID, users.DefaultQuota())
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// calculate usage: sum of size_bytes for file_objects referenced by the
+	// user's (or org's) files and their earlier versions
+	err = db.Get(&used, `
+		SELECT COALESCE(SUM(fo.size_bytes),0)
+		FROM file_objects fo
+		JOIN (
+			SELECT file_object_id FROM user_files uf
+			WHERE CASE WHEN $2::uuid IS NULL THEN uf.user_id = $1 AND uf.org_id IS NULL ELSE uf.org_id = $2 END
+			UNION ALL
+			SELECT fv.file_object_id FROM file_versions fv
+			JOIN user_files uf ON uf.id = fv.user_file_id
+			WHERE CASE WHEN $2::uuid IS NULL THEN uf.user_id = $1 AND uf.org_id IS NULL ELSE uf.org_id = $2 END
+		) refs ON refs.file_object_id = fo.id`, userID, orgID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return used, quota, nil
+}
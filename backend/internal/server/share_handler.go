@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+)
+
+// ShareURL returns the public URL of a share link. PUBLIC_BASE_URL (e.g.
+// "https://vault.example.com") is prepended when set.
+func ShareURL(token string) string {
+	return strings.TrimSuffix(os.Getenv("PUBLIC_BASE_URL"), "/") + "/s/" + token
+}
+
+// ShareDownloadHandler serves GET/HEAD /s/{token} without authentication.
+// Password-protected links take the password in the X-Share-Password header
+// (or the password query parameter); wrong passwords are throttled per link.
+// Each GET that serves the end of the file counts as one download (see
+// countsAsDownload); HEAD requests do not.
+func ShareDownloadHandler(db *sqlx.DB, blobs storage.BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		share, err := storage.GetShareByToken(r.Context(), db, r.PathValue("token"))
+		if errors.Is(err, storage.ErrShareNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := share.Check(time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+
+		if share.PasswordHash != nil {
+			password := r.Header.Get("X-Share-Password")
+			if password == "" {
+				password = r.URL.Query().Get("password")
+			}
+			if password == "" {
+				http.Error(w, "password required", http.StatusUnauthorized)
+				return
+			}
+			err := auth.CheckSharePassword(r.Context(), db, share.ID, *share.PasswordHash, password)
+			var throttled *auth.ThrottledError
+			switch {
+			case errors.As(err, &throttled):
+				w.Header().Set("Retry-After", strconv.Itoa(int(throttled.RetryAfter.Seconds())))
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			case errors.Is(err, auth.ErrInvalidCredentials):
+				http.Error(w, "password required", http.StatusUnauthorized)
+				return
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// counted only once the content is known to exist, so a missing blob
+		// does not use up a download
+		admit := func(f *downloadRow, size int64) bool {
+			if r.Method != http.MethodGet || !countsAsDownload(r, `"`+f.Hash+`"`, size) {
+				return true
+			}
+			err := storage.CountShareDownload(r.Context(), db, share.ID)
+			switch {
+			case errors.Is(err, storage.ErrShareNotFound):
+				http.Error(w, "not found", http.StatusNotFound)
+				return false
+			case errors.Is(err, storage.ErrShareExpired), errors.Is(err, storage.ErrShareExhausted):
+				http.Error(w, err.Error(), http.StatusGone)
+				return false
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return false
+			}
+			return true
+		}
+		serveDownload(w, r, dbDownloads{db}, blobs, share.UserFileID, storage.DownloadEvent{ShareID: &share.ID, Source: storage.DownloadSourceShare}, admit)
+	}
+}
+
+// countsAsDownload reports whether a GET of a file of the given size and
+// ETag is counted against the link's download limit: every response that
+// serves the last byte is, so a file fetched in parts is counted once and
+// no range (bytes=1-, bytes=-N) gets the whole file for free. Conditional
+// requests answered with 304 are not counted; anything unexpected is.
+func countsAsDownload(r *http.Request, etag string, size int64) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" && (inm == etag || inm == "*") {
+		return false
+	}
+	spec, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !ok {
+		// no range, or one http.ServeContent ignores: the whole file
+		return true
+	}
+	if ir := r.Header.Get("If-Range"); ir != "" && ir != etag {
+		// a stale If-Range gets the whole file
+		return true
+	}
+	for _, rng := range strings.Split(spec, ",") {
+		start, end, ok := strings.Cut(strings.TrimSpace(rng), "-")
+		if !ok {
+			return true
+		}
+		if start == "" || end == "" {
+			// a suffix (bytes=-N) or open-ended (bytes=N-) range
+			return true
+		}
+		n, err := strconv.ParseInt(end, 10, 64)
+		if err != nil || n >= size-1 {
+			return true
+		}
+	}
+	return false
+}
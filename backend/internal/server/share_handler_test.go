@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+func TestCountsAsDownload(t *testing.T) {
+	const etag = `"abc"`
+	cases := []struct {
+		header map[string]string
+		want   bool
+	}{
+		{nil, true},
+		{map[string]string{"Range": "bytes=0-"}, true},
+		{map[string]string{"Range": "bytes=1-"}, true},
+		{map[string]string{"Range": "bytes=-10"}, true},
+		{map[string]string{"Range": "bytes=-1"}, true},
+		{map[string]string{"Range": "bytes=90-99"}, true},
+		{map[string]string{"Range": "bytes=90-500"}, true},
+		{map[string]string{"Range": "bytes=0-9,95-"}, true},
+		{map[string]string{"Range": "bytes=0-9"}, false},
+		{map[string]string{"Range": "bytes=10-98"}, false},
+		{map[string]string{"Range": "bytes=0-9", "If-Range": `"stale"`}, true},
+		{map[string]string{"Range": "bytes=0-9", "If-Range": etag}, false},
+		{map[string]string{"Range": "items=0-9"}, true},
+		{map[string]string{"If-None-Match": etag}, false},
+		{map[string]string{"If-None-Match": `"stale"`}, true},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/s/token", nil)
+		for k, v := range c.header {
+			r.Header.Set(k, v)
+		}
+		if got := countsAsDownload(r, etag, 100); got != c.want {
+			t.Errorf("countsAsDownload(%v) = %v, want %v", c.header, got, c.want)
+		}
+	}
+}
+
+func TestShareDownloadRangesCount(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	blobs, err := storage.NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userID string
+	err = db.Get(&userID, "INSERT INTO users (email, password_hash) VALUES ('share-' || gen_random_uuid() || '@example.com', 'x') RETURNING id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged, err := blobs.Put(ctx, strings.NewReader("shared content "+userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer staged.Discard()
+	file, err := storage.AttachContent(ctx, db, storage.AttachInput{
+		UserID: userID, Filename: "shared.txt", Hash: staged.Hash(), Blob: staged,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	two := 2
+	share, err := storage.CreateShare(ctx, db, userID, storage.ShareInput{UserFileID: file.UserFileID, MaxDownloads: &two})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /s/{token}", ShareDownloadHandler(db, blobs))
+	get := func(rng string) int {
+		req := httptest.NewRequest(http.MethodGet, "/s/"+share.Token, nil)
+		req.Header.Set("Range", rng)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := get("bytes=0-3"); code != http.StatusPartialContent {
+		t.Fatalf("leading range: %d", code)
+	}
+	if code := get("bytes=1-"); code != http.StatusPartialContent {
+		t.Fatalf("bytes=1-: %d", code)
+	}
+	if code := get("bytes=-5"); code != http.StatusPartialContent {
+		t.Fatalf("bytes=-5: %d", code)
+	}
+	// both ranges that reached the end were counted
+	if code := get("bytes=-5"); code != http.StatusGone {
+		t.Errorf("past max_downloads: %d, want 410", code)
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+)
+
+type trashListItem struct {
+	UserFileID   string `db:"user_file_id" json:"user_file_id"`
+	FileObjectID string `db:"file_object_id" json:"file_object_id"`
+	Filename     string `db:"filename" json:"filename"`
+	SizeBytes    int64  `db:"size_bytes" json:"size_bytes"`
+	MimeType     string `db:"mime_type" json:"mime_type"`
+	UploadedAt   string `db:"uploaded_at" json:"uploaded_at"`
+	DeletedAt    string `db:"deleted_at" json:"deleted_at"`
+}
+
+// ListTrashHandler lists the authenticated user's trashed files, most
+// recently deleted first.
+func ListTrashHandler(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := GetUserIDFromContext(r)
+		if userID == "" {
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+
+		items := []trashListItem{}
+		query := `
+SELECT
+    uf.id AS user_file_id,
+    fo.id AS file_object_id,
+    uf.filename,
+    fo.size_bytes,
+    COALESCE(fo.mime_type, '') AS mime_type,
+    uf.uploaded_at,
+    uf.deleted_at
+FROM user_files uf
+JOIN file_objects fo ON uf.file_object_id = fo.id
+WHERE uf.user_id = $1 AND uf.deleted_at IS NOT NULL
+ORDER BY uf.deleted_at DESC`
+
+		if err := db.Select(&items, query, userID); err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}
+}
+
+// RestoreFileHandler takes a file out of the trash.
+func RestoreFileHandler(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := trashedFileOwnedBy(w, r, db)
+		if !ok {
+			return
+		}
+
+		err := storage.RestoreUserFile(r.Context(), db, id)
+		if errors.Is(err, storage.ErrUserFileNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// PurgeFileHandler permanently deletes a trashed file.
+func PurgeFileHandler(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := trashedFileOwnedBy(w, r, db)
+		if !ok {
+			return
+		}
+
+		_, err := storage.PurgeUserFile(r.Context(), db, id)
+		if errors.Is(err, storage.ErrUserFileNotFound) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// EmptyTrashHandler permanently deletes all of the user's trashed files.
+func EmptyTrashHandler(db *sqlx.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := GetUserIDFromContext(r)
+		if userID == "" {
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+
+		n, err := storage.EmptyTrash(r.Context(), db, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"purged": n})
+	}
+}
+
+// trashedFileOwnedBy resolves the {id} path value to a trashed file owned by
+// the caller, writing the error response if there is none.
+func trashedFileOwnedBy(w http.ResponseWriter, r *http.Request, db *sqlx.DB) (string, bool) {
+	id := r.PathValue("id")
+	userID := GetUserIDFromContext(r)
+	if userID == "" {
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return "", false
+	}
+
+	var ownerID string
+	err := db.Get(&ownerID, "SELECT user_id FROM user_files WHERE id=$1 AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return "", false
+	}
+	if ownerID != userID {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return "", false
+	}
+	return id, true
+}
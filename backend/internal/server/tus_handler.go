@@ -0,0 +1,511 @@
+package server
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+)
+
+// tus 1.0 resumable uploads: https://tus.io/protocols/resumable-upload
+const (
+	tusVersion            = "1.0.0"
+	tusExtensions         = "creation,creation-with-upload,termination,checksum,expiration"
+	tusChecksumAlgorithms = "md5,sha1,sha256"
+	tusBasePath           = "/api/v1/uploads/"
+	tusContentType        = "application/offset+octet-stream"
+
+	// tusUploadTTL is how long an unfinished upload survives without a PATCH.
+	tusUploadTTL = 24 * time.Hour
+
+	// StatusChecksumMismatch is defined by the tus checksum extension.
+	StatusChecksumMismatch = 460
+)
+
+type tusUpload struct {
+	ID           string         `db:"id"`
+	UserID       string         `db:"user_id"`
+	Filename     string         `db:"filename"`
+	MimeType     string         `db:"mime_type"`
+	UploadLength int64          `db:"upload_length"`
+	UploadOffset int64          `db:"upload_offset"`
+	Metadata     string         `db:"metadata"`
+	UserFileID   sql.NullString `db:"user_file_id"`
+	ExpiresAt    time.Time      `db:"expires_at"`
+}
+
+type tusService struct {
+	db      *sqlx.DB
+	blobs   storage.BlobStore
+	maxSize int64
+}
+
+// tusLocks serialises PATCH requests per upload id. Entries are only made
+// for uploads that exist and are dropped when the upload is finished or
+// removed, including by PurgeExpiredUploads.
+var tusLocks sync.Map
+
+func tusMaxSize() int64 {
+	if v := os.Getenv("TUS_MAX_SIZE_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 5 << 30 // 5GB
+}
+
+func setTusDiscoveryHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Checksum-Algorithm", tusChecksumAlgorithms)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(tusMaxSize(), 10))
+}
+
+// TusOptionsHandler answers tus discovery requests. It needs no
+// authentication so clients can probe capabilities up front.
+func TusOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	setTusDiscoveryHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TusHandler implements the tus core protocol plus the creation,
+// termination, checksum and expiration extensions under /api/v1/uploads/.
+// Partial data lives in the blob store's tmp area; a finished upload is fed
+// through IngestFile exactly like a multipart upload.
+func TusHandler(db *sqlx.DB, blobs storage.BlobStore) http.HandlerFunc {
+	s := &tusService{db: db, blobs: blobs, maxSize: tusMaxSize()}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+
+		if r.Method == http.MethodOptions {
+			TusOptionsHandler(w, r)
+			return
+		}
+		if r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+			return
+		}
+
+		userID := GetUserIDFromContext(r)
+		if userID == "" {
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, tusBasePath), "/")
+		switch {
+		case id == "" && r.Method == http.MethodPost:
+			s.create(w, r, userID)
+		case id != "" && r.Method == http.MethodHead:
+			s.head(w, r, userID, id)
+		case id != "" && r.Method == http.MethodPatch:
+			s.patch(w, r, userID, id)
+		case id != "" && r.Method == http.MethodDelete:
+			s.terminate(w, r, userID, id)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func (s *tusService) partialPath(id string) string {
+	return filepath.Join(s.blobs.TmpDir(), "tus-"+id)
+}
+
+func (s *tusService) create(w http.ResponseWriter, r *http.Request, userID string) {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "Upload-Defer-Length is not supported", http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "valid Upload-Length required", http.StatusBadRequest)
+		return
+	}
+	if length > s.maxSize {
+		http.Error(w, "upload exceeds Tus-Max-Size", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	rawMeta := r.Header.Get("Upload-Metadata")
+	meta, err := parseTusMetadata(rawMeta)
+	if err != nil {
+		http.Error(w, "invalid Upload-Metadata: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	filename := firstNonEmpty(meta["filename"], meta["name"])
+	if filename == "" {
+		http.Error(w, "filename metadata required", http.StatusBadRequest)
+		return
+	}
+	mimeType := firstNonEmpty(meta["filetype"], meta["type"])
+
+	// fail fast; the quota is checked again when the upload completes
+	ok, used, err := CheckStorageQuota(s.db, userID, nil, length)
+	if err != nil {
+		http.Error(w, "quota check failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, (&QuotaExceededError{Used: used}).Error(), http.StatusForbidden)
+		return
+	}
+
+	u := tusUpload{
+		ID:           uuid.New().String(),
+		UserID:       userID,
+		Filename:     filename,
+		MimeType:     mimeType,
+		UploadLength: length,
+		Metadata:     rawMeta,
+		ExpiresAt:    time.Now().Add(tusUploadTTL),
+	}
+
+	f, err := os.OpenFile(s.partialPath(u.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		http.Error(w, "tmp create: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+
+	_, err = s.db.NamedExec(`
+INSERT INTO tus_uploads (id, user_id, filename, mime_type, upload_length, upload_offset, metadata, expires_at)
+VALUES (:id, :user_id, :filename, :mime_type, :upload_length, 0, :metadata, :expires_at)`, &u)
+	if err != nil {
+		os.Remove(s.partialPath(u.ID))
+		http.Error(w, "create upload failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", tusBasePath+u.ID)
+
+	// creation-with-upload: the POST body carries the first chunk
+	if r.Header.Get("Content-Type") == tusContentType && r.ContentLength != 0 {
+		s.appendAndRespond(w, r, &u, http.StatusCreated)
+		return
+	}
+
+	if length == 0 {
+		s.finishAndRespond(w, r, &u, http.StatusCreated)
+		return
+	}
+
+	w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *tusService) load(w http.ResponseWriter, userID, id string) (*tusUpload, bool) {
+	var u tusUpload
+	err := s.db.Get(&u, `
+SELECT id, user_id, filename, mime_type, upload_length, upload_offset, metadata, user_file_id, expires_at
+FROM tus_uploads WHERE id = $1`, id)
+	if err != nil {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return nil, false
+	}
+	if u.UserID != userID {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	if !u.UserFileID.Valid && time.Now().After(u.ExpiresAt) {
+		s.remove(context.Background(), u.ID)
+		http.Error(w, "upload expired", http.StatusGone)
+		return nil, false
+	}
+	return &u, true
+}
+
+func (s *tusService) head(w http.ResponseWriter, r *http.Request, userID, id string) {
+	u, ok := s.load(w, userID, id)
+	if !ok {
+		return
+	}
+
+	h := w.Header()
+	h.Set("Cache-Control", "no-store")
+	h.Set("Upload-Offset", strconv.FormatInt(u.UploadOffset, 10))
+	h.Set("Upload-Length", strconv.FormatInt(u.UploadLength, 10))
+	if u.Metadata != "" {
+		h.Set("Upload-Metadata", u.Metadata)
+	}
+	if u.UserFileID.Valid {
+		h.Set("X-User-File-Id", u.UserFileID.String)
+	} else {
+		h.Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *tusService) patch(w http.ResponseWriter, r *http.Request, userID, id string) {
+	if r.Header.Get("Content-Type") != tusContentType {
+		http.Error(w, "Content-Type must be "+tusContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "valid Upload-Offset required", http.StatusBadRequest)
+		return
+	}
+
+	// only uploads the caller may write get a lock; then load again, as
+	// another PATCH may have moved the offset meanwhile
+	if _, ok := s.load(w, userID, id); !ok {
+		return
+	}
+	mu, _ := tusLocks.LoadOrStore(id, &sync.Mutex{})
+	if !mu.(*sync.Mutex).TryLock() {
+		http.Error(w, "upload is locked by another request", http.StatusLocked)
+		return
+	}
+	defer mu.(*sync.Mutex).Unlock()
+
+	u, ok := s.load(w, userID, id)
+	if !ok {
+		return
+	}
+	if offset != u.UploadOffset {
+		http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+
+	if u.UploadOffset == u.UploadLength {
+		if u.UserFileID.Valid {
+			// already complete; report it again so a client that lost the last response can move on
+			w.Header().Set("Upload-Offset", strconv.FormatInt(u.UploadOffset, 10))
+			w.Header().Set("X-User-File-Id", u.UserFileID.String)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		// all bytes are in but ingest failed earlier (e.g. quota); retry it
+		s.finishAndRespond(w, r, u, http.StatusNoContent)
+		return
+	}
+
+	s.appendAndRespond(w, r, u, http.StatusNoContent)
+}
+
+// appendAndRespond writes the request body at the upload's current offset,
+// then either finishes the upload or reports the new offset.
+func (s *tusService) appendAndRespond(w http.ResponseWriter, r *http.Request, u *tusUpload, status int) {
+	var checksum hash.Hash
+	var want []byte
+	if v := r.Header.Get("Upload-Checksum"); v != "" {
+		var err error
+		checksum, want, err = parseUploadChecksum(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	f, err := os.OpenFile(s.partialPath(u.ID), os.O_WRONLY, 0)
+	if err != nil {
+		http.Error(w, "open partial upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Seek(u.UploadOffset, io.SeekStart); err != nil {
+		http.Error(w, "seek partial upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	remaining := u.UploadLength - u.UploadOffset
+	dst := io.Writer(f)
+	if checksum != nil {
+		dst = io.MultiWriter(f, checksum)
+	}
+	// read one byte past the declared length to detect oversized bodies
+	n, copyErr := io.Copy(dst, io.LimitReader(newDeadlineReader(w, r.Body), remaining+1))
+
+	rollback := func() { f.Truncate(u.UploadOffset) }
+	switch {
+	case n > remaining:
+		rollback()
+		http.Error(w, "body exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	case checksum != nil && copyErr == nil && string(checksum.Sum(nil)) != string(want):
+		rollback()
+		http.Error(w, "checksum mismatch", StatusChecksumMismatch)
+		return
+	case checksum != nil && copyErr != nil:
+		// a partial chunk can't be verified, so it can't be kept
+		rollback()
+		http.Error(w, "read body: "+copyErr.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// without a checksum, keep whatever arrived so the client can resume from it
+	if err := f.Sync(); err != nil {
+		rollback()
+		http.Error(w, "sync partial upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	u.UploadOffset += n
+	u.ExpiresAt = time.Now().Add(tusUploadTTL)
+	if _, err := s.db.Exec(`UPDATE tus_uploads SET upload_offset = $1, expires_at = $2 WHERE id = $3`,
+		u.UploadOffset, u.ExpiresAt, u.ID); err != nil {
+		rollback()
+		http.Error(w, "update upload failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if copyErr != nil {
+		http.Error(w, "read body: "+copyErr.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if u.UploadOffset == u.UploadLength {
+		s.finishAndRespond(w, r, u, status)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.UploadOffset, 10))
+	w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(status)
+}
+
+// finishAndRespond runs a complete upload through IngestFile. If ingest
+// fails the partial data is kept, so a zero-length PATCH can retry it.
+func (s *tusService) finishAndRespond(w http.ResponseWriter, r *http.Request, u *tusUpload, status int) {
+	f, err := os.Open(s.partialPath(u.ID))
+	if err != nil {
+		http.Error(w, "open partial upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res, err := IngestFile(r.Context(), s.db, s.blobs, IngestInput{
+		UserID:       u.UserID,
+		Filename:     u.Filename,
+		DeclaredMime: u.MimeType,
+		Content:      f,
+	})
+	f.Close()
+	if err != nil {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.UploadOffset, 10))
+		http.Error(w, err.Error(), ingestErrorStatus(err))
+		return
+	}
+
+	if _, err := s.db.Exec(`UPDATE tus_uploads SET user_file_id = $1, completed_at = now() WHERE id = $2`,
+		res.UserFileID, u.ID); err != nil {
+		log.Printf("tus: mark upload %s complete: %v", u.ID, err)
+	}
+	os.Remove(s.partialPath(u.ID))
+	tusLocks.Delete(u.ID)
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.UploadOffset, 10))
+	w.Header().Set("X-User-File-Id", res.UserFileID)
+	w.WriteHeader(status)
+}
+
+func (s *tusService) terminate(w http.ResponseWriter, r *http.Request, userID, id string) {
+	if _, ok := s.load(w, userID, id); !ok {
+		return
+	}
+	if err := s.remove(r.Context(), id); err != nil {
+		http.Error(w, "terminate failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *tusService) remove(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM tus_uploads WHERE id = $1`, id); err != nil {
+		return err
+	}
+	tusLocks.Delete(id)
+	if err := os.Remove(s.partialPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// PurgeExpiredUploads deletes tus uploads past their expiry together with
+// their partial data, and forgets completed uploads once they expire.
+func PurgeExpiredUploads(ctx context.Context, db *sqlx.DB, blobs storage.BlobStore) (int, error) {
+	var ids []string
+	if err := db.SelectContext(ctx, &ids, `SELECT id FROM tus_uploads WHERE expires_at < now()`); err != nil {
+		return 0, err
+	}
+	s := &tusService{db: db, blobs: blobs}
+	for _, id := range ids {
+		if err := s.remove(ctx, id); err != nil {
+			return 0, fmt.Errorf("purge upload %s: %w", id, err)
+		}
+	}
+	return len(ids), nil
+}
+
+// parseTusMetadata decodes "key base64value,key2 base64value2".
+func parseTusMetadata(header string) (map[string]string, error) {
+	meta := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return meta, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty key")
+		}
+		if _, dup := meta[key]; dup {
+			return nil, fmt.Errorf("duplicate key %q", key)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", key, err)
+		}
+		meta[key] = string(decoded)
+	}
+	return meta, nil
+}
+
+// parseUploadChecksum decodes "<algorithm> <base64 digest>".
+func parseUploadChecksum(header string) (hash.Hash, []byte, error) {
+	algo, encoded, ok := strings.Cut(header, " ")
+	if !ok {
+		return nil, nil, errors.New("invalid Upload-Checksum")
+	}
+	want, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, errors.New("invalid Upload-Checksum digest")
+	}
+	switch algo {
+	case "sha256":
+		return sha256.New(), want, nil
+	case "sha1":
+		return sha1.New(), want, nil
+	case "md5":
+		return md5.New(), want, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
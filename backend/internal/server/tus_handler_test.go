@@ -0,0 +1,152 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+func TestParseTusMetadata(t *testing.T) {
+	header := "filename " + base64.StdEncoding.EncodeToString([]byte("report 2024.pdf")) +
+		",filetype " + base64.StdEncoding.EncodeToString([]byte("application/pdf")) +
+		",is_confidential"
+
+	meta, err := parseTusMetadata(header)
+	if err != nil {
+		t.Fatalf("parseTusMetadata failed: %v", err)
+	}
+	if meta["filename"] != "report 2024.pdf" {
+		t.Errorf("Expected filename 'report 2024.pdf', got %q", meta["filename"])
+	}
+	if meta["filetype"] != "application/pdf" {
+		t.Errorf("Expected filetype application/pdf, got %q", meta["filetype"])
+	}
+	if v, ok := meta["is_confidential"]; !ok || v != "" {
+		t.Errorf("Expected empty value for key without value, got %q (present=%v)", v, ok)
+	}
+
+	if _, err := parseTusMetadata("filename !!notbase64"); err == nil {
+		t.Error("parseTusMetadata should reject invalid base64")
+	}
+	if _, err := parseTusMetadata("a YQ==,a Yg=="); err == nil {
+		t.Error("parseTusMetadata should reject duplicate keys")
+	}
+}
+
+func TestParseUploadChecksum(t *testing.T) {
+	sum := sha256.Sum256([]byte("chunk"))
+	h, want, err := parseUploadChecksum("sha256 " + base64.StdEncoding.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatalf("parseUploadChecksum failed: %v", err)
+	}
+	h.Write([]byte("chunk"))
+	if string(h.Sum(nil)) != string(want) {
+		t.Error("Expected digest of chunk to match the header")
+	}
+
+	if _, _, err := parseUploadChecksum("crc32 AAAA"); err == nil {
+		t.Error("parseUploadChecksum should reject unsupported algorithms")
+	}
+	if _, _, err := parseUploadChecksum("sha256"); err == nil {
+		t.Error("parseUploadChecksum should reject a missing digest")
+	}
+}
+
+func TestTusHandler(t *testing.T) {
+	db := testdb.Open(t)
+	blobs, err := storage.NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var userID string
+	err = db.Get(&userID, "INSERT INTO users (email, password_hash) VALUES ('tus-' || gen_random_uuid() || '@example.com', 'x') RETURNING id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := TusHandler(db, blobs)
+	do := func(method, path string, header map[string]string, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req = req.WithContext(auth.WithIdentity(req.Context(), &auth.Identity{UserID: userID, Role: auth.RoleUser}))
+		req.Header.Set("Tus-Resumable", tusVersion)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+	patch := func(path string, offset int, body string, extra map[string]string) *httptest.ResponseRecorder {
+		header := map[string]string{"Content-Type": tusContentType, "Upload-Offset": strconv.Itoa(offset)}
+		for k, v := range extra {
+			header[k] = v
+		}
+		return do(http.MethodPatch, path, header, body)
+	}
+
+	rec := do(http.MethodPost, tusBasePath, map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("notes.txt")),
+	}, "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body)
+	}
+	loc := rec.Header().Get("Location")
+	if !strings.HasPrefix(loc, tusBasePath) {
+		t.Fatalf("Location = %q", loc)
+	}
+
+	if rec := patch(loc, 0, "hello", nil); rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("first chunk: %d offset %q %s", rec.Code, rec.Header().Get("Upload-Offset"), rec.Body)
+	}
+	rec = do(http.MethodHead, loc, nil, "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != "5" || rec.Header().Get("Upload-Length") != "10" {
+		t.Errorf("HEAD: %d offset %q length %q", rec.Code, rec.Header().Get("Upload-Offset"), rec.Header().Get("Upload-Length"))
+	}
+
+	if rec := patch(loc, 3, "lo wo", nil); rec.Code != http.StatusConflict {
+		t.Errorf("wrong Upload-Offset: %d", rec.Code)
+	}
+	sum := sha256.Sum256([]byte("not this"))
+	checksum := map[string]string{"Upload-Checksum": "sha256 " + base64.StdEncoding.EncodeToString(sum[:])}
+	if rec := patch(loc, 5, "world", checksum); rec.Code != StatusChecksumMismatch {
+		t.Errorf("checksum mismatch: %d", rec.Code)
+	}
+	// the rejected chunk was not kept
+	if rec := do(http.MethodHead, loc, nil, ""); rec.Header().Get("Upload-Offset") != "5" {
+		t.Errorf("offset after checksum mismatch = %q, want 5", rec.Header().Get("Upload-Offset"))
+	}
+
+	if rec := do(http.MethodDelete, loc, nil, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("terminate: %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodHead, loc, nil, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("HEAD after termination: %d", rec.Code)
+	}
+	id := strings.TrimPrefix(loc, tusBasePath)
+	if _, err := os.Stat(filepath.Join(blobs.TmpDir(), "tus-"+id)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("partial data kept after termination: %v", err)
+	}
+
+	// PATCHes of unknown uploads do not take locks
+	if rec := patch(tusBasePath+"00000000-0000-0000-0000-000000000000", 0, "x", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown upload: %d", rec.Code)
+	}
+	tusLocks.Range(func(k, _ any) bool {
+		if k == id || k == "00000000-0000-0000-0000-000000000000" {
+			t.Errorf("lock for %v left behind", k)
+		}
+		return true
+	})
+}
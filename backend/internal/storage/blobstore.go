@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrBlobNotFound is returned when no blob is stored under a key.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobInfo describes a stored blob.
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// StagedBlob is content that has been streamed and hashed but is not yet
+// visible under its key. Exactly one of Commit or Discard must be called.
+type StagedBlob interface {
+	Hash() string
+	Size() int64
+	// Key is the backend-relative key the blob is stored under once committed.
+	Key() string
+	// Commit makes the blob readable under Key. Committing content whose key
+	// already exists keeps the existing blob and drops the staged copy.
+	Commit(ctx context.Context) error
+	Discard() error
+}
+
+// BlobStore is a content-addressed blob backend. Keys are relative to the
+// backend (see BlobKey) so the storage root can move without touching the
+// file_objects rows that reference them.
+type BlobStore interface {
+	// Put streams r into a staging area while computing its SHA-256.
+	Put(ctx context.Context, r io.Reader) (StagedBlob, error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// OpenRange reads length bytes starting at offset. A negative length
+	// reads to the end of the blob.
+	OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (BlobInfo, error)
+	Delete(ctx context.Context, key string) error
+	// List calls fn for every committed blob. Returning an error from fn
+	// stops the listing and is returned by List.
+	List(ctx context.Context, fn func(BlobInfo) error) error
+	// TmpDir is a local directory for partial uploads and staging. Files in
+	// it are never visible as blobs.
+	TmpDir() string
+}
+
+// ValidHash reports whether h is a lowercase hex-encoded SHA-256 digest.
+func ValidHash(h string) bool {
+	if len(h) != 64 {
+		return false
+	}
+	b, err := hex.DecodeString(h)
+	return err == nil && len(b) == 32 && hex.EncodeToString(b) == h
+}
+
+// BlobKey returns the key for content with the given SHA-256 hash, sharded by
+// the first two hex characters: "ab/abcdef...".
+func BlobKey(hash string) string {
+	if len(hash) < 2 {
+		return hash
+	}
+	return hash[:2] + "/" + hash
+}
+
+// NewBlobStoreFromEnv builds the blob backend selected by STORAGE_BACKEND.
+func NewBlobStoreFromEnv() (BlobStore, error) {
+	switch backend := getEnv("STORAGE_BACKEND", "local"); backend {
+	case "local":
+		return NewLocalBlobStore(getEnv("STORAGE_PATH", "/data/files"))
+	case "s3":
+		return newS3BlobStoreFromEnv()
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
+
+func getEnv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
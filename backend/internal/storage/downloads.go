@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Download sources recorded in downloads.source.
+const (
+	DownloadSourceOwner = "owner"
+	// DownloadSourceGrant is a user the file is shared with (see authz).
+	DownloadSourceGrant = "grant"
+	DownloadSourceShare = "share"
+	DownloadSourceAdmin = "admin"
+)
+
+// DownloadEvent is one row of the download audit trail.
+type DownloadEvent struct {
+	ID         string `db:"id"`
+	UserFileID string `db:"user_file_id"`
+	// UserID is the downloader; nil for anonymous share link downloads.
+	UserID    *string `db:"user_id"`
+	ShareID   *string `db:"share_id"`
+	Source    string  `db:"source"`
+	IP        *string `db:"downloader_ip"`
+	UserAgent *string `db:"user_agent"`
+	// RangeStart and RangeEnd are the inclusive byte range served.
+	RangeStart   *int64    `db:"range_start"`
+	RangeEnd     *int64    `db:"range_end"`
+	BytesSent    int64     `db:"bytes_sent"`
+	Completed    bool      `db:"completed"`
+	DownloadedAt time.Time `db:"downloaded_at"`
+	// Filename and OwnerID describe the file at query time.
+	Filename string `db:"filename"`
+	OwnerID  string `db:"owner_id"`
+}
+
+// RecordDownload appends an event to the audit trail.
+func RecordDownload(ctx context.Context, db *sqlx.DB, ev *DownloadEvent) error {
+	_, err := db.NamedExecContext(ctx, `
+INSERT INTO downloads (user_file_id, user_id, share_id, source, downloader_ip, user_agent,
+	range_start, range_end, bytes_sent, completed)
+VALUES (:user_file_id, :user_id, :share_id, :source, :downloader_ip, :user_agent,
+	:range_start, :range_end, :bytes_sent, :completed)`, ev)
+	if err != nil {
+		return fmt.Errorf("record download: %w", err)
+	}
+	return nil
+}
+
+// DownloadFilter narrows ListDownloads; zero fields match everything.
+type DownloadFilter struct {
+	UserFileID *string
+	// UserID is the downloader, OwnerID the owner of the file.
+	UserID    *string
+	OwnerID   *string
+	Source    *string
+	From      *time.Time
+	To        *time.Time
+	Completed *bool
+}
+
+func (f *DownloadFilter) where(args *[]any) string {
+	parts := []string{"true"}
+	add := func(cond string, v any) {
+		*args = append(*args, v)
+		parts = append(parts, fmt.Sprintf(cond, len(*args)))
+	}
+	if f.UserFileID != nil {
+		add("d.user_file_id = $%d", *f.UserFileID)
+	}
+	if f.UserID != nil {
+		add("d.user_id = $%d", *f.UserID)
+	}
+	if f.OwnerID != nil {
+		add("uf.user_id = $%d", *f.OwnerID)
+	}
+	if f.Source != nil {
+		add("d.source = $%d", *f.Source)
+	}
+	if f.From != nil {
+		add("d.downloaded_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		add("d.downloaded_at <= $%d", *f.To)
+	}
+	if f.Completed != nil {
+		add("d.completed = $%d", *f.Completed)
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// ListDownloads returns a page of matching events, newest first, and the
+// total number of matches.
+func ListDownloads(ctx context.Context, db *sqlx.DB, filter DownloadFilter, limit, offset int) ([]DownloadEvent, int, error) {
+	args := []any{}
+	where := filter.where(&args)
+
+	var total int
+	err := db.GetContext(ctx, &total,
+		"SELECT COUNT(*) FROM downloads d JOIN user_files uf ON uf.id = d.user_file_id WHERE "+where, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("count downloads: %w", err)
+	}
+
+	events := []DownloadEvent{}
+	err = db.SelectContext(ctx, &events, fmt.Sprintf(`
+SELECT d.id, d.user_file_id, d.user_id, d.share_id, d.source, d.downloader_ip, d.user_agent,
+	d.range_start, d.range_end, d.bytes_sent, d.completed, d.downloaded_at,
+	uf.filename, uf.user_id AS owner_id
+FROM downloads d JOIN user_files uf ON uf.id = d.user_file_id
+WHERE %s ORDER BY d.downloaded_at DESC LIMIT $%d OFFSET $%d`, where, len(args)+1, len(args)+2),
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list downloads: %w", err)
+	}
+	return events, total, nil
+}
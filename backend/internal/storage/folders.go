@@ -0,0 +1,359 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrFolderNotFound = errors.New("folder not found")
+	ErrNameConflict   = errors.New("an item with that name already exists in the folder")
+	ErrInvalidName    = errors.New("invalid name")
+	ErrFolderCycle    = errors.New("cannot move a folder into itself or one of its subfolders")
+	ErrOrgFolder      = errors.New("organization files cannot be placed in folders")
+)
+
+// Folder is a node in a user's folder tree. A nil ParentID is the root.
+type Folder struct {
+	ID        string    `db:"id"`
+	UserID    string    `db:"user_id"`
+	ParentID  *string   `db:"parent_id"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// ValidName reports whether name can be used for a folder or a renamed file:
+// non-empty, at most 255 bytes, no slashes, and not "." or "..".
+func ValidName(name string) bool {
+	return name != "" && len(name) <= 255 && name != "." && name != ".." &&
+		!strings.ContainsAny(name, "/\x00")
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// lockFolderTree serialises structural changes to one user's folders, so two
+// concurrent moves cannot combine into a cycle.
+func lockFolderTree(ctx context.Context, tx *sqlx.Tx, userID string) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('folders:' || $1))", userID)
+	return err
+}
+
+// checkFolder fails with ErrFolderNotFound unless folderID is nil (the root)
+// or a folder owned by userID.
+func checkFolder(ctx context.Context, q sqlx.QueryerContext, userID string, folderID *string) error {
+	if folderID == nil {
+		return nil
+	}
+	var ok bool
+	err := sqlx.GetContext(ctx, q, &ok, "SELECT EXISTS (SELECT 1 FROM folders WHERE id=$1 AND user_id=$2)", *folderID, userID)
+	if err != nil {
+		return fmt.Errorf("lookup folder: %w", err)
+	}
+	if !ok {
+		return ErrFolderNotFound
+	}
+	return nil
+}
+
+func CreateFolder(ctx context.Context, db *sqlx.DB, userID string, parentID *string, name string) (*Folder, error) {
+	if !ValidName(name) {
+		return nil, ErrInvalidName
+	}
+	if err := checkFolder(ctx, db, userID, parentID); err != nil {
+		return nil, err
+	}
+
+	var f Folder
+	err := db.GetContext(ctx, &f, `
+INSERT INTO folders (user_id, parent_id, name) VALUES ($1, $2, $3)
+RETURNING id, user_id, parent_id, name, created_at`, userID, parentID, name)
+	if isUniqueViolation(err) {
+		return nil, ErrNameConflict
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create folder: %w", err)
+	}
+	return &f, nil
+}
+
+// GetFolder returns a folder owned by userID.
+func GetFolder(ctx context.Context, db *sqlx.DB, userID, folderID string) (*Folder, error) {
+	var f Folder
+	err := db.GetContext(ctx, &f,
+		"SELECT id, user_id, parent_id, name, created_at FROM folders WHERE id=$1 AND user_id=$2", folderID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrFolderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get folder: %w", err)
+	}
+	return &f, nil
+}
+
+// ListFolders returns the direct subfolders of parentID (nil for the root),
+// sorted by name.
+func ListFolders(ctx context.Context, db *sqlx.DB, userID string, parentID *string) ([]Folder, error) {
+	folders := []Folder{}
+	err := db.SelectContext(ctx, &folders, `
+SELECT id, user_id, parent_id, name, created_at FROM folders
+WHERE user_id=$1 AND parent_id IS NOT DISTINCT FROM $2
+ORDER BY name`, userID, parentID)
+	if err != nil {
+		return nil, fmt.Errorf("list folders: %w", err)
+	}
+	return folders, nil
+}
+
+// ResolveFolderPath maps a slash-separated path such as "/reports/2024" to a
+// folder id. "/" and "" are the root, which resolves to nil.
+func ResolveFolderPath(ctx context.Context, db *sqlx.DB, userID, p string) (*string, error) {
+	var current *string
+	for _, name := range strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		var id string
+		err := db.GetContext(ctx, &id,
+			"SELECT id FROM folders WHERE user_id=$1 AND parent_id IS NOT DISTINCT FROM $2 AND name=$3",
+			userID, current, name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrFolderNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("resolve path: %w", err)
+		}
+		current = &id
+	}
+	return current, nil
+}
+
+// FolderPath returns the absolute path of a folder, e.g. "/reports/2024".
+func FolderPath(ctx context.Context, db *sqlx.DB, folderID string) (string, error) {
+	var p string
+	err := db.GetContext(ctx, &p, `
+WITH RECURSIVE up AS (
+	SELECT id, parent_id, name, 0 AS depth FROM folders WHERE id=$1
+	UNION ALL
+	SELECT f.id, f.parent_id, f.name, up.depth + 1 FROM folders f JOIN up ON f.id = up.parent_id
+)
+SELECT COALESCE(string_agg(name, '/' ORDER BY depth DESC), '') FROM up`, folderID)
+	if err != nil {
+		return "", fmt.Errorf("folder path: %w", err)
+	}
+	return "/" + p, nil
+}
+
+func RenameFolder(ctx context.Context, db *sqlx.DB, userID, folderID, name string) error {
+	if !ValidName(name) {
+		return ErrInvalidName
+	}
+	res, err := db.ExecContext(ctx, "UPDATE folders SET name=$3 WHERE id=$1 AND user_id=$2", folderID, userID, name)
+	if isUniqueViolation(err) {
+		return ErrNameConflict
+	}
+	if err != nil {
+		return fmt.Errorf("rename folder: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrFolderNotFound
+	}
+	return nil
+}
+
+// MoveFolder re-parents a folder (nil parentID moves it to the root). Moving a
+// folder below itself fails with ErrFolderCycle.
+func MoveFolder(ctx context.Context, db *sqlx.DB, userID, folderID string, parentID *string) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockFolderTree(ctx, tx, userID); err != nil {
+		return fmt.Errorf("lock folders: %w", err)
+	}
+	if err := checkFolder(ctx, tx, userID, &folderID); err != nil {
+		return err
+	}
+	if err := checkFolder(ctx, tx, userID, parentID); err != nil {
+		return err
+	}
+
+	if parentID != nil {
+		// walk up from the new parent; meeting folderID means a cycle
+		var cycle bool
+		err := tx.GetContext(ctx, &cycle, `
+WITH RECURSIVE up AS (
+	SELECT id, parent_id FROM folders WHERE id=$1
+	UNION ALL
+	SELECT f.id, f.parent_id FROM folders f JOIN up ON f.id = up.parent_id
+)
+SELECT EXISTS (SELECT 1 FROM up WHERE id=$2)`, *parentID, folderID)
+		if err != nil {
+			return fmt.Errorf("check cycle: %w", err)
+		}
+		if cycle {
+			return ErrFolderCycle
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE folders SET parent_id=$2 WHERE id=$1", folderID, parentID)
+	if isUniqueViolation(err) {
+		return ErrNameConflict
+	}
+	if err != nil {
+		return fmt.Errorf("move folder: %w", err)
+	}
+	return tx.Commit()
+}
+
+// DeleteFolder deletes a folder and everything below it. Live files in the
+// subtree are moved to the trash, keeping their references; with purge they
+// are then purged right away, dropping their references the same way
+// PurgeUserFile does. It returns the number of files affected.
+func DeleteFolder(ctx context.Context, db *sqlx.DB, userID, folderID string, purge bool) (int, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := lockFolderTree(ctx, tx, userID); err != nil {
+		return 0, fmt.Errorf("lock folders: %w", err)
+	}
+	if err := checkFolder(ctx, tx, userID, &folderID); err != nil {
+		return 0, err
+	}
+
+	var fileIDs []string
+	err = tx.SelectContext(ctx, &fileIDs, `
+WITH RECURSIVE tree AS (
+	SELECT id FROM folders WHERE id=$1
+	UNION ALL
+	SELECT f.id FROM folders f JOIN tree ON f.parent_id = tree.id
+)
+UPDATE user_files SET deleted_at = now()
+WHERE folder_id IN (SELECT id FROM tree) AND deleted_at IS NULL
+RETURNING id`, folderID)
+	if err != nil {
+		return 0, fmt.Errorf("trash folder contents: %w", err)
+	}
+
+	// subfolders cascade; trashed files fall back to the root (folder_id SET NULL)
+	if _, err := tx.ExecContext(ctx, "DELETE FROM folders WHERE id=$1", folderID); err != nil {
+		return 0, fmt.Errorf("delete folder: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+
+	if purge {
+		if _, err := purgeAll(ctx, db, fileIDs); err != nil {
+			return len(fileIDs), err
+		}
+	}
+	return len(fileIDs), nil
+}
+
+// MoveUserFile moves a live file into folderID (nil for the root). The caller
+// checks that userID owns the file.
+func MoveUserFile(ctx context.Context, db *sqlx.DB, userID, userFileID string, folderID *string) error {
+	if err := checkFolder(ctx, db, userID, folderID); err != nil {
+		return err
+	}
+	return updateUserFile(ctx, db, "UPDATE user_files SET folder_id=$2 WHERE id=$1 AND deleted_at IS NULL", userFileID, folderID)
+}
+
+// RenameUserFile renames a live file within its folder.
+func RenameUserFile(ctx context.Context, db *sqlx.DB, userFileID, filename string) error {
+	if !ValidName(filename) {
+		return ErrInvalidName
+	}
+	return updateUserFile(ctx, db, "UPDATE user_files SET filename=$2 WHERE id=$1 AND deleted_at IS NULL", userFileID, filename)
+}
+
+func updateUserFile(ctx context.Context, db *sqlx.DB, query, userFileID string, arg any) error {
+	res, err := db.ExecContext(ctx, query, userFileID, arg)
+	if isUniqueViolation(err) {
+		return ErrNameConflict
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Constraint == "user_files_org_root" {
+		return ErrOrgFolder
+	}
+	if err != nil {
+		return fmt.Errorf("update user_file failed: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserFileNotFound
+	}
+	return nil
+}
+
+// nameScope is what live filenames are unique within besides the folder: the
+// org for org files and the owner otherwise (see idx_user_files_unique_name).
+func nameScope(userID string, orgID *string) string {
+	if orgID != nil {
+		return *orgID
+	}
+	return userID
+}
+
+// freeFilename returns name, or "name (n).ext" with the smallest n that is not
+// taken by a live file in the folder. scopeID is the org of an org file and
+// the owner otherwise (see nameScope).
+func freeFilename(ctx context.Context, q sqlx.QueryerContext, scopeID string, folderID *string, name string) (string, error) {
+	ext := path.Ext(name)
+	if ext == name {
+		// dotfiles such as ".env" have no stem
+		ext = ""
+	}
+	stem := strings.TrimSuffix(name, ext)
+
+	var taken []string
+	err := sqlx.SelectContext(ctx, q, &taken, `
+SELECT filename FROM user_files
+WHERE COALESCE(org_id, user_id)=$1 AND folder_id IS NOT DISTINCT FROM $2 AND deleted_at IS NULL AND left(filename, $3) = $4`,
+		scopeID, folderID, len([]rune(stem)), stem)
+	if err != nil {
+		return "", fmt.Errorf("list filenames: %w", err)
+	}
+	used := make(map[string]bool, len(taken))
+	for _, t := range taken {
+		used[t] = true
+	}
+
+	candidate := name
+	for n := 1; used[candidate]; n++ {
+		candidate = fmt.Sprintf("%s (%d)%s", stem, n, ext)
+	}
+	return candidate, nil
+}
+
+// insertUserFile inserts a live user_files row. It reports false, without
+// error, if a live file with that name already exists in the folder.
+func insertUserFile(ctx context.Context, tx *sqlx.Tx, userID string, orgID, folderID *string, foID, filename string, pending bool) (string, bool, error) {
+	var id string
+	err := tx.GetContext(ctx, &id, `
+INSERT INTO user_files (id, user_id, file_object_id, filename, folder_id, org_id, content_pending)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, $5, $6)
+ON CONFLICT DO NOTHING RETURNING id`, userID, foID, filename, folderID, orgID, pending)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return id, true, nil
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+func TestValidName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"report.pdf": true,
+		".env":       true,
+		"":           false,
+		".":          false,
+		"..":         false,
+		"a/b":        false,
+		"a\x00b":     false,
+	} {
+		if got := ValidName(name); got != want {
+			t.Errorf("ValidName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestFolders(t *testing.T) {
+	db := testdb.Open(t)
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	userID := createTestUser(t, db)
+
+	reports, err := CreateFolder(ctx, db, userID, nil, "reports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	y2024, err := CreateFolder(ctx, db, userID, &reports.ID, "2024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateFolder(ctx, db, userID, nil, "reports"); !errors.Is(err, ErrNameConflict) {
+		t.Errorf("duplicate folder: err = %v, want ErrNameConflict", err)
+	}
+
+	id, err := ResolveFolderPath(ctx, db, userID, "/reports/2024/")
+	if err != nil || id == nil || *id != y2024.ID {
+		t.Errorf("ResolveFolderPath = %v, %v; want %s", id, err, y2024.ID)
+	}
+	if p, err := FolderPath(ctx, db, y2024.ID); err != nil || p != "/reports/2024" {
+		t.Errorf("FolderPath = %q, %v", p, err)
+	}
+	if err := MoveFolder(ctx, db, userID, reports.ID, &y2024.ID); !errors.Is(err, ErrFolderCycle) {
+		t.Errorf("move into own subfolder: err = %v, want ErrFolderCycle", err)
+	}
+
+	var nonce string
+	if err := db.Get(&nonce, "SELECT gen_random_uuid()::text"); err != nil {
+		t.Fatal(err)
+	}
+	attach := func(name string, folderID *string) *AttachResult {
+		t.Helper()
+		staged, err := store.Put(ctx, bytes.NewReader([]byte(name+nonce)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer staged.Discard()
+		res, err := AttachContent(ctx, db, AttachInput{
+			UserID: userID, Filename: name, FolderID: folderID, Hash: staged.Hash(), Blob: staged,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	first := attach("q1.pdf", &y2024.ID)
+	second := attach("q2.pdf", &y2024.ID)
+	if err := RenameUserFile(ctx, db, second.UserFileID, "q1.pdf"); !errors.Is(err, ErrNameConflict) {
+		t.Errorf("rename onto existing name: err = %v, want ErrNameConflict", err)
+	}
+	if err := MoveUserFile(ctx, db, userID, second.UserFileID, &reports.ID); err != nil {
+		t.Fatal(err)
+	}
+	rootFile := attach("q1.pdf", nil)
+
+	n, err := DeleteFolder(ctx, db, userID, reports.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("DeleteFolder affected %d files, want 2", n)
+	}
+	if _, err := GetFolder(ctx, db, userID, y2024.ID); !errors.Is(err, ErrFolderNotFound) {
+		t.Errorf("subfolder survived delete: %v", err)
+	}
+
+	// the folder is gone, so the file comes back in the root next to rootFile
+	if err := RestoreUserFile(ctx, db, first.UserFileID); err != nil {
+		t.Fatal(err)
+	}
+	var restored struct {
+		Filename string  `db:"filename"`
+		FolderID *string `db:"folder_id"`
+	}
+	db.Get(&restored, "SELECT filename, folder_id FROM user_files WHERE id=$1", first.UserFileID)
+	if restored.FolderID != nil || restored.Filename != "q1 (1).pdf" {
+		t.Errorf("restored as %q in %v, want \"q1 (1).pdf\" in the root (next to %s)", restored.Filename, restored.FolderID, rootFile.Filename)
+	}
+}
@@ -0,0 +1,388 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// FsckOptions controls a consistency check.
+type FsckOptions struct {
+	// Repair fixes what was found instead of only reporting it. Objects
+	// whose content is missing or corrupt are left alone unless DropMissing
+	// is set too.
+	Repair bool
+	// DropMissing makes Repair delete objects whose content is missing or
+	// corrupt, together with every user file and version that references
+	// them, for all users. It cannot be undone.
+	DropMissing bool
+	// Verify re-hashes every blob that has a row. Without it only sizes are
+	// compared, which needs no blob reads.
+	Verify bool
+	// MinAge skips blobs and tmp files modified more recently than this, so
+	// uploads that are in flight are not reported as orphans. Defaults to
+	// one hour.
+	MinAge time.Duration
+}
+
+// FsckObject is a file_objects row whose blob is missing or damaged.
+type FsckObject struct {
+	FileObjectID string `json:"file_object_id"`
+	Hash         string `json:"hash"`
+	StoragePath  string `json:"storage_path"`
+	Detail       string `json:"detail,omitempty"`
+}
+
+// FsckRefCount is a file_objects row whose ref_count disagrees with the
+// number of user_files and file_versions rows pointing at it, or whose
+// pending GC mark disagrees with whether it is referenced at all.
+type FsckRefCount struct {
+	FileObjectID string `json:"file_object_id"`
+	Hash         string `json:"hash"`
+	RefCount     int    `json:"ref_count"`
+	Actual       int    `json:"actual"`
+	PendingGC    bool   `json:"pending_gc"`
+}
+
+// FsckReport lists every inconsistency found. When Repaired is set each of
+// them has been fixed, except missing and corrupt blobs unless LostDropped
+// is set too.
+type FsckReport struct {
+	OrphanBlobs        []string       `json:"orphan_blobs"`
+	MissingBlobs       []FsckObject   `json:"missing_blobs"`
+	CorruptBlobs       []FsckObject   `json:"corrupt_blobs"`
+	RefCountMismatches []FsckRefCount `json:"ref_count_mismatches"`
+	StaleTmpFiles      []string       `json:"stale_tmp_files"`
+	Repaired           bool           `json:"repaired"`
+	// LostDropped is set when the objects in MissingBlobs and CorruptBlobs
+	// were deleted with their files (FsckOptions.DropMissing).
+	LostDropped bool `json:"lost_dropped"`
+}
+
+// Clean reports whether nothing was found.
+func (r *FsckReport) Clean() bool {
+	return len(r.OrphanBlobs) == 0 && len(r.MissingBlobs) == 0 && len(r.CorruptBlobs) == 0 &&
+		len(r.RefCountMismatches) == 0 && len(r.StaleTmpFiles) == 0
+}
+
+// Resolved reports whether nothing found is left in place.
+func (r *FsckReport) Resolved() bool {
+	if !r.Repaired {
+		return r.Clean()
+	}
+	return r.LostDropped || len(r.MissingBlobs) == 0 && len(r.CorruptBlobs) == 0
+}
+
+// Fsck checks that the database and the blob store agree: every blob has a
+// file_objects row and vice versa, blob contents match their hash, ref_count
+// matches the user_files and file_versions references, and no stale files
+// are left in TmpDir.
+//
+// Objects referenced only by files registered by hash (content_pending) have
+// no blob until the content is uploaded, so they are not reported missing.
+//
+// With Repair, orphan blobs and stale tmp files are deleted and ref counts
+// are recomputed (unreferenced rows are marked pending GC). Rows whose
+// content is missing or corrupt are only reported, since removing them loses
+// users' files; with DropMissing they are removed along with the user_files
+// that point at them, as the content cannot be recovered.
+func Fsck(ctx context.Context, db *sqlx.DB, blobs BlobStore, opts FsckOptions) (*FsckReport, error) {
+	if opts.MinAge <= 0 {
+		opts.MinAge = time.Hour
+	}
+	cutoff := time.Now().Add(-opts.MinAge)
+	report := &FsckReport{Repaired: opts.Repair, LostDropped: opts.Repair && opts.DropMissing}
+
+	// Blobs are listed before rows are read. A row committed after the
+	// listing may have a blob the listing missed, so recent rows are not
+	// reported as missing; a blob whose row is not committed yet is recent
+	// and not reported as an orphan.
+	stored := make(map[string]BlobInfo)
+	err := blobs.List(ctx, func(bi BlobInfo) error {
+		stored[bi.Key] = bi
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list blobs: %w", err)
+	}
+
+	var rows []struct {
+		ID          string `db:"id"`
+		Hash        string `db:"hash"`
+		StoragePath string `db:"storage_path"`
+		SizeBytes   int64  `db:"size_bytes"`
+		RefCount    int    `db:"ref_count"`
+		PendingGC   bool   `db:"pending_gc"`
+		Actual      int    `db:"actual"`
+		Recent      bool   `db:"recent"`
+		// AwaitingUpload is set when every reference is content_pending
+		AwaitingUpload bool `db:"awaiting_upload"`
+	}
+	err = db.SelectContext(ctx, &rows, `
+SELECT fo.id, fo.hash, fo.storage_path, fo.size_bytes, fo.ref_count,
+	fo.pending_gc_at IS NOT NULL AS pending_gc,
+	(SELECT COUNT(*) FROM user_files uf WHERE uf.file_object_id = fo.id) +
+		(SELECT COUNT(*) FROM file_versions fv WHERE fv.file_object_id = fo.id) AS actual,
+	COALESCE(fo.created_at > $1, false) AS recent,
+	EXISTS (SELECT 1 FROM user_files uf WHERE uf.file_object_id = fo.id AND uf.content_pending) AND
+		NOT EXISTS (SELECT 1 FROM user_files uf WHERE uf.file_object_id = fo.id AND NOT uf.content_pending) AND
+		NOT EXISTS (SELECT 1 FROM file_versions fv WHERE fv.file_object_id = fo.id AND NOT fv.content_pending) AS awaiting_upload
+FROM file_objects fo
+ORDER BY fo.hash`, cutoff)
+	if err != nil {
+		return nil, fmt.Errorf("list file objects: %w", err)
+	}
+
+	referenced := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		referenced[row.StoragePath] = true
+		obj := FsckObject{FileObjectID: row.ID, Hash: row.Hash, StoragePath: row.StoragePath}
+
+		bi, ok := stored[row.StoragePath]
+		switch {
+		case !ok && row.Recent:
+			// may be an upload whose blob was committed after the listing
+		case !ok && row.AwaitingUpload:
+			// registered by hash, content not uploaded yet
+		case !ok:
+			report.MissingBlobs = append(report.MissingBlobs, obj)
+		case bi.Size != row.SizeBytes:
+			obj.Detail = fmt.Sprintf("size %d, expected %d", bi.Size, row.SizeBytes)
+			report.CorruptBlobs = append(report.CorruptBlobs, obj)
+		case opts.Verify:
+			sum, err := hashBlob(ctx, blobs, row.StoragePath)
+			if errors.Is(err, ErrBlobNotFound) {
+				report.MissingBlobs = append(report.MissingBlobs, obj)
+			} else if err != nil {
+				return nil, fmt.Errorf("verify %s: %w", row.StoragePath, err)
+			} else if sum != row.Hash {
+				obj.Detail = "content hash " + sum
+				report.CorruptBlobs = append(report.CorruptBlobs, obj)
+			}
+		}
+
+		if row.RefCount != row.Actual || row.PendingGC != (row.Actual == 0) {
+			report.RefCountMismatches = append(report.RefCountMismatches, FsckRefCount{
+				FileObjectID: row.ID, Hash: row.Hash, RefCount: row.RefCount, Actual: row.Actual, PendingGC: row.PendingGC,
+			})
+		}
+	}
+
+	for key, bi := range stored {
+		if !referenced[key] && bi.ModTime.Before(cutoff) {
+			report.OrphanBlobs = append(report.OrphanBlobs, key)
+		}
+	}
+
+	report.StaleTmpFiles, err = staleTmpFiles(ctx, db, blobs.TmpDir(), cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Repair {
+		if err := repair(ctx, db, blobs, report); err != nil {
+			return report, fmt.Errorf("repair: %w", err)
+		}
+	}
+	return report, nil
+}
+
+func hashBlob(ctx context.Context, blobs BlobStore, key string) (string, error) {
+	rc, err := blobs.Open(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// staleTmpFiles returns upload spool files and tus partials in dir that are
+// older than cutoff. A tus partial is only stale once its tus_uploads row is
+// gone or expired; live ones may legitimately sit idle until they expire.
+func staleTmpFiles(ctx context.Context, db *sqlx.DB, dir string, cutoff time.Time) ([]string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read tmp dir: %w", err)
+	}
+
+	var stale []string
+	for _, e := range entries {
+		name := e.Name()
+		// the S3 staging dir defaults to the OS temp dir, so only our own
+		// files are considered
+		if e.IsDir() || !(strings.HasPrefix(name, "upload-") || strings.HasPrefix(name, "tus-")) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil || fi.ModTime().After(cutoff) {
+			continue
+		}
+		if id, ok := strings.CutPrefix(name, "tus-"); ok {
+			var live bool
+			err := db.GetContext(ctx, &live,
+				"SELECT EXISTS (SELECT 1 FROM tus_uploads WHERE id::text=$1 AND expires_at > now())", id)
+			if err != nil {
+				return nil, fmt.Errorf("check tus upload: %w", err)
+			}
+			if live {
+				continue
+			}
+		}
+		stale = append(stale, filepath.Join(dir, name))
+	}
+	return stale, nil
+}
+
+func repair(ctx context.Context, db *sqlx.DB, blobs BlobStore, report *FsckReport) error {
+	for _, key := range report.OrphanBlobs {
+		if err := deleteOrphanBlob(ctx, db, blobs, key); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range report.StaleTmpFiles {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove %s: %w", p, err)
+		}
+	}
+
+	if report.LostDropped {
+		lost := append(append([]FsckObject{}, report.MissingBlobs...), report.CorruptBlobs...)
+		for _, obj := range lost {
+			if err := dropLostObject(ctx, db, blobs, obj); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, m := range report.RefCountMismatches {
+		if err := fixRefCount(ctx, db, m.FileObjectID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteOrphanBlob removes a blob unless a row for it has appeared since the
+// check. The row lookup takes the row lock so a concurrent AttachContent of
+// the same content either finishes first or waits and re-commits the blob.
+func deleteOrphanBlob(ctx context.Context, db *sqlx.DB, blobs BlobStore, key string) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.GetContext(ctx, &id, "SELECT id FROM file_objects WHERE storage_path=$1 FOR UPDATE", key)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("lookup %s: %w", key, err)
+	}
+	if err := blobs.Delete(ctx, key); err != nil && !errors.Is(err, ErrBlobNotFound) {
+		return fmt.Errorf("delete blob %s: %w", key, err)
+	}
+	return tx.Commit()
+}
+
+// dropLostObject removes a file_objects row whose content is gone, together
+// with the user_files and file_versions that reference it and whatever is
+// left of the blob. Deleting a user_files row also deletes its history, so
+// the references those versions held are dropped too.
+func dropLostObject(ctx context.Context, db *sqlx.DB, blobs BlobStore, obj FsckObject) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT id FROM file_objects WHERE id=$1 FOR UPDATE", obj.FileObjectID); err != nil {
+		return fmt.Errorf("lock %s: %w", obj.FileObjectID, err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM file_versions WHERE file_object_id=$1", obj.FileObjectID); err != nil {
+		return fmt.Errorf("delete file_versions for %s: %w", obj.FileObjectID, err)
+	}
+	var history []string
+	err = tx.SelectContext(ctx, &history, `
+SELECT fv.file_object_id FROM file_versions fv JOIN user_files uf ON uf.id = fv.user_file_id
+WHERE uf.file_object_id=$1`, obj.FileObjectID)
+	if err != nil {
+		return fmt.Errorf("list versions for %s: %w", obj.FileObjectID, err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_files WHERE file_object_id=$1", obj.FileObjectID); err != nil {
+		return fmt.Errorf("delete user_files for %s: %w", obj.FileObjectID, err)
+	}
+	for _, id := range history {
+		if _, err := dropRef(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM file_objects WHERE id=$1", obj.FileObjectID); err != nil {
+		return fmt.Errorf("delete file_object %s: %w", obj.FileObjectID, err)
+	}
+	if err := blobs.Delete(ctx, obj.StoragePath); err != nil && !errors.Is(err, ErrBlobNotFound) {
+		return fmt.Errorf("delete blob %s: %w", obj.StoragePath, err)
+	}
+	return tx.Commit()
+}
+
+// fixRefCount recounts references with the row locked, so concurrent attach
+// and detach calls are either fully counted or not at all. A row that turns
+// out to be unreferenced is marked pending GC the way DetachContent would.
+func fixRefCount(ctx context.Context, db *sqlx.DB, foID string) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var locked string
+	err = tx.GetContext(ctx, &locked, "SELECT id FROM file_objects WHERE id=$1 FOR UPDATE", foID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("lock %s: %w", foID, err)
+	}
+
+	var actual int
+	err = tx.GetContext(ctx, &actual, `
+SELECT (SELECT COUNT(*) FROM user_files WHERE file_object_id=$1) +
+	(SELECT COUNT(*) FROM file_versions WHERE file_object_id=$1)`, foID)
+	if err != nil {
+		return fmt.Errorf("count references for %s: %w", foID, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+UPDATE file_objects SET
+	ref_count = $2,
+	pending_gc_at = CASE WHEN $2 = 0 THEN COALESCE(pending_gc_at, now()) ELSE NULL END
+WHERE id=$1`, foID, actual)
+	if err != nil {
+		return fmt.Errorf("update ref_count for %s: %w", foID, err)
+	}
+	return tx.Commit()
+}
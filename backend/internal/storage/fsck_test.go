@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+func TestFsckFindsAndRepairs(t *testing.T) {
+	db := testdb.Open(t)
+	root := t.TempDir()
+	store, err := NewLocalBlobStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	userID := createTestUser(t, db)
+
+	var nonce string
+	if err := db.Get(&nonce, "SELECT gen_random_uuid()::text"); err != nil {
+		t.Fatal(err)
+	}
+	attach := func(content string) *AttachResult {
+		t.Helper()
+		staged, err := store.Put(ctx, bytes.NewReader([]byte(content+nonce)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer staged.Discard()
+		res, err := AttachContent(ctx, db, AttachInput{
+			UserID: userID, Filename: content, Hash: staged.Hash(), Blob: staged,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	healthy := attach("healthy")
+	// registered by hash only: no blob until it is uploaded
+	registered, err := AttachContent(ctx, db, AttachInput{
+		UserID: userID, Filename: "registered", Hash: fmt.Sprintf("%064x", sha256.Sum256([]byte("registered"+nonce))),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := attach("missing")
+	corrupt := attach("corrupt")
+	miscounted := attach("miscounted")
+
+	store.Delete(ctx, missing.FileObject.StoragePath)
+	p, _ := store.path(corrupt.FileObject.StoragePath)
+	data, _ := os.ReadFile(p)
+	data[0] ^= 0xff
+	os.WriteFile(p, data, 0o644)
+	db.Exec("UPDATE file_objects SET ref_count=5 WHERE id=$1", miscounted.FileObject.ID)
+
+	orphan, err := store.Put(ctx, bytes.NewReader([]byte("orphan"+nonce)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphan.Commit(ctx)
+	stale := filepath.Join(store.TmpDir(), "upload-stale")
+	os.WriteFile(stale, []byte("x"), 0o644)
+
+	time.Sleep(20 * time.Millisecond)
+	opts := FsckOptions{Verify: true, MinAge: 10 * time.Millisecond}
+
+	report, err := Fsck(ctx, db, store, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(report.OrphanBlobs, orphan.Key()) {
+		t.Errorf("orphan blob not reported: %v", report.OrphanBlobs)
+	}
+	if !slices.ContainsFunc(report.MissingBlobs, func(o FsckObject) bool { return o.FileObjectID == missing.FileObject.ID }) {
+		t.Errorf("missing blob not reported: %v", report.MissingBlobs)
+	}
+	if !slices.ContainsFunc(report.CorruptBlobs, func(o FsckObject) bool { return o.FileObjectID == corrupt.FileObject.ID }) {
+		t.Errorf("corrupt blob not reported: %v", report.CorruptBlobs)
+	}
+	if !slices.ContainsFunc(report.RefCountMismatches, func(m FsckRefCount) bool {
+		return m.FileObjectID == miscounted.FileObject.ID && m.RefCount == 5 && m.Actual == 1
+	}) {
+		t.Errorf("ref_count mismatch not reported: %v", report.RefCountMismatches)
+	}
+	if !slices.Contains(report.StaleTmpFiles, stale) {
+		t.Errorf("stale tmp file not reported: %v", report.StaleTmpFiles)
+	}
+	for _, o := range append(report.MissingBlobs, report.CorruptBlobs...) {
+		if o.FileObjectID == healthy.FileObject.ID || o.FileObjectID == registered.FileObject.ID {
+			t.Errorf("healthy or awaiting object reported: %+v", o)
+		}
+	}
+
+	// a plain repair keeps the files whose content is lost
+	opts.Repair = true
+	if report, err = Fsck(ctx, db, store, opts); err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	if report.Resolved() {
+		t.Errorf("repair without DropMissing reported lost objects as resolved")
+	}
+	report, err = Fsck(ctx, db, store, FsckOptions{Verify: true, MinAge: opts.MinAge})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.MissingBlobs) == 0 || len(report.CorruptBlobs) == 0 || len(report.OrphanBlobs) > 0 ||
+		len(report.RefCountMismatches) > 0 || len(report.StaleTmpFiles) > 0 {
+		t.Errorf("after repair without DropMissing: %+v", report)
+	}
+	var kept int
+	db.Get(&kept, "SELECT COUNT(*) FROM user_files WHERE id = ANY($1)", pq.Array([]string{missing.UserFileID, corrupt.UserFileID}))
+	if kept != 2 {
+		t.Errorf("repair without DropMissing deleted %d files", 2-kept)
+	}
+
+	opts.DropMissing = true
+	if report, err = Fsck(ctx, db, store, opts); err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	if !report.Resolved() {
+		t.Errorf("repair with DropMissing not resolved: %+v", report)
+	}
+	report, err = Fsck(ctx, db, store, FsckOptions{Verify: true, MinAge: opts.MinAge})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Clean() {
+		t.Errorf("not clean after repair: %+v", report)
+	}
+	if _, err := store.Stat(ctx, healthy.FileObject.StoragePath); err != nil {
+		t.Errorf("healthy blob removed by repair: %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// tmpDirName is the staging directory inside the storage root. It is never
+// reported by List.
+const tmpDirName = "tmp"
+
+// LocalBlobStore keeps blobs on the local filesystem under
+// <root>/<hash[:2]>/<hash>, staging uploads in <root>/tmp.
+type LocalBlobStore struct {
+	root string
+}
+
+func NewLocalBlobStore(root string) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(filepath.Join(root, tmpDirName), 0o755); err != nil {
+		return nil, fmt.Errorf("create storage root: %w", err)
+	}
+	return &LocalBlobStore{root: root}, nil
+}
+
+// Root returns the directory blobs are stored under.
+func (s *LocalBlobStore) Root() string { return s.root }
+
+// TmpDir returns the staging directory for partial uploads.
+func (s *LocalBlobStore) TmpDir() string { return filepath.Join(s.root, tmpDirName) }
+
+func (s *LocalBlobStore) path(key string) (string, error) {
+	if !filepath.IsLocal(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *LocalBlobStore) Put(ctx context.Context, r io.Reader) (StagedBlob, error) {
+	sp, err := spool(s.TmpDir(), r)
+	if err != nil {
+		return nil, err
+	}
+	return &localStaged{store: s, spooled: sp}, nil
+}
+
+func (s *LocalBlobStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.OpenRange(ctx, key, 0, -1)
+}
+
+func (s *LocalBlobStore) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (s *LocalBlobStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	fi, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return BlobInfo{}, ErrBlobNotFound
+	}
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{Key: key, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (s *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrBlobNotFound
+	}
+	return err
+}
+
+func (s *LocalBlobStore) List(ctx context.Context, fn func(BlobInfo) error) error {
+	return filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if rel == tmpDirName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(BlobInfo{Key: filepath.ToSlash(rel), Size: fi.Size(), ModTime: fi.ModTime()})
+	})
+}
+
+// spooled is upload content written to a temp file together with its
+// SHA-256, before it is known where (or whether) it will be stored.
+type spooled struct {
+	tmpPath string
+	hash    string
+	size    int64
+}
+
+func spool(dir string, r io.Reader) (*spooled, error) {
+	tmp, err := os.CreateTemp(dir, "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("tmp create: %w", err)
+	}
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("stream copy: %w", err)
+	}
+
+	return &spooled{tmpPath: tmp.Name(), hash: hex.EncodeToString(hasher.Sum(nil)), size: size}, nil
+}
+
+func (sp *spooled) Hash() string { return sp.hash }
+func (sp *spooled) Size() int64  { return sp.size }
+func (sp *spooled) Key() string  { return BlobKey(sp.hash) }
+
+func (sp *spooled) Discard() error {
+	err := os.Remove(sp.tmpPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+type localStaged struct {
+	*spooled
+	store *LocalBlobStore
+}
+
+func (b *localStaged) Commit(ctx context.Context) error {
+	finalPath, err := b.store.path(b.Key())
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(finalPath); err == nil {
+		// identical content is already stored
+		return b.Discard()
+	}
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0o755); err != nil {
+		return fmt.Errorf("mkdir final: %w", err)
+	}
+
+	// move temp -> final
+	if err := os.Rename(b.tmpPath, finalPath); err != nil {
+		// fallback: copy
+		if err := copyFile(b.tmpPath, finalPath); err != nil {
+			return fmt.Errorf("store file failed: %w", err)
+		}
+		os.Remove(b.tmpPath)
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Sync()
+}
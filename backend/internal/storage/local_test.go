@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocalBlobStorePutCommit(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalBlobStore failed: %v", err)
+	}
+
+	content := "hello blob store"
+	sum := sha256.Sum256([]byte(content))
+	wantHash := hex.EncodeToString(sum[:])
+
+	staged, err := store.Put(ctx, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if staged.Hash() != wantHash {
+		t.Errorf("Expected hash %s, got %s", wantHash, staged.Hash())
+	}
+	if staged.Size() != int64(len(content)) {
+		t.Errorf("Expected size %d, got %d", len(content), staged.Size())
+	}
+	if staged.Key() != wantHash[:2]+"/"+wantHash {
+		t.Errorf("Unexpected key %s", staged.Key())
+	}
+
+	// not visible until committed
+	if _, err := store.Stat(ctx, staged.Key()); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Expected ErrBlobNotFound before commit, got %v", err)
+	}
+	if err := staged.Commit(ctx); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	info, err := store.Stat(ctx, staged.Key())
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Size != int64(len(content)) {
+		t.Errorf("Expected stat size %d, got %d", len(content), info.Size)
+	}
+
+	// committing identical content again keeps the existing blob
+	dup, err := store.Put(ctx, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Put duplicate failed: %v", err)
+	}
+	if err := dup.Commit(ctx); err != nil {
+		t.Fatalf("Commit duplicate failed: %v", err)
+	}
+
+	var keys []string
+	err = store.List(ctx, func(b BlobInfo) error {
+		keys = append(keys, b.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != staged.Key() {
+		t.Errorf("Expected only %s to be listed, got %v", staged.Key(), keys)
+	}
+}
+
+func TestLocalBlobStoreOpenRange(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalBlobStore failed: %v", err)
+	}
+
+	staged, err := store.Put(ctx, strings.NewReader("0123456789"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := staged.Commit(ctx); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	rc, err := store.OpenRange(ctx, staged.Key(), 3, 4)
+	if err != nil {
+		t.Fatalf("OpenRange failed: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(got) != "3456" {
+		t.Errorf("Expected 3456, got %q", got)
+	}
+
+	rs := NewBlobReadSeeker(ctx, store, staged.Key(), 10)
+	if _, err := rs.Seek(-2, io.SeekEnd); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	got, _ = io.ReadAll(rs)
+	rs.Close()
+	if string(got) != "89" {
+		t.Errorf("Expected 89, got %q", got)
+	}
+
+	if err := store.Delete(ctx, staged.Key()); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Open(ctx, staged.Key()); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Expected ErrBlobNotFound after delete, got %v", err)
+	}
+}
+
+func TestLocalBlobStoreRejectsEscapingKeys(t *testing.T) {
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalBlobStore failed: %v", err)
+	}
+	if _, err := store.Open(context.Background(), "../etc/passwd"); err == nil {
+		t.Error("Open should reject keys outside the storage root")
+	}
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// BlobReadSeeker adapts a BlobStore key to io.ReadSeeker so it can be handed
+// to http.ServeContent. Seeking is free; each Read after a seek opens a
+// ranged read from the backend, so only the requested bytes are fetched.
+type BlobReadSeeker struct {
+	ctx   context.Context
+	store BlobStore
+	key   string
+	size  int64
+
+	off  int64
+	body io.ReadCloser
+}
+
+func NewBlobReadSeeker(ctx context.Context, store BlobStore, key string, size int64) *BlobReadSeeker {
+	return &BlobReadSeeker{ctx: ctx, store: store, key: key, size: size}
+}
+
+func (b *BlobReadSeeker) Read(p []byte) (int, error) {
+	if b.off >= b.size {
+		return 0, io.EOF
+	}
+	if b.body == nil {
+		body, err := b.store.OpenRange(b.ctx, b.key, b.off, b.size-b.off)
+		if err != nil {
+			return 0, err
+		}
+		b.body = body
+	}
+	n, err := b.body.Read(p)
+	b.off += int64(n)
+	return n, err
+}
+
+func (b *BlobReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = b.off + offset
+	case io.SeekEnd:
+		abs = b.size + offset
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+	if abs != b.off {
+		b.closeBody()
+		b.off = abs
+	}
+	return abs, nil
+}
+
+func (b *BlobReadSeeker) Close() error {
+	return b.closeBody()
+}
+
+func (b *BlobReadSeeker) closeBody() error {
+	if b.body == nil {
+		return nil
+	}
+	err := b.body.Close()
+	b.body = nil
+	return err
+}
@@ -0,0 +1,355 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// ErrUserFileNotFound is returned when a user_files row does not exist.
+var ErrUserFileNotFound = errors.New("user file not found")
+
+// AttachInput describes content being added to a user's vault.
+type AttachInput struct {
+	UserID   string
+	Filename string
+	// FolderID is the folder to add the file to; nil is the root. A live
+	// file with the same name in the folder gets the content as a new version.
+	FolderID *string
+	// ReplaceUserFileID, if set, adds the content as a new version of that
+	// file instead (it must be a live file of UserID).
+	ReplaceUserFileID *string
+	// OrgID makes the file an organization file. Org files are not placed in
+	// folders, and a live org file with the same name gets the content as a
+	// new version whoever uploaded it.
+	OrgID *string
+	Hash  string
+	// MimeType is the type detected from Blob's content; it is ignored
+	// without a Blob.
+	MimeType string
+	// Blob, if set, is committed while the file_objects row is locked, so
+	// the row never becomes visible without its content. It is nil for
+	// metadata-only registration, whose content stays pending (not
+	// downloadable) until the same bytes are uploaded to the file.
+	Blob StagedBlob
+}
+
+type AttachResult struct {
+	FileObject *FileObject
+	UserFileID string
+	Filename   string
+	// Version is the file's current version number after the attach.
+	Version int
+	// Unchanged is true when the content was identical to the current
+	// version, in which case nothing was changed.
+	Unchanged bool
+	// Created is true when this call created the file_objects row rather
+	// than taking another reference on an existing one.
+	Created bool
+	// ContentPending is true when the file's current content was registered
+	// by hash alone and cannot be downloaded yet.
+	ContentPending bool
+}
+
+// AttachContent links content to a user in one transaction: it upserts the
+// file_objects row for the hash (taking a reference with ON CONFLICT, which
+// also row-locks it) and inserts the user_files row. Concurrent attaches of
+// the same hash serialise on the row lock instead of racing on the UNIQUE
+// constraint, and a failed user_files insert rolls the reference back.
+// Taking a reference on an object that is pending GC brings it back.
+//
+// If the file being added already exists (see AttachInput), the content
+// becomes its new version and the old one is kept in file_versions, up to the
+// user's version limit. Uploading the content a file was registered with by
+// hash alone clears its content_pending flag. A registration without content
+// records a size of 0 and no type until the content is uploaded.
+func AttachContent(ctx context.Context, db *sqlx.DB, in AttachInput) (*AttachResult, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if in.OrgID != nil && in.FolderID != nil {
+		return nil, ErrOrgFolder
+	}
+	if err := checkFolder(ctx, tx, in.UserID, in.FolderID); err != nil {
+		return nil, err
+	}
+
+	// lock the file this becomes a new version of, if there is one, before
+	// any file_objects row (the order RestoreVersion uses too)
+	target, err := lockVersionTarget(ctx, tx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	// size and type only ever come from stored content: a registration
+	// knows neither, and an upload sets both, also on a row registered first
+	var size int64
+	var mimeType string
+	if in.Blob != nil {
+		size, mimeType = in.Blob.Size(), in.MimeType
+	}
+	var row struct {
+		FileObject
+		Inserted bool `db:"inserted"`
+	}
+	err = tx.GetContext(ctx, &row, `
+INSERT INTO file_objects (id, hash, storage_path, size_bytes, mime_type, ref_count)
+VALUES (gen_random_uuid(), $1, $2, $3, NULLIF($4, ''), 1)
+ON CONFLICT (hash) DO UPDATE SET ref_count = file_objects.ref_count + 1, pending_gc_at = NULL,
+	size_bytes = CASE WHEN $5 THEN EXCLUDED.size_bytes ELSE file_objects.size_bytes END,
+	mime_type = CASE WHEN $5 THEN EXCLUDED.mime_type ELSE file_objects.mime_type END
+RETURNING id, hash, storage_path, size_bytes, COALESCE(mime_type, '') AS mime_type, ref_count, created_at,
+	(xmax = 0) AS inserted`,
+		in.Hash, BlobKey(in.Hash), size, mimeType, in.Blob != nil)
+	if err != nil {
+		return nil, fmt.Errorf("upsert file object: %w", err)
+	}
+
+	if in.Blob != nil {
+		// Commit is a no-op when the content is already stored. It still runs
+		// for existing rows so a metadata-only registration gets its blob.
+		if err := in.Blob.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("store file failed: %w", err)
+		}
+	}
+
+	fo := row.FileObject
+	res := &AttachResult{FileObject: &fo, Created: row.Inserted, ContentPending: in.Blob == nil}
+
+	if target == nil {
+		var inserted bool
+		res.UserFileID, inserted, err = insertUserFile(ctx, tx, in.UserID, in.OrgID, in.FolderID, row.ID, in.Filename, res.ContentPending)
+		if err != nil {
+			return nil, fmt.Errorf("create user_file failed: %w", err)
+		}
+		if inserted {
+			res.Filename, res.Version = in.Filename, 1
+		} else {
+			// a concurrent upload created the file first; become its next version
+			if target, err = lockVersionTarget(ctx, tx, in); err != nil {
+				return nil, err
+			}
+			if target == nil {
+				return nil, ErrNameConflict
+			}
+		}
+	}
+
+	if target != nil {
+		res.UserFileID, res.Filename, res.Version = target.ID, target.Filename, target.Version
+		if target.FileObjectID == row.ID {
+			// same content as the current version: give back the reference
+			if _, err := dropRef(ctx, tx, row.ID); err != nil {
+				return nil, err
+			}
+			res.Unchanged = true
+			fo.RefCount--
+			if target.ContentPending && in.Blob != nil {
+				// the owner uploaded the content they registered by hash
+				if _, err := tx.ExecContext(ctx, "UPDATE user_files SET content_pending = false WHERE id=$1", target.ID); err != nil {
+					return nil, fmt.Errorf("clear content_pending: %w", err)
+				}
+			}
+			res.ContentPending = target.ContentPending && in.Blob == nil
+		} else if res.Version, err = addVersion(ctx, tx, target, row.ID, res.ContentPending); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return res, nil
+}
+
+type DetachResult struct {
+	FileObjectID string
+	// Unreferenced is true when the last reference was dropped and the
+	// object is now pending GC.
+	Unreferenced bool
+}
+
+// DetachContent deletes a user_files row and drops its reference in one
+// transaction. An object whose last reference is dropped is not deleted:
+// it is marked pending_gc_at and its blob stays until SweepGarbage removes
+// it after the grace period, so an upload of the same content in the
+// meantime simply takes a new reference on it.
+func DetachContent(ctx context.Context, db *sqlx.DB, userFileID string) (*DetachResult, error) {
+	return detach(ctx, db, "DELETE FROM user_files WHERE id=$1 RETURNING file_object_id", userFileID)
+}
+
+// detach runs deleteQuery, which must delete at most one user_files row and
+// return its file_object_id, and drops that row's reference along with the
+// references held by its earlier versions.
+func detach(ctx context.Context, db *sqlx.DB, deleteQuery, userFileID string) (*DetachResult, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// lock the row before listing its versions, so a concurrent addVersion
+	// either commits first or adds none
+	var locked string
+	err = tx.GetContext(ctx, &locked, "SELECT id FROM user_files WHERE id=$1 FOR UPDATE", userFileID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserFileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lock user_file failed: %w", err)
+	}
+
+	// file_versions rows go with the user_files row (ON DELETE CASCADE)
+	var versionObjects []string
+	err = tx.SelectContext(ctx, &versionObjects, "SELECT file_object_id FROM file_versions WHERE user_file_id=$1", userFileID)
+	if err != nil {
+		return nil, fmt.Errorf("list versions: %w", err)
+	}
+
+	var foID string
+	err = tx.GetContext(ctx, &foID, deleteQuery, userFileID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserFileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("delete user_file failed: %w", err)
+	}
+
+	refCount, err := dropRef(ctx, tx, foID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range versionObjects {
+		if _, err := dropRef(ctx, tx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return &DetachResult{FileObjectID: foID, Unreferenced: refCount == 0}, nil
+}
+
+// DetachUserContent deletes every file a user owns outside organizations,
+// with its earlier versions, and drops their references inside tx. It is
+// the file part of deleting a user: the user_files rows would go with the
+// user (ON DELETE CASCADE), but their references would stay counted and the
+// objects would never be collected. The caller must hold the user's row lock
+// (FOR UPDATE), which keeps new files from being added meanwhile. It returns
+// how many files were deleted.
+func DetachUserContent(ctx context.Context, tx *sqlx.Tx, userID string) (int, error) {
+	// lock the files before listing their versions, as detach does
+	var ids []string
+	err := tx.SelectContext(ctx, &ids,
+		"SELECT id FROM user_files WHERE user_id = $1 AND org_id IS NULL ORDER BY id FOR UPDATE", userID)
+	if err != nil {
+		return 0, fmt.Errorf("lock user files: %w", err)
+	}
+
+	// in id order, so concurrent detaches lock shared objects in the same order
+	var objects []string
+	err = tx.SelectContext(ctx, &objects, `
+SELECT file_object_id FROM user_files WHERE id = ANY($1)
+UNION ALL
+SELECT file_object_id FROM file_versions WHERE user_file_id = ANY($1)
+ORDER BY 1`, pq.Array(ids))
+	if err != nil {
+		return 0, fmt.Errorf("list user files: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM user_files WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		return 0, fmt.Errorf("delete user files: %w", err)
+	}
+	for _, id := range objects {
+		if _, err := dropRef(ctx, tx, id); err != nil {
+			return 0, err
+		}
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// dropRef releases one reference on a file object, marking it pending GC when
+// it was the last one, and returns the new ref_count.
+func dropRef(ctx context.Context, tx *sqlx.Tx, foID string) (int, error) {
+	var refCount int
+	err := tx.GetContext(ctx, &refCount, `
+UPDATE file_objects SET
+	ref_count = GREATEST(ref_count - 1, 0),
+	pending_gc_at = CASE WHEN ref_count <= 1 THEN now() ELSE NULL END
+WHERE id=$1 RETURNING ref_count`, foID)
+	if err != nil {
+		return 0, fmt.Errorf("decrement failed: %w", err)
+	}
+	return refCount, nil
+}
+
+// SweepGarbage deletes objects that have been pending GC for longer than
+// grace, together with their blobs, and returns how many were removed.
+// Each object is deleted in its own transaction with the row locked and the
+// blob removed before commit, so an upload of the same content either
+// revives the object first or waits and then re-creates row and blob.
+func SweepGarbage(ctx context.Context, db *sqlx.DB, blobs BlobStore, grace time.Duration) (int, error) {
+	var ids []string
+	err := db.SelectContext(ctx, &ids,
+		"SELECT id FROM file_objects WHERE pending_gc_at < now() - make_interval(secs => $1)", grace.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("list pending gc: %w", err)
+	}
+
+	removed := 0
+	for _, id := range ids {
+		ok, err := sweepObject(ctx, db, blobs, id, grace)
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func sweepObject(ctx context.Context, db *sqlx.DB, blobs BlobStore, id string, grace time.Duration) (bool, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// re-checked under the row lock: the object may have been revived
+	var storagePath string
+	err = tx.GetContext(ctx, &storagePath, `
+DELETE FROM file_objects
+WHERE id=$1 AND ref_count = 0 AND pending_gc_at < now() - make_interval(secs => $2)
+	AND NOT EXISTS (SELECT 1 FROM user_files WHERE file_object_id = $1)
+	AND NOT EXISTS (SELECT 1 FROM file_versions WHERE file_object_id = $1)
+RETURNING storage_path`, id, grace.Seconds())
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("delete file_object %s: %w", id, err)
+	}
+
+	if storagePath != "" {
+		if err := blobs.Delete(ctx, storagePath); err != nil && !errors.Is(err, ErrBlobNotFound) {
+			return false, fmt.Errorf("delete blob %s: %w", storagePath, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit: %w", err)
+	}
+	return true, nil
+}
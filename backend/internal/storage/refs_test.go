@@ -0,0 +1,431 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+func createTestUser(t *testing.T, db *sqlx.DB) string {
+	t.Helper()
+	var id string
+	err := db.Get(&id, "INSERT INTO users (email, password_hash) VALUES ('refs-' || gen_random_uuid() || '@example.com', 'x') RETURNING id")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM users WHERE id=$1", id) })
+	return id
+}
+
+func TestAttachDetachConcurrent(t *testing.T) {
+	db := testdb.Open(t)
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	userID := createTestUser(t, db)
+
+	// unique per run so a shared test database does not interfere
+	var nonce string
+	if err := db.Get(&nonce, "SELECT gen_random_uuid()::text"); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("concurrent dedup " + nonce)
+
+	const n = 16
+	ids := make([]string, n)
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			staged, err := store.Put(ctx, bytes.NewReader(content))
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer staged.Discard()
+			res, err := AttachContent(ctx, db, AttachInput{
+				UserID:   userID,
+				Filename: fmt.Sprintf("dup-%d.txt", i),
+				Hash:     staged.Hash(),
+				MimeType: "text/plain",
+				Blob:     staged,
+			})
+			if err != nil {
+				errs <- err
+				return
+			}
+			ids[i] = res.UserFileID
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("attach: %v", err)
+	}
+
+	staged, err := store.Put(ctx, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, key := staged.Hash(), staged.Key()
+	staged.Discard()
+
+	var refCount int
+	if err := db.Get(&refCount, "SELECT ref_count FROM file_objects WHERE hash=$1", hash); err != nil {
+		t.Fatalf("file object: %v", err)
+	}
+	if refCount != n {
+		t.Errorf("ref_count = %d, want %d", refCount, n)
+	}
+	var rows int
+	db.Get(&rows, "SELECT COUNT(*) FROM user_files uf JOIN file_objects fo ON fo.id = uf.file_object_id WHERE fo.hash=$1", hash)
+	if rows != n {
+		t.Errorf("user_files rows = %d, want %d", rows, n)
+	}
+	if _, err := store.Stat(ctx, key); err != nil {
+		t.Errorf("blob missing after attach: %v", err)
+	}
+
+	var unreferenced int
+	var mu sync.Mutex
+	errs = make(chan error, n)
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			res, err := DetachContent(ctx, db, id)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if res.Unreferenced {
+				mu.Lock()
+				unreferenced++
+				mu.Unlock()
+			}
+		}(id)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("detach: %v", err)
+	}
+
+	if unreferenced != 1 {
+		t.Errorf("unreferenced reported %d times, want 1", unreferenced)
+	}
+	var pending bool
+	if err := db.Get(&pending, "SELECT ref_count = 0 AND pending_gc_at IS NOT NULL FROM file_objects WHERE hash=$1", hash); err != nil {
+		t.Fatalf("file object after detach: %v", err)
+	}
+	if !pending {
+		t.Errorf("file object not pending gc after last detach")
+	}
+	if _, err := store.Stat(ctx, key); err != nil {
+		t.Errorf("blob removed before sweep: %v", err)
+	}
+
+	// still inside the grace period
+	if _, err := SweepGarbage(ctx, db, store, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stat(ctx, key); err != nil {
+		t.Errorf("blob swept inside grace period: %v", err)
+	}
+
+	db.Exec("UPDATE file_objects SET pending_gc_at = now() - interval '2 hours' WHERE hash=$1", hash)
+	if _, err := SweepGarbage(ctx, db, store, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	var left int
+	db.Get(&left, "SELECT COUNT(*) FROM file_objects WHERE hash=$1", hash)
+	if left != 0 {
+		t.Errorf("file_objects row still present after sweep")
+	}
+	if _, err := store.Stat(ctx, key); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("blob still present after sweep: %v", err)
+	}
+
+	if _, err := DetachContent(ctx, db, ids[0]); !errors.Is(err, ErrUserFileNotFound) {
+		t.Errorf("second detach err = %v, want ErrUserFileNotFound", err)
+	}
+}
+
+func TestDetachConcurrentWithNewVersion(t *testing.T) {
+	db := testdb.Open(t)
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	var nonce string
+	if err := db.Get(&nonce, "SELECT gen_random_uuid()::text"); err != nil {
+		t.Fatal(err)
+	}
+	attach := func(userID, content string, replace *string) (*AttachResult, error) {
+		staged, err := store.Put(ctx, bytes.NewReader([]byte(content+nonce)))
+		if err != nil {
+			return nil, err
+		}
+		defer staged.Discard()
+		return AttachContent(ctx, db, AttachInput{
+			UserID: userID, Filename: "raced.txt", ReplaceUserFileID: replace,
+			Hash: staged.Hash(), Blob: staged,
+		})
+	}
+
+	// each round races a new version against deleting the file, either one
+	// file (detach) or all of a user's (DetachUserContent)
+	const rounds = 20
+	for i := 0; i < rounds; i++ {
+		userID := createTestUser(t, db)
+		first, err := attach(userID, fmt.Sprintf("v1-%d ", i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects := []string{first.FileObject.ID}
+
+		var wg sync.WaitGroup
+		var second *AttachResult
+		var attachErr, detachErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			second, attachErr = attach(userID, fmt.Sprintf("v2-%d ", i), &first.UserFileID)
+		}()
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				_, detachErr = DetachContent(ctx, db, first.UserFileID)
+				return
+			}
+			tx, err := db.BeginTxx(ctx, nil)
+			if err != nil {
+				detachErr = err
+				return
+			}
+			defer tx.Rollback()
+			if _, err := tx.Exec("SELECT id FROM users WHERE id=$1 FOR UPDATE", userID); err != nil {
+				detachErr = err
+				return
+			}
+			if _, err := DetachUserContent(ctx, tx, userID); err != nil {
+				detachErr = err
+				return
+			}
+			detachErr = tx.Commit()
+		}()
+		wg.Wait()
+
+		if detachErr != nil {
+			t.Fatalf("round %d: detach: %v", i, detachErr)
+		}
+		if attachErr != nil && !errors.Is(attachErr, ErrUserFileNotFound) {
+			t.Fatalf("round %d: attach: %v", i, attachErr)
+		}
+		if second != nil {
+			objects = append(objects, second.FileObject.ID)
+		}
+
+		// every reference still counted must belong to a row
+		var leaked []string
+		err = db.Select(&leaked, `
+SELECT fo.id FROM file_objects fo WHERE fo.id = ANY($1) AND fo.ref_count <>
+	(SELECT COUNT(*) FROM user_files WHERE file_object_id = fo.id) +
+	(SELECT COUNT(*) FROM file_versions WHERE file_object_id = fo.id)`, pq.Array(objects))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(leaked) > 0 {
+			t.Errorf("round %d: ref_count does not match references of %v", i, leaked)
+		}
+	}
+}
+
+func TestReuploadRevivesPendingObject(t *testing.T) {
+	db := testdb.Open(t)
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	userID := createTestUser(t, db)
+
+	var nonce string
+	if err := db.Get(&nonce, "SELECT gen_random_uuid()::text"); err != nil {
+		t.Fatal(err)
+	}
+	attach := func() *AttachResult {
+		t.Helper()
+		staged, err := store.Put(ctx, bytes.NewReader([]byte("revive "+nonce)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer staged.Discard()
+		res, err := AttachContent(ctx, db, AttachInput{
+			UserID: userID, Filename: "revive.txt", Hash: staged.Hash(), Blob: staged,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	first := attach()
+	if _, err := DetachContent(ctx, db, first.UserFileID); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("UPDATE file_objects SET pending_gc_at = now() - interval '2 hours' WHERE id=$1", first.FileObject.ID)
+
+	second := attach()
+	if second.FileObject.ID != first.FileObject.ID || second.Created {
+		t.Fatalf("re-upload created a new object instead of reviving %s", first.FileObject.ID)
+	}
+
+	if n, err := SweepGarbage(ctx, db, store, time.Hour); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Errorf("swept %d objects, want 0", n)
+	}
+	var refCount int
+	db.Get(&refCount, "SELECT ref_count FROM file_objects WHERE id=$1 AND pending_gc_at IS NULL", first.FileObject.ID)
+	if refCount != 1 {
+		t.Errorf("ref_count = %d after revival, want 1", refCount)
+	}
+	if _, err := store.Stat(ctx, first.FileObject.StoragePath); err != nil {
+		t.Errorf("revived blob missing: %v", err)
+	}
+}
+
+func TestHashOnlyAttachIsPending(t *testing.T) {
+	db := testdb.Open(t)
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	owner := createTestUser(t, db)
+	other := createTestUser(t, db)
+
+	var nonce string
+	if err := db.Get(&nonce, "SELECT gen_random_uuid()::text"); err != nil {
+		t.Fatal(err)
+	}
+	upload := func(userID string) *AttachResult {
+		t.Helper()
+		staged, err := store.Put(ctx, bytes.NewReader([]byte("secret "+nonce)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer staged.Discard()
+		res, err := AttachContent(ctx, db, AttachInput{
+			UserID: userID, Filename: "secret.txt", Hash: staged.Hash(), Blob: staged,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	pending := func(userFileID string) bool {
+		t.Helper()
+		var p bool
+		if err := db.Get(&p, "SELECT content_pending FROM user_files WHERE id=$1", userFileID); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	uploaded := upload(owner)
+	if uploaded.ContentPending || pending(uploaded.UserFileID) {
+		t.Fatalf("uploaded file is pending")
+	}
+
+	// knowing the hash attaches the object but does not expose its content
+	registered, err := AttachContent(ctx, db, AttachInput{
+		UserID: other, Filename: "secret.txt", Hash: uploaded.FileObject.Hash,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registered.FileObject.ID != uploaded.FileObject.ID {
+		t.Fatalf("hash-only attach created a new object")
+	}
+	if !registered.ContentPending || !pending(registered.UserFileID) {
+		t.Errorf("hash-only attach is not pending")
+	}
+
+	// uploading the same bytes to the file proves possession
+	proved := upload(other)
+	if proved.UserFileID != registered.UserFileID || !proved.Unchanged {
+		t.Fatalf("upload did not land on the registered file: %+v", proved)
+	}
+	if proved.ContentPending || pending(registered.UserFileID) {
+		t.Errorf("file still pending after its content was uploaded")
+	}
+}
+
+func TestAttachSizeAndTypeFromContent(t *testing.T) {
+	db := testdb.Open(t)
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	userID := createTestUser(t, db)
+
+	var nonce string
+	if err := db.Get(&nonce, "SELECT gen_random_uuid()::text"); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("measured " + nonce)
+	staged, err := store.Put(ctx, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer staged.Discard()
+
+	// registered first, with nothing to measure
+	registered, err := AttachContent(ctx, db, AttachInput{
+		UserID: userID, Filename: "registered.txt", Hash: staged.Hash(), MimeType: "text/html",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fo := registered.FileObject; fo.SizeBytes != 0 || fo.MimeType != "" {
+		t.Errorf("registration stored size %d, type %q", fo.SizeBytes, fo.MimeType)
+	}
+
+	uploaded, err := AttachContent(ctx, db, AttachInput{
+		UserID: userID, Filename: "uploaded.txt", Hash: staged.Hash(), MimeType: "text/plain; charset=utf-8", Blob: staged,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fo := uploaded.FileObject; fo.ID != registered.FileObject.ID || fo.SizeBytes != int64(len(content)) || fo.MimeType != "text/plain; charset=utf-8" {
+		t.Errorf("after upload: %+v, want size %d and the detected type", fo, len(content))
+	}
+
+	// a later registration does not change them
+	again, err := AttachContent(ctx, db, AttachInput{
+		UserID: userID, Filename: "again.txt", Hash: staged.Hash(), MimeType: "text/html",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fo := again.FileObject; fo.SizeBytes != int64(len(content)) || fo.MimeType != "text/plain; charset=utf-8" {
+		t.Errorf("registration changed the object: %+v", fo)
+	}
+}
@@ -0,0 +1,492 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// S3 rejects multipart parts smaller than 5 MiB (except the last one).
+	minS3PartSize     = 5 << 20
+	defaultS3PartSize = 16 << 20
+)
+
+// S3Config configures an S3BlobStore.
+type S3Config struct {
+	// Endpoint is the service base URL, e.g. https://s3.eu-west-1.amazonaws.com
+	// or http://minio:9000.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Prefix is prepended to every blob key inside the bucket.
+	Prefix string
+	// PathStyle addresses the bucket as <endpoint>/<bucket>/<key> instead of
+	// <bucket>.<endpoint>/<key>. MinIO and most self-hosted services need it.
+	PathStyle bool
+	// PartSize is both the multipart threshold and the part size.
+	PartSize int64
+	// StagingDir holds uploads while they are hashed, before they are sent.
+	StagingDir string
+	HTTPClient *http.Client
+}
+
+// S3BlobStore stores blobs in an S3-compatible bucket using the REST API
+// directly, signed with SigV4. Objects larger than PartSize are sent with a
+// multipart upload.
+type S3BlobStore struct {
+	cfg      S3Config
+	endpoint *url.URL
+	signer   *sigV4Signer
+	client   *http.Client
+	now      func() time.Time
+}
+
+func NewS3BlobStore(cfg S3Config) (*S3BlobStore, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("s3: endpoint and bucket are required")
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.PartSize == 0 {
+		cfg.PartSize = defaultS3PartSize
+	}
+	if cfg.PartSize < minS3PartSize {
+		return nil, fmt.Errorf("s3: part size must be at least %d bytes", minS3PartSize)
+	}
+	if cfg.StagingDir == "" {
+		cfg.StagingDir = os.TempDir()
+	}
+	if err := os.MkdirAll(cfg.StagingDir, 0o755); err != nil {
+		return nil, fmt.Errorf("s3: create staging dir: %w", err)
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+
+	return &S3BlobStore{
+		cfg:      cfg,
+		endpoint: u,
+		signer: &sigV4Signer{
+			accessKey: cfg.AccessKey,
+			secretKey: cfg.SecretKey,
+			region:    cfg.Region,
+			service:   "s3",
+		},
+		client: client,
+		now:    time.Now,
+	}, nil
+}
+
+func newS3BlobStoreFromEnv() (*S3BlobStore, error) {
+	cfg := S3Config{
+		Endpoint:   os.Getenv("S3_ENDPOINT"),
+		Region:     os.Getenv("S3_REGION"),
+		Bucket:     os.Getenv("S3_BUCKET"),
+		AccessKey:  os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretKey:  os.Getenv("S3_SECRET_ACCESS_KEY"),
+		Prefix:     os.Getenv("S3_PREFIX"),
+		PathStyle:  getEnv("S3_FORCE_PATH_STYLE", "true") == "true",
+		StagingDir: getEnv("S3_STAGING_DIR", os.TempDir()),
+	}
+	if v := os.Getenv("S3_PART_SIZE_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("s3: invalid S3_PART_SIZE_BYTES: %w", err)
+		}
+		cfg.PartSize = n
+	}
+	return NewS3BlobStore(cfg)
+}
+
+func (s *S3BlobStore) objectName(key string) string {
+	if s.cfg.Prefix == "" {
+		return key
+	}
+	return s.cfg.Prefix + "/" + key
+}
+
+// newRequest builds an unsigned request for an object, or for the bucket
+// itself when object is empty. Callers set extra headers (Range,
+// Content-Type) and then send it with do, which signs everything.
+func (s *S3BlobStore) newRequest(ctx context.Context, method, object string, query url.Values, body io.Reader, size int64) (*http.Request, error) {
+	u := *s.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if s.cfg.PathStyle {
+		path += "/" + s.cfg.Bucket
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	if object != "" || !s.cfg.PathStyle {
+		path += "/" + object
+	}
+	u.Path = path
+	u.RawPath = awsURIEncode(path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	return req, nil
+}
+
+func (s *S3BlobStore) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	if payloadHash == "" {
+		payloadHash = emptyPayloadSHA
+	}
+	s.signer.sign(req, payloadHash, s.now())
+	return s.client.Do(req)
+}
+
+// s3Error is the XML error document returned by S3.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func readS3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var e s3Error
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return fmt.Errorf("s3: %s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, e.Code, e.Message)
+	}
+	return fmt.Errorf("s3: %s %s: unexpected status %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)
+}
+
+// TmpDir returns the local staging directory.
+func (s *S3BlobStore) TmpDir() string { return s.cfg.StagingDir }
+
+func (s *S3BlobStore) Put(ctx context.Context, r io.Reader) (StagedBlob, error) {
+	sp, err := spool(s.cfg.StagingDir, r)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Staged{spooled: sp, store: s}, nil
+}
+
+func (s *S3BlobStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.OpenRange(ctx, key, 0, -1)
+}
+
+func (s *S3BlobStore) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	req, err := s.newRequest(ctx, http.MethodGet, s.objectName(key), nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case length > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := s.do(req, "")
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrBlobNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, readS3Error(resp)
+	}
+}
+
+func (s *S3BlobStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	req, err := s.newRequest(ctx, http.MethodHead, s.objectName(key), nil, nil, 0)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	resp, err := s.do(req, "")
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return BlobInfo{}, ErrBlobNotFound
+	default:
+		return BlobInfo{}, fmt.Errorf("s3: HEAD %s: unexpected status %s", key, resp.Status)
+	}
+
+	info := BlobInfo{Key: key, Size: resp.ContentLength}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return info, nil
+}
+
+// Delete removes the object. S3 does not report whether the object existed,
+// so ErrBlobNotFound is never returned.
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, s.objectName(key), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return readS3Error(resp)
+	}
+	return nil
+}
+
+type listBucketResult struct {
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+	Contents              []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+func (s *S3BlobStore) List(ctx context.Context, fn func(BlobInfo) error) error {
+	prefix := ""
+	if s.cfg.Prefix != "" {
+		prefix = s.cfg.Prefix + "/"
+	}
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}}
+		if prefix != "" {
+			q.Set("prefix", prefix)
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		req, err := s.newRequest(ctx, http.MethodGet, "", q, nil, 0)
+		if err != nil {
+			return err
+		}
+		resp, err := s.do(req, "")
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := readS3Error(resp)
+			resp.Body.Close()
+			return err
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("s3: decode list: %w", err)
+		}
+
+		for _, c := range page.Contents {
+			if err := fn(BlobInfo{Key: strings.TrimPrefix(c.Key, prefix), Size: c.Size, ModTime: c.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+type s3Staged struct {
+	*spooled
+	store *S3BlobStore
+}
+
+func (b *s3Staged) Commit(ctx context.Context) error {
+	defer b.Discard()
+
+	s := b.store
+	if _, err := s.Stat(ctx, b.Key()); err == nil {
+		// identical content is already stored
+		return nil
+	} else if !errors.Is(err, ErrBlobNotFound) {
+		return err
+	}
+
+	f, err := os.Open(b.tmpPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if b.size <= s.cfg.PartSize {
+		// the object hash doubles as the signed payload hash
+		return s.putObject(ctx, b.Key(), io.NewSectionReader(f, 0, b.size), b.size, b.hash)
+	}
+	return s.multipartUpload(ctx, b.Key(), f, b.size)
+}
+
+func (s *S3BlobStore) putObject(ctx context.Context, key string, body io.Reader, size int64, payloadHash string) error {
+	req, err := s.newRequest(ctx, http.MethodPut, s.objectName(key), nil, body, size)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.do(req, payloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return readS3Error(resp)
+	}
+	return nil
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+func (s *S3BlobStore) multipartUpload(ctx context.Context, key string, f io.ReaderAt, size int64) (err error) {
+	object := s.objectName(key)
+
+	req, err := s.newRequest(ctx, http.MethodPost, object, url.Values{"uploads": {""}}, nil, 0)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.do(req, "")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return readS3Error(resp)
+	}
+	var initRes initiateMultipartUploadResult
+	err = xml.NewDecoder(resp.Body).Decode(&initRes)
+	resp.Body.Close()
+	if err != nil || initRes.UploadID == "" {
+		return fmt.Errorf("s3: initiate multipart upload: %v", err)
+	}
+	uploadID := initRes.UploadID
+
+	defer func() {
+		if err != nil {
+			// don't leave orphaned parts behind; use a fresh context in case ctx was cancelled
+			s.abortMultipartUpload(context.Background(), object, uploadID)
+		}
+	}()
+
+	var parts []completedPart
+	for partNumber, off := 1, int64(0); off < size; partNumber, off = partNumber+1, off+s.cfg.PartSize {
+		n := min(s.cfg.PartSize, size-off)
+		etag, err := s.uploadPart(ctx, object, uploadID, partNumber, io.NewSectionReader(f, off, n), n)
+		if err != nil {
+			return err
+		}
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: etag})
+	}
+
+	payload, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return err
+	}
+	payloadHash := hexSHA256(payload)
+	req, err = s.newRequest(ctx, http.MethodPost, object, url.Values{"uploadId": {uploadID}}, bytes.NewReader(payload), int64(len(payload)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	resp, err = s.do(req, payloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return readS3Error(resp)
+	}
+	// CompleteMultipartUpload can fail after sending 200; the error is in the body
+	body, _ := io.ReadAll(resp.Body)
+	var e s3Error
+	if xml.Unmarshal(body, &e) == nil && e.Code != "" {
+		return fmt.Errorf("s3: complete multipart upload: %s: %s", e.Code, e.Message)
+	}
+	return nil
+}
+
+func (s *S3BlobStore) uploadPart(ctx context.Context, object, uploadID string, partNumber int, part *io.SectionReader, size int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, part); err != nil {
+		return "", err
+	}
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	payloadHash := hex.EncodeToString(h.Sum(nil))
+
+	q := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+	req, err := s.newRequest(ctx, http.MethodPut, object, q, part, size)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.do(req, payloadHash)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", readS3Error(resp)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("s3: upload part %d: missing ETag", partNumber)
+	}
+	return etag, nil
+}
+
+func (s *S3BlobStore) abortMultipartUpload(ctx context.Context, object, uploadID string) {
+	req, err := s.newRequest(ctx, http.MethodDelete, object, url.Values{"uploadId": {uploadID}}, nil, 0)
+	if err != nil {
+		return
+	}
+	if resp, err := s.do(req, ""); err == nil {
+		resp.Body.Close()
+	}
+}
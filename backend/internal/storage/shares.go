@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrShareNotFound  = errors.New("share not found")
+	ErrShareExpired   = errors.New("share link has expired")
+	ErrShareExhausted = errors.New("share link has reached its download limit")
+)
+
+// Share is a public link to a user file. Token is the secret part of the
+// /s/{token} URL (stored in shares.public_link).
+type Share struct {
+	ID            string     `db:"id"`
+	UserFileID    string     `db:"user_file_id"`
+	Token         string     `db:"public_link"`
+	ExpiresAt     *time.Time `db:"expires_at"`
+	MaxDownloads  *int       `db:"max_downloads"`
+	DownloadCount int64      `db:"download_count"`
+	PasswordHash  *string    `db:"password_hash"`
+	CreatedAt     time.Time  `db:"created_at"`
+	// Filename is the shared file's current name.
+	Filename string `db:"filename"`
+}
+
+const shareColumns = `s.id, s.user_file_id, s.public_link, s.expires_at, s.max_downloads,
+	s.download_count, s.password_hash, s.created_at, uf.filename`
+
+// Check reports whether the link can still be used: ErrShareExpired after
+// ExpiresAt, ErrShareExhausted once MaxDownloads downloads were counted.
+func (s *Share) Check(now time.Time) error {
+	if s.ExpiresAt != nil && !now.Before(*s.ExpiresAt) {
+		return ErrShareExpired
+	}
+	if s.MaxDownloads != nil && s.DownloadCount >= int64(*s.MaxDownloads) {
+		return ErrShareExhausted
+	}
+	return nil
+}
+
+type ShareInput struct {
+	UserFileID   string
+	ExpiresAt    *time.Time
+	MaxDownloads *int
+	// PasswordHash, if set, is the hash of the password the link asks for.
+	PasswordHash *string
+}
+
+func newShareToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateShare creates a link to a live file of userID.
+func CreateShare(ctx context.Context, db *sqlx.DB, userID string, in ShareInput) (*Share, error) {
+	token, err := newShareToken()
+	if err != nil {
+		return nil, fmt.Errorf("generate token: %w", err)
+	}
+	var s Share
+	err = db.GetContext(ctx, &s, `
+WITH s AS (
+	INSERT INTO shares (user_file_id, public_link, expires_at, max_downloads, password_hash)
+	SELECT id, $3, $4, $5, $6 FROM user_files WHERE id=$1 AND user_id=$2 AND deleted_at IS NULL
+	RETURNING *
+)
+SELECT `+shareColumns+` FROM s JOIN user_files uf ON uf.id = s.user_file_id`,
+		in.UserFileID, userID, token, in.ExpiresAt, in.MaxDownloads, in.PasswordHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserFileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create share: %w", err)
+	}
+	return &s, nil
+}
+
+// ListShares returns the links to userID's files, newest first, including
+// expired and exhausted ones.
+func ListShares(ctx context.Context, db *sqlx.DB, userID string) ([]Share, error) {
+	shares := []Share{}
+	err := db.SelectContext(ctx, &shares, `
+SELECT `+shareColumns+` FROM shares s JOIN user_files uf ON uf.id = s.user_file_id
+WHERE uf.user_id=$1 ORDER BY s.created_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list shares: %w", err)
+	}
+	return shares, nil
+}
+
+// RevokeShare deletes a link to one of userID's files.
+func RevokeShare(ctx context.Context, db *sqlx.DB, userID, shareID string) error {
+	res, err := db.ExecContext(ctx, `
+DELETE FROM shares s USING user_files uf
+WHERE s.id=$1 AND uf.id = s.user_file_id AND uf.user_id=$2`, shareID, userID)
+	if err != nil {
+		return fmt.Errorf("revoke share: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+// GetShareByToken looks up a link by its token. Links to trashed files are
+// reported as ErrShareNotFound.
+func GetShareByToken(ctx context.Context, db *sqlx.DB, token string) (*Share, error) {
+	var s Share
+	err := db.GetContext(ctx, &s, `
+SELECT `+shareColumns+` FROM shares s JOIN user_files uf ON uf.id = s.user_file_id
+WHERE s.public_link=$1 AND uf.deleted_at IS NULL`, token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get share: %w", err)
+	}
+	return &s, nil
+}
+
+// CountShareDownload increments download_count if the link is still usable,
+// so concurrent downloads cannot go past MaxDownloads. Otherwise it returns
+// the reason the link cannot be used.
+func CountShareDownload(ctx context.Context, db *sqlx.DB, shareID string) error {
+	var count int64
+	err := db.GetContext(ctx, &count, `
+UPDATE shares SET download_count = download_count + 1
+WHERE id=$1
+	AND (expires_at IS NULL OR expires_at > now())
+	AND (max_downloads IS NULL OR download_count < max_downloads)
+RETURNING download_count`, shareID)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("count download: %w", err)
+	}
+
+	var s Share
+	err = db.GetContext(ctx, &s, "SELECT id, expires_at, max_downloads, download_count FROM shares WHERE id=$1", shareID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrShareNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("get share: %w", err)
+	}
+	if err := s.Check(time.Now()); err != nil {
+		return err
+	}
+	return ErrShareExhausted
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+func TestShareCheck(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+	two := 2
+	tests := []struct {
+		name  string
+		share Share
+		want  error
+	}{
+		{"unlimited", Share{}, nil},
+		{"not yet expired", Share{ExpiresAt: &future}, nil},
+		{"expired", Share{ExpiresAt: &past}, ErrShareExpired},
+		{"downloads left", Share{MaxDownloads: &two, DownloadCount: 1}, nil},
+		{"exhausted", Share{MaxDownloads: &two, DownloadCount: 2}, ErrShareExhausted},
+	}
+	for _, tt := range tests {
+		if err := tt.share.Check(now); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Check() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestShareDownloadLimit(t *testing.T) {
+	db := testdb.Open(t)
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	userID := createTestUser(t, db)
+	other := createTestUser(t, db)
+
+	staged, err := store.Put(ctx, bytes.NewReader([]byte("shared "+userID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer staged.Discard()
+	file, err := AttachContent(ctx, db, AttachInput{
+		UserID: userID, Filename: "shared.txt", Hash: staged.Hash(), Blob: staged,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	one := 1
+	if _, err := CreateShare(ctx, db, other, ShareInput{UserFileID: file.UserFileID}); !errors.Is(err, ErrUserFileNotFound) {
+		t.Errorf("sharing another user's file: err = %v, want ErrUserFileNotFound", err)
+	}
+	share, err := CreateShare(ctx, db, userID, ShareInput{UserFileID: file.UserFileID, MaxDownloads: &one})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := GetShareByToken(ctx, db, share.Token); err != nil || got.ID != share.ID {
+		t.Fatalf("GetShareByToken = %v, %v", got, err)
+	}
+
+	if err := CountShareDownload(ctx, db, share.ID); err != nil {
+		t.Fatalf("first download: %v", err)
+	}
+	if err := CountShareDownload(ctx, db, share.ID); !errors.Is(err, ErrShareExhausted) {
+		t.Errorf("second download: err = %v, want ErrShareExhausted", err)
+	}
+
+	if err := RevokeShare(ctx, db, other, share.ID); !errors.Is(err, ErrShareNotFound) {
+		t.Errorf("revoke by another user: err = %v, want ErrShareNotFound", err)
+	}
+	if err := RevokeShare(ctx, db, userID, share.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetShareByToken(ctx, db, share.Token); !errors.Is(err, ErrShareNotFound) {
+		t.Errorf("revoked link still resolves: %v", err)
+	}
+}
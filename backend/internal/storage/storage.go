@@ -3,7 +3,6 @@ package storage
 import (
 	"database/sql"
 
-	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -19,7 +18,8 @@ type FileObject struct {
 
 func FindFileObjectByHash(db *sqlx.DB, hash string) (*FileObject, error) {
 	var fo FileObject
-	err := db.Get(&fo, "SELECT * FROM file_objects WHERE hash=$1", hash)
+	err := db.Get(&fo, `SELECT id, hash, storage_path, size_bytes, COALESCE(mime_type, '') AS mime_type, ref_count, created_at
+		FROM file_objects WHERE hash=$1`, hash)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -28,26 +28,3 @@ func FindFileObjectByHash(db *sqlx.DB, hash string) (*FileObject, error) {
 	}
 	return &fo, nil
 }
-
-func CreateFileObject(db *sqlx.DB, hash, storagePath string, size int64, mime string) (*FileObject, error) {
-	id := uuid.New().String()
-	_, err := db.Exec(`INSERT INTO file_objects (id, hash, storage_path, size_bytes, mime_type, ref_count) VALUES ($1,$2,$3,$4,$5,$6)`,
-		id, hash, storagePath, size, mime, 1)
-	if err != nil {
-		return nil, err
-	}
-
-	return &FileObject{
-		ID:          id,
-		Hash:        hash,
-		StoragePath: storagePath,
-		SizeBytes:   size,
-		MimeType:    mime,
-		RefCount:    1,
-	}, nil
-}
-
-func IncrementRefCount(db *sqlx.DB, id string) error {
-	_, err := db.Exec("UPDATE file_objects SET ref_count = ref_count + 1 WHERE id=$1", id)
-	return err
-}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TrashUserFile moves a file to the trash. The row keeps its file_objects
+// reference, so the content stays stored and counts against quota until the
+// file is purged.
+func TrashUserFile(ctx context.Context, db *sqlx.DB, userFileID string) error {
+	res, err := db.ExecContext(ctx,
+		"UPDATE user_files SET deleted_at = now() WHERE id=$1 AND deleted_at IS NULL", userFileID)
+	if err != nil {
+		return fmt.Errorf("trash user_file failed: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserFileNotFound
+	}
+	return nil
+}
+
+// RestoreUserFile takes a file back out of the trash. If its name has been
+// taken in the meantime it gets a " (n)" suffix, and a file whose folder was
+// deleted goes back to the root.
+func RestoreUserFile(ctx context.Context, db *sqlx.DB, userFileID string) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var uf struct {
+		ScopeID  string  `db:"scope_id"`
+		FolderID *string `db:"folder_id"`
+		Filename string  `db:"filename"`
+	}
+	err = tx.GetContext(ctx, &uf, `
+SELECT COALESCE(org_id, user_id) AS scope_id, folder_id, filename
+FROM user_files WHERE id=$1 AND deleted_at IS NOT NULL FOR UPDATE`, userFileID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserFileNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("lookup user_file failed: %w", err)
+	}
+
+	name, err := freeFilename(ctx, tx, uf.ScopeID, uf.FolderID, uf.Filename)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE user_files SET deleted_at = NULL, filename = $2 WHERE id=$1", userFileID, name)
+	if isUniqueViolation(err) {
+		return ErrNameConflict
+	}
+	if err != nil {
+		return fmt.Errorf("restore user_file failed: %w", err)
+	}
+	return tx.Commit()
+}
+
+// PurgeUserFile permanently deletes a file that is in the trash and drops its
+// reference (see DetachContent). Files that are not trashed are left alone
+// and reported as ErrUserFileNotFound.
+func PurgeUserFile(ctx context.Context, db *sqlx.DB, userFileID string) (*DetachResult, error) {
+	return detach(ctx, db,
+		"DELETE FROM user_files WHERE id=$1 AND deleted_at IS NOT NULL RETURNING file_object_id", userFileID)
+}
+
+// EmptyTrash purges every trashed file of a user and returns how many were
+// purged.
+func EmptyTrash(ctx context.Context, db *sqlx.DB, userID string) (int, error) {
+	var ids []string
+	err := db.SelectContext(ctx, &ids,
+		"SELECT id FROM user_files WHERE user_id=$1 AND deleted_at IS NOT NULL", userID)
+	if err != nil {
+		return 0, fmt.Errorf("list trash: %w", err)
+	}
+	return purgeAll(ctx, db, ids)
+}
+
+// PurgeExpiredTrash purges files that have been in the trash for longer than
+// retention and returns how many were purged.
+func PurgeExpiredTrash(ctx context.Context, db *sqlx.DB, retention time.Duration) (int, error) {
+	var ids []string
+	err := db.SelectContext(ctx, &ids,
+		"SELECT id FROM user_files WHERE deleted_at < now() - make_interval(secs => $1)", retention.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("list expired trash: %w", err)
+	}
+	return purgeAll(ctx, db, ids)
+}
+
+func purgeAll(ctx context.Context, db *sqlx.DB, ids []string) (int, error) {
+	purged := 0
+	for _, id := range ids {
+		_, err := PurgeUserFile(ctx, db, id)
+		if errors.Is(err, ErrUserFileNotFound) {
+			// restored or purged concurrently
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+func TestTrashRestoreAndRetention(t *testing.T) {
+	db := testdb.Open(t)
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	userID := createTestUser(t, db)
+
+	var nonce string
+	if err := db.Get(&nonce, "SELECT gen_random_uuid()::text"); err != nil {
+		t.Fatal(err)
+	}
+	staged, err := store.Put(ctx, bytes.NewReader([]byte("trash "+nonce)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer staged.Discard()
+	res, err := AttachContent(ctx, db, AttachInput{
+		UserID: userID, Filename: "trash.txt", Hash: staged.Hash(), Blob: staged,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, foID := res.UserFileID, res.FileObject.ID
+
+	refCount := func() int {
+		t.Helper()
+		var n int
+		if err := db.Get(&n, "SELECT ref_count FROM file_objects WHERE id=$1", foID); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if _, err := PurgeUserFile(ctx, db, id); !errors.Is(err, ErrUserFileNotFound) {
+		t.Errorf("purge of a live file: err = %v, want ErrUserFileNotFound", err)
+	}
+	if err := RestoreUserFile(ctx, db, id); !errors.Is(err, ErrUserFileNotFound) {
+		t.Errorf("restore of a live file: err = %v, want ErrUserFileNotFound", err)
+	}
+
+	if err := TrashUserFile(ctx, db, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := TrashUserFile(ctx, db, id); !errors.Is(err, ErrUserFileNotFound) {
+		t.Errorf("second trash: err = %v, want ErrUserFileNotFound", err)
+	}
+	if n := refCount(); n != 1 {
+		t.Errorf("ref_count = %d while trashed, want 1", n)
+	}
+
+	if err := RestoreUserFile(ctx, db, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := TrashUserFile(ctx, db, id); err != nil {
+		t.Fatal(err)
+	}
+
+	// inside the retention period
+	if _, err := PurgeExpiredTrash(ctx, db, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if n := refCount(); n != 1 {
+		t.Errorf("ref_count = %d after early purge run, want 1", n)
+	}
+
+	db.Exec("UPDATE user_files SET deleted_at = now() - interval '2 days' WHERE id=$1", id)
+	if _, err := PurgeExpiredTrash(ctx, db, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if n := refCount(); n != 0 {
+		t.Errorf("ref_count = %d after retention purge, want 0", n)
+	}
+	var exists bool
+	db.Get(&exists, "SELECT EXISTS (SELECT 1 FROM user_files WHERE id=$1)", id)
+	if exists {
+		t.Errorf("user_files row still present after retention purge")
+	}
+}
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrVersionNotFound is returned when a file has no version with the
+// requested number.
+var ErrVersionNotFound = errors.New("version not found")
+
+// ErrInvalidVersionLimit is returned for a version limit below 1.
+var ErrInvalidVersionLimit = errors.New("version limit must be at least 1")
+
+// DefaultMaxVersions returns how many versions of a file, including the
+// current one, are kept for users without their own limit (MAX_FILE_VERSIONS,
+// default 10).
+func DefaultMaxVersions() int {
+	n, err := strconv.Atoi(getEnv("MAX_FILE_VERSIONS", "10"))
+	if err != nil || n < 1 {
+		return 10
+	}
+	return n
+}
+
+// FileVersion is an earlier version of a user file.
+type FileVersion struct {
+	ID           string    `db:"id"`
+	UserFileID   string    `db:"user_file_id"`
+	Version      int       `db:"version"`
+	FileObjectID string    `db:"file_object_id"`
+	Filename     string    `db:"filename"`
+	CreatedAt    time.Time `db:"created_at"`
+}
+
+// versionTarget is the locked current state of a file getting a new version.
+type versionTarget struct {
+	ID           string    `db:"id"`
+	UserID       string    `db:"user_id"`
+	FileObjectID string    `db:"file_object_id"`
+	Filename     string    `db:"filename"`
+	Version      int       `db:"version"`
+	UploadedAt   time.Time `db:"uploaded_at"`
+	// ContentPending is set while the current content was registered by
+	// hash alone (see AttachInput.Blob).
+	ContentPending bool `db:"content_pending"`
+}
+
+const versionTargetColumns = "id, user_id, file_object_id, filename, version, uploaded_at, content_pending"
+
+// lockVersionTarget locks the live file an attach adds a version to: the file
+// named by ReplaceUserFileID, or else the one with the same name in the
+// folder (or the org). It returns nil if there is none, which means a new file.
+func lockVersionTarget(ctx context.Context, tx *sqlx.Tx, in AttachInput) (*versionTarget, error) {
+	var t versionTarget
+	var err error
+	if in.ReplaceUserFileID != nil {
+		err = tx.GetContext(ctx, &t, "SELECT "+versionTargetColumns+
+			" FROM user_files WHERE id=$1 AND user_id=$2 AND deleted_at IS NULL FOR UPDATE",
+			*in.ReplaceUserFileID, in.UserID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserFileNotFound
+		}
+	} else {
+		err = tx.GetContext(ctx, &t, "SELECT "+versionTargetColumns+
+			" FROM user_files WHERE COALESCE(org_id, user_id)=$1 AND folder_id IS NOT DISTINCT FROM $2 AND filename=$3 AND deleted_at IS NULL FOR UPDATE",
+			nameScope(in.UserID, in.OrgID), in.FolderID, in.Filename)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lock user_file failed: %w", err)
+	}
+	return &t, nil
+}
+
+// addVersion moves the current version of a locked file into file_versions
+// and makes foID, on which the caller already holds a reference, the current
+// content (pending if it was registered by hash alone). It returns the new
+// version number.
+func addVersion(ctx context.Context, tx *sqlx.Tx, t *versionTarget, foID string, pending bool) (int, error) {
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO file_versions (user_file_id, version, file_object_id, filename, created_at, content_pending)
+VALUES ($1, $2, $3, $4, $5, $6)`, t.ID, t.Version, t.FileObjectID, t.Filename, t.UploadedAt, t.ContentPending)
+	if err != nil {
+		return 0, fmt.Errorf("save version failed: %w", err)
+	}
+	var version int
+	err = tx.GetContext(ctx, &version, `
+UPDATE user_files SET file_object_id=$2, version = version + 1, uploaded_at = now(), content_pending=$3
+WHERE id=$1 RETURNING version`, t.ID, foID, pending)
+	if err != nil {
+		return 0, fmt.Errorf("update user_file failed: %w", err)
+	}
+	if err := pruneVersions(ctx, tx, t.UserID, &t.ID); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// pruneVersions deletes the oldest versions beyond the user's limit, either
+// of one file or (userFileID nil) of all the user's files, and drops their
+// references. The current version is not in file_versions but counts
+// towards the limit, hence n >= limit.
+func pruneVersions(ctx context.Context, tx *sqlx.Tx, userID string, userFileID *string) error {
+	var pruned []string
+	err := tx.SelectContext(ctx, &pruned, `
+WITH ranked AS (
+	SELECT fv.id, row_number() OVER (PARTITION BY fv.user_file_id ORDER BY fv.version DESC) AS n
+	FROM file_versions fv JOIN user_files uf ON uf.id = fv.user_file_id
+	WHERE uf.user_id=$1 AND ($2::uuid IS NULL OR uf.id = $2)
+)
+DELETE FROM file_versions fv USING ranked, users u
+WHERE fv.id = ranked.id AND u.id=$1 AND ranked.n >= COALESCE(u.max_file_versions, $3)
+RETURNING fv.file_object_id`, userID, userFileID, DefaultMaxVersions())
+	if err != nil {
+		return fmt.Errorf("prune versions failed: %w", err)
+	}
+	for _, foID := range pruned {
+		if _, err := dropRef(ctx, tx, foID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListVersions returns the earlier versions of a file, newest first.
+func ListVersions(ctx context.Context, db sqlx.QueryerContext, userFileID string) ([]FileVersion, error) {
+	versions := []FileVersion{}
+	err := sqlx.SelectContext(ctx, db, &versions, `
+SELECT id, user_file_id, version, file_object_id, filename, created_at
+FROM file_versions WHERE user_file_id=$1 ORDER BY version DESC`, userFileID)
+	if err != nil {
+		return nil, fmt.Errorf("list versions: %w", err)
+	}
+	return versions, nil
+}
+
+// RestoreVersion makes the content of an earlier version current again. The
+// history is kept: the restore is recorded as a new version, so the version
+// it replaces can itself be restored later. Restoring content identical to
+// the current version changes nothing. It returns the current version number.
+func RestoreVersion(ctx context.Context, db *sqlx.DB, userID, userFileID string, version int) (int, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	t, err := lockVersionTarget(ctx, tx, AttachInput{UserID: userID, ReplaceUserFileID: &userFileID})
+	if err != nil {
+		return 0, err
+	}
+
+	var v struct {
+		FileObjectID   string `db:"file_object_id"`
+		ContentPending bool   `db:"content_pending"`
+	}
+	err = tx.GetContext(ctx, &v,
+		"SELECT file_object_id, content_pending FROM file_versions WHERE user_file_id=$1 AND version=$2", userFileID, version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrVersionNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("lookup version failed: %w", err)
+	}
+	foID := v.FileObjectID
+	if foID == t.FileObjectID {
+		return t.Version, nil
+	}
+
+	// the version row keeps its own reference, the new current one needs another
+	_, err = tx.ExecContext(ctx,
+		"UPDATE file_objects SET ref_count = ref_count + 1, pending_gc_at = NULL WHERE id=$1", foID)
+	if err != nil {
+		return 0, fmt.Errorf("increment failed: %w", err)
+	}
+	current, err := addVersion(ctx, tx, t, foID, v.ContentPending)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return current, nil
+}
+
+// SetVersionLimit sets how many versions per file a user keeps (nil restores
+// the server default) and prunes existing history down to it. The limit
+// counts the current version, so a limit of N keeps N-1 earlier versions
+// and a limit of 1 keeps no history at all.
+func SetVersionLimit(ctx context.Context, db *sqlx.DB, userID string, limit *int) error {
+	if limit != nil && *limit < 1 {
+		return ErrInvalidVersionLimit
+	}
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET max_file_versions=$2 WHERE id=$1", userID, limit); err != nil {
+		return fmt.Errorf("set version limit: %w", err)
+	}
+	if err := pruneVersions(ctx, tx, userID, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// VersionLimit returns the number of versions per file kept for a user.
+func VersionLimit(ctx context.Context, db sqlx.QueryerContext, userID string) (int, error) {
+	var limit int
+	err := sqlx.GetContext(ctx, db, &limit,
+		"SELECT COALESCE(max_file_versions, $2) FROM users WHERE id=$1", userID, DefaultMaxVersions())
+	if err != nil {
+		return 0, fmt.Errorf("version limit: %w", err)
+	}
+	return limit, nil
+}
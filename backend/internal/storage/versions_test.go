@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+func TestVersionsPruneAndRestore(t *testing.T) {
+	db := testdb.Open(t)
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	userID := createTestUser(t, db)
+
+	var nonce string
+	if err := db.Get(&nonce, "SELECT gen_random_uuid()::text"); err != nil {
+		t.Fatal(err)
+	}
+	attach := func(content string, replace *string) *AttachResult {
+		t.Helper()
+		staged, err := store.Put(ctx, bytes.NewReader([]byte(content+nonce)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer staged.Discard()
+		res, err := AttachContent(ctx, db, AttachInput{
+			UserID: userID, Filename: "report.txt", ReplaceUserFileID: replace,
+			Hash: staged.Hash(), Blob: staged,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	refCount := func(foID string) int {
+		t.Helper()
+		var n int
+		if err := db.Get(&n, "SELECT ref_count FROM file_objects WHERE id=$1", foID); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	v1 := attach("one", nil)
+	v2 := attach("two", nil)
+	if v2.UserFileID != v1.UserFileID || v2.Version != 2 {
+		t.Fatalf("same-name upload: file %s version %d, want %s version 2", v2.UserFileID, v2.Version, v1.UserFileID)
+	}
+	again := attach("two", &v1.UserFileID)
+	if !again.Unchanged || again.Version != 2 || refCount(v2.FileObject.ID) != 1 {
+		t.Errorf("identical re-upload: unchanged=%v version=%d ref_count=%d, want true 2 1",
+			again.Unchanged, again.Version, refCount(v2.FileObject.ID))
+	}
+
+	limit := 2
+	if err := SetVersionLimit(ctx, db, userID, &limit); err != nil {
+		t.Fatal(err)
+	}
+	v3 := attach("three", nil)
+	versions, err := ListVersions(ctx, db, v1.UserFileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].Version != 2 {
+		t.Fatalf("history = %+v, want only version 2", versions)
+	}
+	if n := refCount(v1.FileObject.ID); n != 0 {
+		t.Errorf("pruned version ref_count = %d, want 0", n)
+	}
+
+	current, err := RestoreVersion(ctx, db, userID, v1.UserFileID, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != 4 || refCount(v2.FileObject.ID) != 2 {
+		t.Errorf("restore: version %d, ref_count %d; want 4, 2", current, refCount(v2.FileObject.ID))
+	}
+	if _, err := RestoreVersion(ctx, db, userID, v1.UserFileID, 1); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("restore pruned version: err = %v, want ErrVersionNotFound", err)
+	}
+
+	if _, err := DetachContent(ctx, db, v1.UserFileID); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{v2.FileObject.ID, v3.FileObject.ID} {
+		if n := refCount(id); n != 0 {
+			t.Errorf("ref_count of %s = %d after delete, want 0", id, n)
+		}
+	}
+}
+
+func TestVersionLimitCountsCurrent(t *testing.T) {
+	db := testdb.Open(t)
+	store, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	userID := createTestUser(t, db)
+
+	var nonce string
+	if err := db.Get(&nonce, "SELECT gen_random_uuid()::text"); err != nil {
+		t.Fatal(err)
+	}
+	upload := func(name string, n int) string {
+		t.Helper()
+		var id string
+		for i := 0; i < n; i++ {
+			staged, err := store.Put(ctx, bytes.NewReader(fmt.Appendf(nil, "%s %d %s", name, i, nonce)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := AttachContent(ctx, db, AttachInput{UserID: userID, Filename: name, Hash: staged.Hash(), Blob: staged})
+			staged.Discard()
+			if err != nil {
+				t.Fatal(err)
+			}
+			id = res.UserFileID
+		}
+		return id
+	}
+	history := func(userFileID string) []int {
+		t.Helper()
+		versions, err := ListVersions(ctx, db, userFileID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := []int{}
+		for _, v := range versions {
+			out = append(out, v.Version)
+		}
+		return out
+	}
+
+	// a limit of N keeps the current version and N-1 earlier ones
+	limit := 3
+	if err := SetVersionLimit(ctx, db, userID, &limit); err != nil {
+		t.Fatal(err)
+	}
+	file := upload("n.txt", 5)
+	if got := history(file); !slices.Equal(got, []int{4, 3}) {
+		t.Errorf("history with limit 3 = %v, want [4 3]", got)
+	}
+
+	// a limit of 1 keeps only the current version, and prunes what is there
+	limit = 1
+	if err := SetVersionLimit(ctx, db, userID, &limit); err != nil {
+		t.Fatal(err)
+	}
+	if got := history(file); len(got) != 0 {
+		t.Errorf("history after lowering the limit to 1 = %v, want none", got)
+	}
+	if got := history(upload("one.txt", 3)); len(got) != 0 {
+		t.Errorf("history with limit 1 = %v, want none", got)
+	}
+
+	limit = 0
+	if err := SetVersionLimit(ctx, db, userID, &limit); !errors.Is(err, ErrInvalidVersionLimit) {
+		t.Errorf("limit 0: err = %v, want ErrInvalidVersionLimit", err)
+	}
+}
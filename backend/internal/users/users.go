@@ -0,0 +1,317 @@
+// Package users is the admin side of user accounts: listing them with their
+// storage usage, changing roles and quotas, suspending and deleting them,
+// and creating the first admin.
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrInvalidRole  = errors.New("role must be user or admin")
+	ErrInvalidQuota = errors.New("quota must not be negative")
+	ErrEmailTaken   = errors.New("a user with that email already exists")
+	// ErrSelf is returned when admins try to demote, suspend or delete
+	// themselves.
+	ErrSelf = errors.New("admins cannot demote, suspend or delete themselves")
+	// ErrLastAdmin keeps at least one active admin.
+	ErrLastAdmin = errors.New("the last active admin cannot be demoted, suspended or deleted")
+	ErrOrgOwner  = errors.New("the user owns an organization; transfer ownership first")
+)
+
+// DefaultQuota is the storage quota of users without one of their own
+// (STORAGE_QUOTA_BYTES, default 10MB).
+func DefaultQuota() int64 {
+	quota, err := strconv.ParseInt(os.Getenv("STORAGE_QUOTA_BYTES"), 10, 64)
+	if err != nil || quota < 0 {
+		return 10485760 // 10MB
+	}
+	return quota
+}
+
+// User is an account as admins see it.
+type User struct {
+	ID          string     `db:"id"`
+	Email       string     `db:"email"`
+	Role        string     `db:"role"`
+	CreatedAt   time.Time  `db:"created_at"`
+	SuspendedAt *time.Time `db:"suspended_at"`
+	// QuotaBytes is the user's own quota; nil uses DefaultQuota.
+	QuotaBytes *int64 `db:"quota_bytes"`
+	// UsedBytes and FileCount cover the user's own files (not those of
+	// organizations), including the trash and earlier versions.
+	UsedBytes int64 `db:"used_bytes"`
+	FileCount int   `db:"file_count"`
+}
+
+// Quota returns the quota in effect for the user.
+func (u *User) Quota() int64 {
+	if u.QuotaBytes != nil {
+		return *u.QuotaBytes
+	}
+	return DefaultQuota()
+}
+
+// Filter narrows List; nil fields match everything.
+type Filter struct {
+	// Email matches part of the address, ignoring case.
+	Email     *string
+	Role      *string
+	Suspended *bool
+}
+
+const userColumns = `u.id, u.email, u.role, u.created_at, u.suspended_at, u.quota_bytes,
+	COALESCE((SELECT SUM(fo.size_bytes) FROM file_objects fo JOIN (
+		SELECT file_object_id FROM user_files WHERE user_id = u.id AND org_id IS NULL
+		UNION ALL
+		SELECT fv.file_object_id FROM file_versions fv JOIN user_files uf ON uf.id = fv.user_file_id
+		WHERE uf.user_id = u.id AND uf.org_id IS NULL
+	) refs ON refs.file_object_id = fo.id), 0) AS used_bytes,
+	(SELECT COUNT(*) FROM user_files WHERE user_id = u.id AND org_id IS NULL) AS file_count`
+
+// List returns a page of users matching f, oldest first, and the total.
+func List(ctx context.Context, db sqlx.QueryerContext, f Filter, limit, offset int) ([]User, int, error) {
+	var where []string
+	var args []any
+	if f.Email != nil {
+		args = append(args, "%"+strings.ToLower(*f.Email)+"%")
+		where = append(where, fmt.Sprintf("lower(u.email) LIKE $%d", len(args)))
+	}
+	if f.Role != nil {
+		args = append(args, *f.Role)
+		where = append(where, fmt.Sprintf("u.role = $%d", len(args)))
+	}
+	if f.Suspended != nil {
+		args = append(args, *f.Suspended)
+		where = append(where, fmt.Sprintf("(u.suspended_at IS NOT NULL) = $%d", len(args)))
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = "WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := sqlx.GetContext(ctx, db, &total, "SELECT COUNT(*) FROM users u "+cond, args...); err != nil {
+		return nil, 0, fmt.Errorf("count users: %w", err)
+	}
+	users := []User{}
+	args = append(args, limit, offset)
+	err := sqlx.SelectContext(ctx, db, &users, fmt.Sprintf(
+		"SELECT %s FROM users u %s ORDER BY u.created_at, u.id LIMIT $%d OFFSET $%d",
+		userColumns, cond, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("list users: %w", err)
+	}
+	return users, total, nil
+}
+
+// Get returns one user.
+func Get(ctx context.Context, db sqlx.QueryerContext, userID string) (*User, error) {
+	var u User
+	err := sqlx.GetContext(ctx, db, &u, "SELECT "+userColumns+" FROM users u WHERE u.id = $1", userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+	return &u, nil
+}
+
+// SetRole changes a user's role (user or admin). actorID is the admin doing
+// it, who cannot demote themselves; the last active admin stays one.
+func SetRole(ctx context.Context, db *sqlx.DB, actorID, userID, role string) error {
+	if role != auth.RoleUser && role != auth.RoleAdmin {
+		return ErrInvalidRole
+	}
+	return change(ctx, db, actorID, userID, role != auth.RoleAdmin, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET role = $2 WHERE id = $1", userID, role); err != nil {
+			return fmt.Errorf("set role: %w", err)
+		}
+		return nil
+	})
+}
+
+// Suspend stops a user from logging in and signs them out everywhere; their
+// personal access tokens stop working until they are reactivated. Their
+// files are kept.
+func Suspend(ctx context.Context, db *sqlx.DB, actorID, userID string) error {
+	return change(ctx, db, actorID, userID, true, func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE users SET suspended_at = COALESCE(suspended_at, now()) WHERE id = $1", userID)
+		if err != nil {
+			return fmt.Errorf("suspend user: %w", err)
+		}
+		_, err = tx.ExecContext(ctx, `
+UPDATE sessions SET revoked_at = now(), revoke_reason = 'suspended'
+WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+		if err != nil {
+			return fmt.Errorf("revoke sessions: %w", err)
+		}
+		return nil
+	})
+}
+
+// Reactivate lifts a suspension. The user has to log in again.
+func Reactivate(ctx context.Context, db *sqlx.DB, userID string) error {
+	res, err := db.ExecContext(ctx, "UPDATE users SET suspended_at = NULL WHERE id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("reactivate user: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetQuota sets a user's storage quota in bytes; nil goes back to
+// DefaultQuota. Files already stored are kept even when over the new quota.
+func SetQuota(ctx context.Context, db *sqlx.DB, userID string, quota *int64) error {
+	if quota != nil && *quota < 0 {
+		return ErrInvalidQuota
+	}
+	res, err := db.ExecContext(ctx, "UPDATE users SET quota_bytes = $2 WHERE id = $1", userID, quota)
+	if err != nil {
+		return fmt.Errorf("set quota: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// Delete deletes a user and their files. The references of their files and
+// versions are dropped (see storage.DetachUserContent), so content nobody
+// else has goes pending GC. Org files they uploaded stay in the org and pass
+// to its owner; owners of an org have to transfer it first. It returns how
+// many files were deleted.
+func Delete(ctx context.Context, db *sqlx.DB, actorID, userID string) (int, error) {
+	var files int
+	err := change(ctx, db, actorID, userID, true, func(tx *sqlx.Tx) error {
+		var owner bool
+		err := tx.GetContext(ctx, &owner, "SELECT EXISTS (SELECT 1 FROM org_members WHERE user_id = $1 AND role = 'owner')", userID)
+		if err != nil {
+			return fmt.Errorf("check organizations: %w", err)
+		}
+		if owner {
+			return ErrOrgOwner
+		}
+		_, err = tx.ExecContext(ctx, `
+UPDATE user_files uf SET user_id = m.user_id FROM org_members m
+WHERE uf.user_id = $1 AND m.org_id = uf.org_id AND m.role = 'owner'`, userID)
+		if err != nil {
+			return fmt.Errorf("reassign org files: %w", err)
+		}
+		if files, err = storage.DetachUserContent(ctx, tx, userID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", userID); err != nil {
+			return fmt.Errorf("delete user: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return files, nil
+}
+
+// change runs fn in a transaction with the user's row locked. If demotes is
+// set, fn takes away the user's admin rights, which admins cannot do to
+// themselves or to the last active admin.
+func change(ctx context.Context, db *sqlx.DB, actorID, userID string, demotes bool, fn func(tx *sqlx.Tx) error) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if demotes {
+		// lock the active admins first, so concurrent demotions cannot
+		// remove the last two at once
+		var admins []string
+		err := tx.SelectContext(ctx, &admins,
+			"SELECT id FROM users WHERE role = 'admin' AND suspended_at IS NULL ORDER BY id FOR UPDATE")
+		if err != nil {
+			return fmt.Errorf("lock admins: %w", err)
+		}
+		for _, id := range admins {
+			if id != userID {
+				continue
+			}
+			if userID == actorID {
+				return ErrSelf
+			}
+			if len(admins) == 1 {
+				return ErrLastAdmin
+			}
+		}
+	}
+
+	var exists bool
+	if err := tx.GetContext(ctx, &exists, "SELECT true FROM users WHERE id = $1 FOR UPDATE", userID); errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	} else if err != nil {
+		return fmt.Errorf("lock user: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CreateAdmin creates an admin account with a verified email address, for
+// bootstrapping a deployment. The password must meet the policy (see
+// auth.ValidatePassword).
+func CreateAdmin(ctx context.Context, db *sqlx.DB, email, password string) (string, error) {
+	email, err := auth.ValidateEmail(email)
+	if err != nil {
+		return "", err
+	}
+	if err := auth.ValidatePassword(password, email); err != nil {
+		return "", err
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return "", err
+	}
+	var id string
+	err = db.GetContext(ctx, &id, `
+INSERT INTO users (email, password_hash, role, email_verified_at) VALUES ($1, $2, 'admin', now())
+RETURNING id`, email, hash)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return "", ErrEmailTaken
+	}
+	if err != nil {
+		return "", fmt.Errorf("create admin: %w", err)
+	}
+	return id, nil
+}
+
+// Promote makes the user with email an admin, for bootstrapping with an
+// account that already exists.
+func Promote(ctx context.Context, db *sqlx.DB, email string) (string, error) {
+	var id string
+	err := db.GetContext(ctx, &id, "UPDATE users SET role = 'admin' WHERE email = $1 RETURNING id", strings.TrimSpace(email))
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("promote user: %w", err)
+	}
+	return id, nil
+}
@@ -0,0 +1,158 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/rishit911/file_vault_proj-backend/internal/auth"
+	"github.com/rishit911/file_vault_proj-backend/internal/storage"
+	"github.com/rishit911/file_vault_proj-backend/internal/testdb"
+)
+
+func createTestUser(t *testing.T, db *sqlx.DB, role string) string {
+	t.Helper()
+	var id string
+	err := db.Get(&id, `
+INSERT INTO users (email, password_hash, role, email_verified_at)
+VALUES ('users-' || gen_random_uuid() || '@example.com', 'x', $1, now()) RETURNING id`, role)
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM user_files WHERE user_id=$1", id)
+		db.Exec("DELETE FROM users WHERE id=$1", id)
+	})
+	return id
+}
+
+func refCount(t *testing.T, db *sqlx.DB, foID string) (int, bool) {
+	t.Helper()
+	var row struct {
+		RefCount int  `db:"ref_count"`
+		Pending  bool `db:"pending"`
+	}
+	if err := db.Get(&row, "SELECT ref_count, pending_gc_at IS NOT NULL AS pending FROM file_objects WHERE id=$1", foID); err != nil {
+		t.Fatal(err)
+	}
+	return row.RefCount, row.Pending
+}
+
+func TestDeleteUserDropsReferences(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	admin := createTestUser(t, db, auth.RoleAdmin)
+	alice := createTestUser(t, db, auth.RoleUser)
+	bob := createTestUser(t, db, auth.RoleUser)
+
+	var nonce string
+	if err := db.Get(&nonce, "SELECT gen_random_uuid()::text"); err != nil {
+		t.Fatal(err)
+	}
+	attach := func(userID, name, hash string, replace *string) *storage.AttachResult {
+		t.Helper()
+		res, err := storage.AttachContent(ctx, db, storage.AttachInput{
+			UserID: userID, Filename: name, Hash: hash, ReplaceUserFileID: replace,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// registrations record no size; stand in for uploaded content
+		if _, err := db.Exec("UPDATE file_objects SET size_bytes = 10 WHERE id = $1", res.FileObject.ID); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	// shared content, plus a file of alice's with an earlier version
+	shared := attach(alice, "shared.txt", "shared-"+nonce, nil)
+	attach(bob, "shared.txt", "shared-"+nonce, nil)
+	first := attach(alice, "report.txt", "v1-"+nonce, nil)
+	attach(alice, "report.txt", "v2-"+nonce, &first.UserFileID)
+
+	u, err := Get(ctx, db, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.FileCount != 2 || u.UsedBytes != 30 {
+		t.Errorf("alice has %d files, %d bytes; want 2, 30", u.FileCount, u.UsedBytes)
+	}
+
+	if n, err := Delete(ctx, db, admin, alice); err != nil || n != 2 {
+		t.Fatalf("Delete = %d, %v", n, err)
+	}
+	if n, pending := refCount(t, db, shared.FileObject.ID); n != 1 || pending {
+		t.Errorf("shared object: ref_count %d, pending %v; want 1, false", n, pending)
+	}
+	if n, pending := refCount(t, db, first.FileObject.ID); n != 0 || !pending {
+		t.Errorf("earlier version: ref_count %d, pending %v; want 0, true", n, pending)
+	}
+	if _, err := Get(ctx, db, alice); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Get deleted user: err = %v", err)
+	}
+}
+
+func TestSuspendAndRoles(t *testing.T) {
+	db := testdb.Open(t)
+	ctx := context.Background()
+	admin := createTestUser(t, db, auth.RoleAdmin)
+	user := createTestUser(t, db, auth.RoleUser)
+
+	if err := Suspend(ctx, db, admin, admin); !errors.Is(err, ErrSelf) {
+		t.Errorf("suspending oneself: err = %v, want ErrSelf", err)
+	}
+	if err := SetRole(ctx, db, admin, user, "owner"); !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("SetRole(owner): err = %v", err)
+	}
+
+	tokens, err := auth.StartSession(ctx, db, user, auth.Device{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Suspend(ctx, db, admin, user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Authenticate(ctx, db, tokens.AccessToken, ""); err == nil {
+		t.Error("access token of a suspended user accepted")
+	}
+	if _, err := auth.StartSession(ctx, db, user, auth.Device{}); !errors.Is(err, auth.ErrAccountSuspended) {
+		t.Errorf("StartSession for a suspended user: err = %v", err)
+	}
+	if err := Reactivate(ctx, db, user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.StartSession(ctx, db, user, auth.Device{}); err != nil {
+		t.Errorf("StartSession after reactivation: %v", err)
+	}
+
+	quota := int64(1 << 20)
+	if err := SetQuota(ctx, db, user, &quota); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetRole(ctx, db, admin, user, auth.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	u, err := Get(ctx, db, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Role != auth.RoleAdmin || u.Quota() != quota || u.SuspendedAt != nil {
+		t.Errorf("user = %+v", u)
+	}
+	// now an admin too, so the first admin can be demoted by them
+	if err := SetRole(ctx, db, user, admin, auth.RoleUser); err != nil {
+		t.Errorf("demoting another admin: %v", err)
+	}
+}
+
+func TestDefaultQuota(t *testing.T) {
+	t.Setenv("STORAGE_QUOTA_BYTES", "")
+	if got := DefaultQuota(); got != 10485760 {
+		t.Errorf("DefaultQuota() = %d, want 10MB", got)
+	}
+	t.Setenv("STORAGE_QUOTA_BYTES", "2048")
+	if got := DefaultQuota(); got != 2048 {
+		t.Errorf("DefaultQuota() = %d, want 2048", got)
+	}
+}
@@ -0,0 +1,5 @@
+-- 000002_blob_storage_keys.down.sql
+
+UPDATE file_objects
+SET storage_path = '/data/files/' || storage_path
+WHERE storage_path NOT LIKE '/%';
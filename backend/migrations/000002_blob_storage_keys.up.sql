@@ -0,0 +1,7 @@
+-- 000002_blob_storage_keys.up.sql
+
+-- file_objects.storage_path now holds a backend-relative blob key
+-- ("<hash[:2]>/<hash>") instead of an absolute filesystem path.
+UPDATE file_objects
+SET storage_path = substr(hash, 1, 2) || '/' || hash
+WHERE storage_path LIKE '/%';
@@ -0,0 +1,3 @@
+-- 000003_create_tus_uploads.down.sql
+
+DROP TABLE IF EXISTS tus_uploads;
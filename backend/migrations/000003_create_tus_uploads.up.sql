@@ -0,0 +1,19 @@
+-- 000003_create_tus_uploads.up.sql
+
+-- In-progress tus resumable uploads. The bytes received so far live in the
+-- blob store's tmp area as tus-<id>.
+CREATE TABLE IF NOT EXISTS tus_uploads (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    filename TEXT NOT NULL,
+    mime_type TEXT NOT NULL DEFAULT '',
+    upload_length BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    metadata TEXT NOT NULL DEFAULT '',
+    user_file_id UUID, -- set once the upload has been ingested
+    created_at TIMESTAMPTZ DEFAULT now(),
+    completed_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_tus_uploads_expires_at ON tus_uploads(expires_at);
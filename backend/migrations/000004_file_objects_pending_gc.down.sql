@@ -0,0 +1,4 @@
+-- 000004_file_objects_pending_gc.down.sql
+
+DROP INDEX IF EXISTS idx_file_objects_pending_gc_at;
+ALTER TABLE file_objects DROP COLUMN IF EXISTS pending_gc_at;
@@ -0,0 +1,9 @@
+-- 000004_file_objects_pending_gc.up.sql
+
+-- Objects whose last reference is dropped are kept for a grace period before
+-- the sweeper deletes them; pending_gc_at is when that happened. AttachContent
+-- clears it when the same content is uploaded again.
+ALTER TABLE file_objects ADD COLUMN IF NOT EXISTS pending_gc_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_file_objects_pending_gc_at ON file_objects(pending_gc_at)
+    WHERE pending_gc_at IS NOT NULL;
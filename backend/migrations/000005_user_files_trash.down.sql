@@ -0,0 +1,4 @@
+-- 000005_user_files_trash.down.sql
+
+DROP INDEX IF EXISTS idx_user_files_deleted_at;
+ALTER TABLE user_files DROP COLUMN IF EXISTS deleted_at;
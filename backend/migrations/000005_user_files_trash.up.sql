@@ -0,0 +1,8 @@
+-- 000005_user_files_trash.up.sql
+
+-- Deleted files go to the trash first: deleted_at is set and the row keeps
+-- its reference (and counts against quota) until it is purged.
+ALTER TABLE user_files ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_user_files_deleted_at ON user_files(deleted_at)
+    WHERE deleted_at IS NOT NULL;
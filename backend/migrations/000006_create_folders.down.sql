@@ -0,0 +1,6 @@
+-- 000006_create_folders.down.sql
+
+DROP INDEX IF EXISTS idx_user_files_unique_name;
+DROP INDEX IF EXISTS idx_user_files_folder_id;
+ALTER TABLE user_files DROP COLUMN IF EXISTS folder_id;
+DROP TABLE IF EXISTS folders;
@@ -0,0 +1,37 @@
+-- 000006_create_folders.up.sql
+
+-- Per-user folder tree. A NULL parent_id (or folder_id on user_files) is the
+-- user's root folder.
+CREATE TABLE IF NOT EXISTS folders (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    parent_id UUID REFERENCES folders(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_folders_parent_id ON folders(parent_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_folders_unique_name
+    ON folders(user_id, COALESCE(parent_id, '00000000-0000-0000-0000-000000000000'), name);
+
+-- Deleting a folder trashes its files first; trashed files whose folder is
+-- gone are restored to the root.
+ALTER TABLE user_files ADD COLUMN IF NOT EXISTS folder_id UUID REFERENCES folders(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_user_files_folder_id ON user_files(folder_id);
+
+-- Live filenames are unique per folder. Existing duplicates (everything was in
+-- the root until now) get a " (n)" suffix before the extension.
+WITH dup AS (
+    SELECT id, row_number() OVER (PARTITION BY user_id, filename ORDER BY uploaded_at, id) - 1 AS n
+    FROM user_files
+    WHERE deleted_at IS NULL AND folder_id IS NULL
+)
+UPDATE user_files uf
+SET filename = regexp_replace(uf.filename, '^(.*?)(\.[^.]*)?$', '\1 (' || dup.n || ')\2')
+FROM dup
+WHERE uf.id = dup.id AND dup.n > 0;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_files_unique_name
+    ON user_files(user_id, COALESCE(folder_id, '00000000-0000-0000-0000-000000000000'), filename)
+    WHERE deleted_at IS NULL;
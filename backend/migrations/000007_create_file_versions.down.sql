@@ -0,0 +1,5 @@
+-- 000007_create_file_versions.down.sql
+
+ALTER TABLE users DROP COLUMN IF EXISTS max_file_versions;
+DROP TABLE IF EXISTS file_versions;
+ALTER TABLE user_files DROP COLUMN IF EXISTS version;
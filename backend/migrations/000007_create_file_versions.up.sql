@@ -0,0 +1,21 @@
+-- 000007_create_file_versions.up.sql
+
+-- user_files points at the current version; earlier versions live in
+-- file_versions. Each row in either table holds one file_objects reference.
+ALTER TABLE user_files ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS file_versions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_file_id UUID NOT NULL REFERENCES user_files(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    file_object_id UUID NOT NULL REFERENCES file_objects(id) ON DELETE RESTRICT,
+    filename TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    UNIQUE (user_file_id, version)
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_versions_file_object_id ON file_versions(file_object_id);
+
+-- how many versions (including the current one) to keep per file; NULL uses
+-- the server default (MAX_FILE_VERSIONS)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS max_file_versions INT;
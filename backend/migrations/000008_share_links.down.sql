@@ -0,0 +1,7 @@
+-- 000008_share_links.down.sql
+
+DROP INDEX IF EXISTS idx_shares_user_file_id;
+DROP INDEX IF EXISTS idx_shares_public_link;
+ALTER TABLE shares ALTER COLUMN download_count DROP NOT NULL;
+ALTER TABLE shares DROP COLUMN IF EXISTS password_hash;
+ALTER TABLE shares DROP COLUMN IF EXISTS max_downloads;
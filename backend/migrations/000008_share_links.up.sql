@@ -0,0 +1,12 @@
+-- 000008_share_links.up.sql
+
+-- shares.public_link holds the random token of a /s/{token} link.
+ALTER TABLE shares ADD COLUMN IF NOT EXISTS max_downloads INT;
+-- bcrypt hash; NULL for links without a password
+ALTER TABLE shares ADD COLUMN IF NOT EXISTS password_hash TEXT;
+
+UPDATE shares SET download_count = 0 WHERE download_count IS NULL;
+ALTER TABLE shares ALTER COLUMN download_count SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_shares_public_link ON shares(public_link);
+CREATE INDEX IF NOT EXISTS idx_shares_user_file_id ON shares(user_file_id);
@@ -0,0 +1,15 @@
+-- 000009_download_audit.down.sql
+
+DROP INDEX IF EXISTS idx_downloads_downloaded_at;
+DROP INDEX IF EXISTS idx_downloads_user_file_id;
+ALTER TABLE downloads DROP COLUMN IF EXISTS completed;
+ALTER TABLE downloads DROP COLUMN IF EXISTS bytes_sent;
+ALTER TABLE downloads DROP COLUMN IF EXISTS range_end;
+ALTER TABLE downloads DROP COLUMN IF EXISTS range_start;
+ALTER TABLE downloads DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE downloads DROP COLUMN IF EXISTS source;
+ALTER TABLE downloads DROP COLUMN IF EXISTS share_id;
+ALTER TABLE downloads DROP COLUMN IF EXISTS user_id;
+ALTER TABLE downloads DROP CONSTRAINT IF EXISTS downloads_user_file_id_fkey;
+ALTER TABLE downloads ADD CONSTRAINT downloads_user_file_id_fkey
+    FOREIGN KEY (user_file_id) REFERENCES user_files(id);
@@ -0,0 +1,23 @@
+-- 000009_download_audit.up.sql
+
+-- One row per download (owner, share link or admin). The original foreign
+-- key had no ON DELETE action, so purging a downloaded file failed; the
+-- audit rows now go with the file.
+ALTER TABLE downloads DROP CONSTRAINT IF EXISTS downloads_user_file_id_fkey;
+ALTER TABLE downloads ADD CONSTRAINT downloads_user_file_id_fkey
+    FOREIGN KEY (user_file_id) REFERENCES user_files(id) ON DELETE CASCADE;
+
+-- NULL for anonymous share link downloads
+ALTER TABLE downloads ADD COLUMN IF NOT EXISTS user_id UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE downloads ADD COLUMN IF NOT EXISTS share_id UUID REFERENCES shares(id) ON DELETE SET NULL;
+-- owner | share | admin
+ALTER TABLE downloads ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT 'owner';
+ALTER TABLE downloads ADD COLUMN IF NOT EXISTS user_agent TEXT;
+-- byte range served (inclusive) and bytes actually written
+ALTER TABLE downloads ADD COLUMN IF NOT EXISTS range_start BIGINT;
+ALTER TABLE downloads ADD COLUMN IF NOT EXISTS range_end BIGINT;
+ALTER TABLE downloads ADD COLUMN IF NOT EXISTS bytes_sent BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE downloads ADD COLUMN IF NOT EXISTS completed BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS idx_downloads_user_file_id ON downloads(user_file_id, downloaded_at DESC);
+CREATE INDEX IF NOT EXISTS idx_downloads_downloaded_at ON downloads(downloaded_at);
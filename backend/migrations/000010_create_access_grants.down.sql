@@ -0,0 +1,3 @@
+-- 000010_create_access_grants.down.sql
+
+DROP TABLE IF EXISTS access_grants;
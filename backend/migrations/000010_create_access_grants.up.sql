@@ -0,0 +1,20 @@
+-- 000010_create_access_grants.up.sql
+
+-- Per-user sharing: a grant gives one user a role on a file or on a folder
+-- (and everything below it). Owners are not stored here.
+CREATE TABLE IF NOT EXISTS access_grants (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_file_id UUID REFERENCES user_files(id) ON DELETE CASCADE,
+    folder_id UUID REFERENCES folders(id) ON DELETE CASCADE,
+    grantee_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL CHECK (role IN ('viewer', 'editor', 'co-owner')),
+    granted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK ((user_file_id IS NULL) <> (folder_id IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_access_grants_file_grantee ON access_grants(user_file_id, grantee_id)
+    WHERE user_file_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_access_grants_folder_grantee ON access_grants(folder_id, grantee_id)
+    WHERE folder_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_access_grants_grantee_id ON access_grants(grantee_id);
@@ -0,0 +1,11 @@
+-- 000011_create_organizations.down.sql
+
+DROP INDEX IF EXISTS idx_user_files_unique_name;
+DROP INDEX IF EXISTS idx_user_files_org_id;
+ALTER TABLE user_files DROP CONSTRAINT IF EXISTS user_files_org_root;
+ALTER TABLE user_files DROP COLUMN IF EXISTS org_id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_files_unique_name
+    ON user_files(user_id, COALESCE(folder_id, '00000000-0000-0000-0000-000000000000'), filename)
+    WHERE deleted_at IS NULL;
+DROP TABLE IF EXISTS org_members;
+DROP TABLE IF EXISTS organizations;
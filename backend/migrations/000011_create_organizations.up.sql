@@ -0,0 +1,35 @@
+-- 000011_create_organizations.up.sql
+
+-- Organizations pool storage between their members. An org file is a
+-- user_files row with org_id set: user_id is still the uploader, but every
+-- member can see it and it counts against the org's pooled quota instead of
+-- the uploader's own.
+CREATE TABLE IF NOT EXISTS organizations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS org_members (
+    org_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL CHECK (role IN ('owner', 'admin', 'member')),
+    joined_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_org_members_one_owner ON org_members(org_id) WHERE role = 'owner';
+CREATE INDEX IF NOT EXISTS idx_org_members_user_id ON org_members(user_id);
+
+-- org files live at the org root; folders are per user
+ALTER TABLE user_files ADD COLUMN IF NOT EXISTS org_id UUID REFERENCES organizations(id) ON DELETE RESTRICT;
+ALTER TABLE user_files DROP CONSTRAINT IF EXISTS user_files_org_root;
+ALTER TABLE user_files ADD CONSTRAINT user_files_org_root CHECK (org_id IS NULL OR folder_id IS NULL);
+
+CREATE INDEX IF NOT EXISTS idx_user_files_org_id ON user_files(org_id) WHERE org_id IS NOT NULL;
+
+-- live filenames are unique per org instead of per uploader for org files
+DROP INDEX IF EXISTS idx_user_files_unique_name;
+CREATE UNIQUE INDEX idx_user_files_unique_name
+    ON user_files(COALESCE(org_id, user_id), COALESCE(folder_id, '00000000-0000-0000-0000-000000000000'), filename)
+    WHERE deleted_at IS NULL;
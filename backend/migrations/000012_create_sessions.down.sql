@@ -0,0 +1,3 @@
+-- 000012_create_sessions.down.sql
+
+DROP TABLE IF EXISTS sessions;
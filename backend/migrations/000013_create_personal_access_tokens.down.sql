@@ -0,0 +1,3 @@
+-- 000013_create_personal_access_tokens.down.sql
+
+DROP TABLE IF EXISTS personal_access_tokens;
@@ -0,0 +1,22 @@
+-- 000013_create_personal_access_tokens.up.sql
+
+-- Long-lived tokens for automation. Only a SHA-256 hash of the token is
+-- stored; prefix is its first characters, to tell tokens apart in listings.
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    prefix TEXT NOT NULL,
+    -- files:read | files:write | shares:manage | admin
+    scopes TEXT[] NOT NULL,
+    -- CIDR ranges the token may be used from; empty allows any address
+    allowed_ips TEXT[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    last_used_ip TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user_id ON personal_access_tokens(user_id);
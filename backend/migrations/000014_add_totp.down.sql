@@ -0,0 +1,8 @@
+-- 000014_add_totp.down.sql
+
+DROP TABLE IF EXISTS role_policies;
+DROP TABLE IF EXISTS recovery_codes;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_last_step;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled_at;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_pending_secret;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;
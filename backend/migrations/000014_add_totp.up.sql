@@ -0,0 +1,28 @@
+-- 000014_add_totp.up.sql
+
+-- TOTP (RFC 6238) second factor. The secret is needed to check codes, so it
+-- is stored as is; totp_pending_secret holds a secret until its first code is
+-- confirmed. totp_last_step is the time step of the last accepted code, so a
+-- code cannot be used twice.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_pending_secret TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT;
+
+-- single-use codes for when the authenticator is lost; only hashes are kept
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id);
+
+-- per-role security settings, changed by admins
+CREATE TABLE IF NOT EXISTS role_policies (
+    role TEXT PRIMARY KEY,
+    require_mfa BOOLEAN NOT NULL DEFAULT false,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
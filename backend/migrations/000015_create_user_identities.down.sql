@@ -0,0 +1,6 @@
+-- 000015_create_user_identities.down.sql
+
+DROP TABLE IF EXISTS oidc_login_states;
+DROP TABLE IF EXISTS user_identities;
+-- fails while single sign-on users without a password exist
+ALTER TABLE users ALTER COLUMN password_hash SET NOT NULL;
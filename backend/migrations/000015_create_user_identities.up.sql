@@ -0,0 +1,29 @@
+-- 000015_create_user_identities.up.sql
+
+-- Accounts provisioned through OpenID Connect single sign-on have no
+-- password.
+ALTER TABLE users ALTER COLUMN password_hash DROP NOT NULL;
+
+-- An identity at an OpenID provider, keyed by (issuer, subject) as the spec
+-- requires; emails can change and are only kept for reference.
+CREATE TABLE IF NOT EXISTS user_identities (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_login_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id);
+
+-- Logins in progress: the state parameter, and the nonce and PKCE verifier
+-- the callback needs. Each is used once.
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state TEXT PRIMARY KEY,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
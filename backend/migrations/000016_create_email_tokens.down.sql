@@ -0,0 +1,4 @@
+-- 000016_create_email_tokens.down.sql
+
+DROP TABLE IF EXISTS email_tokens;
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
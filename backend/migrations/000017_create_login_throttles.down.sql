@@ -0,0 +1,4 @@
+-- 000017_create_login_throttles.down.sql
+
+DROP TABLE IF EXISTS login_events;
+DROP TABLE IF EXISTS login_throttles;
@@ -0,0 +1,31 @@
+-- 000017_create_login_throttles.up.sql
+
+-- Failed password logins per account (key "email:<address>", whether or not
+-- the account exists) and per client address ("ip:<address>"). After a few
+-- failures further attempts wait with exponential backoff (locked_until);
+-- too many lock the account for a while.
+CREATE TABLE IF NOT EXISTS login_throttles (
+    key TEXT PRIMARY KEY,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until TIMESTAMPTZ,
+    -- true while the account is locked out, rather than backing off
+    lockout BOOLEAN NOT NULL DEFAULT false
+);
+
+-- Audit trail of logins: login_succeeded, login_failed, login_throttled,
+-- account_locked, account_unlocked. user_id is NULL for unknown emails;
+-- actor_id is the admin who unlocked an account.
+CREATE TABLE IF NOT EXISTS login_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    event TEXT NOT NULL,
+    email TEXT NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    actor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    ip TEXT,
+    user_agent TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_events_created_at ON login_events(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_login_events_user_id ON login_events(user_id, created_at DESC);
@@ -0,0 +1,5 @@
+-- 000018_user_admin.down.sql
+
+DROP INDEX IF EXISTS idx_users_role;
+ALTER TABLE users DROP COLUMN IF EXISTS quota_bytes;
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_at;
@@ -0,0 +1,13 @@
+-- 000018_user_admin.up.sql
+
+-- Suspended users cannot log in or use their sessions and tokens; their
+-- files are kept. Reactivating clears suspended_at.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMPTZ;
+
+-- per-user storage quota in bytes; NULL uses the server default
+-- (STORAGE_QUOTA_BYTES)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS quota_bytes BIGINT;
+
+CREATE INDEX IF NOT EXISTS idx_users_role ON users(role);
+
+-- sessions revoked by a suspension have revoke_reason 'suspended'
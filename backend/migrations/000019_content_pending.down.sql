@@ -0,0 +1,4 @@
+-- 000019_content_pending.down.sql
+
+ALTER TABLE file_versions DROP COLUMN IF EXISTS content_pending;
+ALTER TABLE user_files DROP COLUMN IF EXISTS content_pending;
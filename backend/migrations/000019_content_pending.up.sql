@@ -0,0 +1,8 @@
+-- 000019_content_pending.up.sql
+
+-- A file registered by hash alone (POST /api/v1/files/register, GraphQL
+-- registerFile) only references content; its owner has not shown they have
+-- it. Its content cannot be downloaded until the owner uploads the same bytes,
+-- which clears content_pending. Versions keep the flag of the content they hold.
+ALTER TABLE user_files ADD COLUMN IF NOT EXISTS content_pending BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE file_versions ADD COLUMN IF NOT EXISTS content_pending BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,3 @@
+-- 000020_retired_refresh_tokens.down.sql
+
+DROP TABLE IF EXISTS retired_refresh_tokens;
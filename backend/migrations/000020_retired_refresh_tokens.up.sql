@@ -0,0 +1,12 @@
+-- 000020_retired_refresh_tokens.up.sql
+
+-- Hashes of the refresh tokens a session has rotated out. Only a token found
+-- here counts as reuse and revokes its session; any other wrong secret for a
+-- session id (which access tokens carry in their sid claim) is just rejected.
+CREATE TABLE IF NOT EXISTS retired_refresh_tokens (
+    token_hash TEXT PRIMARY KEY,
+    session_id UUID NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    retired_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_retired_refresh_tokens_session_id ON retired_refresh_tokens(session_id);
@@ -0,0 +1,4 @@
+-- 000021_org_invites.down.sql
+
+ALTER TABLE organizations DROP COLUMN IF EXISTS quota_bytes;
+DROP TABLE IF EXISTS org_invites;
@@ -0,0 +1,18 @@
+-- 000021_org_invites.up.sql
+
+-- Invitations to an organization. An invited user becomes a member only when
+-- they accept; declining or accepting deletes the row.
+CREATE TABLE IF NOT EXISTS org_invites (
+    org_id UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL CHECK (role IN ('admin', 'member')),
+    invited_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_invites_user_id ON org_invites(user_id);
+
+-- an org's own quota, set by an admin; NULL uses the server default
+-- (ORG_QUOTA_BYTES). Org storage no longer pools the members' quotas.
+ALTER TABLE organizations ADD COLUMN IF NOT EXISTS quota_bytes BIGINT;
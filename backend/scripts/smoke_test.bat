@@ -20,8 +20,16 @@ echo Login...
 for /f "tokens=*" %%i in ('curl -s -X POST "%BASE%/api/v1/auth/login" -H "Content-Type: application/json" -d "{\"email\":\"smoke@example.com\",\"password\":\"SmokePass123\"}"') do set LOGIN_RESPONSE=%%i
 echo Login Response: %LOGIN_RESPONSE%
 
+REM Extract token the same way
+for /f "tokens=2 delims=:" %%a in ('echo %LOGIN_RESPONSE% ^| findstr /r "\"token\""') do (
+    set TOKEN=%%a
+    set TOKEN=!TOKEN:"=!
+    set TOKEN=!TOKEN:}=!
+    set TOKEN=!TOKEN: =!
+)
+
 echo Register a file...
-curl -s -X POST "%BASE%/api/v1/files/register" -H "Content-Type: application/json" -H "X-User-Id: %USER_ID%" -d "{\"filename\":\"smoke.txt\",\"hash\":\"deadbeef\",\"size_bytes\":100,\"mime_type\":\"text/plain\"}"
+curl -s -X POST "%BASE%/api/v1/files/register" -H "Content-Type: application/json" -H "Authorization: Bearer %TOKEN%" -d "{\"filename\":\"smoke.txt\",\"hash\":\"04d588cb41b8022d1233924f0fe8280d9e2bb92cdc81658eb102ef9c42fc9452\",\"size_bytes\":100,\"mime_type\":\"text/plain\"}"
 
 echo.
 echo Done
\ No newline at end of file
@@ -13,9 +13,9 @@ Write-Host "Token: $TOKEN"
 Write-Host "Register a file..."
 $headers = @{
     "Content-Type" = "application/json"
-    "X-User-Id" = $USER_ID
+    "Authorization" = "Bearer $TOKEN"
 }
-$fileResponse = Invoke-RestMethod -Uri "$BASE/api/v1/files/register" -Method POST -Headers $headers -Body '{"filename":"smoke.txt","hash":"deadbeef","size_bytes":100,"mime_type":"text/plain"}'
+$fileResponse = Invoke-RestMethod -Uri "$BASE/api/v1/files/register" -Method POST -Headers $headers -Body '{"filename":"smoke.txt","hash":"04d588cb41b8022d1233924f0fe8280d9e2bb92cdc81658eb102ef9c42fc9452","size_bytes":100,"mime_type":"text/plain"}'
 Write-Host "File Response:" ($fileResponse | ConvertTo-Json)
 
 Write-Host "Done"
\ No newline at end of file